@@ -0,0 +1,188 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// A command is a go2proto subcommand. The commands analysing Go packages share the global
+// flag set, setting the mode they run in before it is parsed; flags selects the flags their
+// help lists, as flag group titles or flag names. Commands with their own arguments set run
+// instead.
+type command struct {
+	name    string
+	args    string
+	summary string
+	flags   []string
+	mode    func()
+	run     func(args []string) error
+}
+
+// commands lists the subcommands; the first is the default when no command is given.
+var commands = []*command{
+	{
+		name: "generate", args: "[flags]", summary: "Write the schema of the annotated Go types",
+		flags: []string{"Packages", "Schema", "Output", "Toolchain", "Logging"},
+		mode:  func() {},
+	},
+	{
+		name: "check", args: "[flags]", summary: "Fail with a diff if the generated files are out of date",
+		flags: []string{"Packages", "Schema", "Logging", "f", "out", "merge", "append", "source-comments", "eol", "reproducible"},
+		mode:  func() { *checkMode = true },
+	},
+	{
+		name: "diff", args: "[flags]", summary: "Print how generating would change the output files, without writing them",
+		flags: []string{"Packages", "Schema", "Logging", "f", "out", "merge", "append", "source-comments", "reproducible"},
+		mode:  func() { diffMode = true },
+	},
+	{
+		name: "list", args: "[flags]", summary: "Print the annotated types found, without writing anything",
+		flags: []string{"Packages", "Schema", "Logging"},
+		mode:  func() { *listMode = true },
+	},
+	{
+		name: "breaking", args: "[flags]", summary: "Fail on wire-breaking changes to the generated messages",
+		flags: []string{"Packages", "Schema", "Logging", "f", "out"},
+		mode:  func() { breakingMode = true },
+	},
+	{
+		name: "check-compiled", args: "[flags]", summary: "Compare the generated messages with the compiled .pb.go types",
+		flags: []string{"Packages", "Schema", "Logging", "f", "out", "pb-dir"},
+		mode:  func() { compiledMode = true },
+	},
+	{
+		name: "init", args: "[flags]", summary: "Start a buf module with the generated messages",
+		flags: []string{"Packages", "Schema", "Logging", "f", "connect"},
+		mode:  func() { initMode = true },
+	},
+	{
+		name: "doctor", args: "[flags]", summary: "Check that the packages load, report problems with the annotated types and find the external tools",
+		flags: []string{"Packages", "Schema", "Logging"},
+		mode:  func() { doctorMode = true },
+	},
+	{
+		name: "proto2go", args: "[-o file.go] [-package name] file.proto", summary: "Convert a .proto file into annotated Go structs",
+		run: runProto2Go,
+	},
+	{
+		name: "completion", args: "bash|zsh|fish", summary: "Print a shell completion script",
+	},
+}
+
+func init() {
+	// Set here, as the completion scripts list the commands.
+	lookupCommand("completion").run = runCompletion
+}
+
+// commandAliases are former command names kept working.
+var commandAliases = map[string]string{"check-breaking": "breaking"}
+
+// flagGroups sorts the shared flags for the command help. The help of generate lists the
+// flags missing here under "Other flags".
+var flagGroups = []struct {
+	title string
+	flags []string
+}{
+	{"Packages", []string{"p", "filter", "tags", "goos", "goarch", "include-tests", "cgo", "cache-dir", "batch-size", "progress", "progress-interval"}},
+	{"Schema", []string{"t", "n", "proto-package", "package-version", "format", "duplicates", "message-naming", "field-naming", "enum-naming", "enum-value-naming", "non-ascii", "duration", "time", "field-mask", "any-strategy", "msg-prefix", "msg-suffix", "numbering", "optimize-for", "alphabetical",
+		"collapse-wrappers", "strict", "graphql-scalar"}},
+	{"Output", []string{"f", "out", "import", "import-public", "merge", "append", "force", "no-overwrite", "backup", "mode", "source-comments", "reproducible", "report", "graph", "plugin", "plugin-out",
+		"watch", "watch-interval"}},
+	{"Toolchain", []string{"validate", "validate-with", "run-protoc", "protoc-plugin", "run-buf-generate", "buf-template", "connect", "buf-push",
+		"registry-url", "registry-subject", "registry-compatibility"}},
+	{"Logging", []string{"v", "vv", "q", "log-file", "color", "diag-format", "diag-file", "cpuprofile", "memprofile", "trace", "version"}},
+}
+
+// lookupCommand returns the command named name, following aliases, or nil.
+func lookupCommand(name string) *command {
+	if alias, ok := commandAliases[name]; ok {
+		name = alias
+	}
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// parseCommand picks the command from the first argument, defaulting to generate, and
+// returns it with its arguments. "help [command]" prints the help and exits.
+func parseCommand(args []string) (*command, []string) {
+	if len(args) > 0 && args[0] == "help" {
+		cmd := commands[0]
+		if len(args) > 1 {
+			if cmd = lookupCommand(args[1]); cmd == nil {
+				fatalf("unknown command %q, run go2proto help for the list", args[1])
+			}
+		}
+		printCommandUsage(os.Stdout, cmd, flag.CommandLine)
+		os.Exit(exitOK)
+	}
+	if len(args) > 0 {
+		if cmd := lookupCommand(args[0]); cmd != nil {
+			return cmd, args[1:]
+		}
+	}
+	return commands[0], args
+}
+
+// printCommandUsage writes the help of cmd: its usage line, the flags it selects from fs
+// grouped as in flagGroups, and, for the default command, the list of commands.
+func printCommandUsage(w io.Writer, cmd *command, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "Usage: go2proto %s %s\n\n%s.\n", cmd.name, cmd.args, cmd.summary)
+	if cmd == commands[0] {
+		fmt.Fprintf(w, "\nCommands:\n")
+		for _, c := range commands {
+			fmt.Fprintf(w, "  %-15s %s\n", c.name, c.summary)
+		}
+		fmt.Fprintf(w, "\nWithout a command, go2proto runs generate. Run go2proto help <command> for the flags of a command.\n")
+	}
+	if cmd.run != nil {
+		return
+	}
+
+	grouped := make(map[string]bool)
+	selected := make(map[string]bool)
+	for _, name := range cmd.flags {
+		selected[name] = true
+	}
+	for _, group := range flagGroups {
+		var names []string
+		for _, name := range group.flags {
+			grouped[name] = true
+			if selected[group.title] || selected[name] {
+				names = append(names, name)
+			}
+		}
+		printFlags(w, group.title+" flags", names, fs)
+	}
+	var others []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if !grouped[f.Name] && (cmd == commands[0] || selected[f.Name]) {
+			others = append(others, f.Name)
+		}
+	})
+	printFlags(w, "Other flags", others, fs)
+}
+
+// printFlags writes the named flags of fs under title, formatted as by flag.PrintDefaults.
+func printFlags(w io.Writer, title string, names []string, fs *flag.FlagSet) {
+	group := flag.NewFlagSet("", flag.ContinueOnError)
+	group.SetOutput(w)
+	defined := 0
+	for _, name := range names {
+		if f := fs.Lookup(name); f != nil {
+			group.Var(f.Value, f.Name, f.Usage)
+			group.Lookup(f.Name).DefValue = f.DefValue
+			defined++
+		}
+	}
+	if defined == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n%s:\n", title)
+	group.PrintDefaults()
+}
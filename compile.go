@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/beam-cloud/go2proto/pkg/generator"
+)
+
+// protocCommand returns the protoc invocation compiling files with the given plugins, each
+// given as name=out where out is what protoc takes for --<name>_out (optionally
+// "options:dir"). Without plugins it runs protoc-gen-go beside the first file.
+func protocCommand(files []*generator.File, plugins []string) ([]string, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files to compile")
+	}
+	dir := filepath.Dir(files[0].Path)
+	if len(plugins) == 0 {
+		plugins = []string{"go=paths=source_relative:" + dir}
+	}
+
+	args := []string{"protoc", "-I", dir}
+	for _, spec := range plugins {
+		i := strings.Index(spec, "=")
+		if i <= 0 || i == len(spec)-1 {
+			return nil, fmt.Errorf("invalid -protoc-plugin %q, expected name=out", spec)
+		}
+		args = append(args, "--"+strings.Replace(spec[:i], "-", "_", -1)+"_out="+spec[i+1:])
+	}
+	for _, f := range files {
+		args = append(args, f.Path)
+	}
+	return args, nil
+}
+
+// bufGenerateCommand returns the buf generate invocation for the directory holding files.
+func bufGenerateCommand(files []*generator.File, template string) []string {
+	args := []string{"buf", "generate"}
+	if template != "" {
+		args = append(args, "--template", template)
+	}
+	return append(args, filepath.Dir(files[0].Path))
+}
+
+// runCompiler runs a protoc or buf command, returning its output on failure.
+func runCompiler(args []string) error {
+	verbosef("running %s", strings.Join(args, " "))
+	var out bytes.Buffer
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %w\n%s", strings.Join(args[:2], " "), err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/beam-cloud/go2proto/pkg/generator"
+)
+
+// completionShells are the shells runCompletion writes scripts for.
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// flagValues lists the values of the flags that take one of a fixed set.
+var flagValues = map[string][]string{
+	"any-strategy":      {generator.AnyStrategyAny, generator.AnyStrategyStruct, generator.AnyStrategyBytes},
+	"color":             {colorAuto, colorAlways, colorNever},
+	"diag-format":       {diagFormatText, diagFormatJSON, diagFormatSARIF},
+	"duplicates":        {generator.DuplicatesError, generator.DuplicatesPrefix},
+	"duration":          {generator.DurationNanos, generator.DurationMillis, generator.DurationWellKnown},
+	"eol":               {generator.LineEndingLF, generator.LineEndingCRLF},
+	"field-naming":      namingStyles,
+	"enum-naming":       namingStyles,
+	"message-naming":    namingStyles,
+	"enum-value-naming": namingStyles,
+	"non-ascii":         {generator.NonASCIIError, generator.NonASCIITransliterate},
+	"numbering":         {generator.NumberingOrder, generator.NumberingHash},
+	"optimize-for":      {generator.OptimizeSpeed, generator.OptimizeCodeSize, generator.OptimizeLiteRuntime},
+	"progress":          {"bar", "log"},
+	"time":              {generator.TimeTimestamp, generator.TimeUnixSeconds, generator.TimeUnixMillis},
+	"validate-with":     {"auto", "protoc", "buf"},
+}
+
+// namingStyles are the built-in styles of the naming flags.
+var namingStyles = []string{generator.NamingSnake, generator.NamingLowerCamel, generator.NamingCamel, generator.NamingScreamingSnake, generator.NamingOriginal}
+
+// dirFlags take a directory, and fileFlags a file.
+var (
+	dirFlags  = map[string]bool{"p": true, "cache-dir": true, "pb-dir": true, "plugin-out": true}
+	fileFlags = map[string]bool{"f": true, "buf-template": true, "cpuprofile": true, "diag-file": true, "graph": true,
+		"log-file": true, "memprofile": true, "report": true, "trace": true}
+)
+
+// runCompletion implements `go2proto completion bash|zsh|fish`.
+func runCompletion(args []string) error {
+	if len(args) != 1 {
+		return withExitCode(exitUsageError, fmt.Errorf("completion expects one shell: %s", strings.Join(completionShells, ", ")))
+	}
+	return writeCompletion(os.Stdout, args[0], flag.CommandLine)
+}
+
+// completionFlag is a flag as offered by the completion scripts.
+type completionFlag struct {
+	name        string
+	description string
+	bool        bool
+	values      []string
+}
+
+// completionFlags returns the flags of fs in name order, with the first sentence of their usage.
+func completionFlags(fs *flag.FlagSet) []completionFlag {
+	var result []completionFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		description, _, _ := strings.Cut(f.Usage, ". ")
+		cf := completionFlag{name: f.Name, description: strings.TrimSuffix(description, "."), values: flagValues[f.Name]}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			cf.bool = true
+		}
+		if f.Name == "format" {
+			for format := range formatExtensions {
+				cf.values = append(cf.values, format)
+			}
+			sort.Strings(cf.values)
+		}
+		result = append(result, cf)
+	})
+	return result
+}
+
+// writeCompletion writes the completion script for shell, covering the subcommands and the
+// flags of fs. Package flags complete directories as ./relative paths.
+func writeCompletion(w io.Writer, shell string, fs *flag.FlagSet) error {
+	flags := completionFlags(fs)
+	switch shell {
+	case "bash":
+		writeBashCompletion(w, flags)
+	case "zsh":
+		writeZshCompletion(w, flags)
+	case "fish":
+		writeFishCompletion(w, flags)
+	default:
+		return withExitCode(exitUsageError, errors.New("unknown shell "+shell+", expected "+strings.Join(completionShells, ", ")))
+	}
+	return nil
+}
+
+func writeBashCompletion(w io.Writer, flags []completionFlag) {
+	var flagNames, dirs, files, values, others []string
+	for _, f := range flags {
+		flagNames = append(flagNames, "-"+f.name)
+		switch {
+		case dirFlags[f.name]:
+			dirs = append(dirs, "-"+f.name)
+		case fileFlags[f.name]:
+			files = append(files, "-"+f.name)
+		case f.values != nil:
+			values = append(values, fmt.Sprintf("\t\t-%s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", f.name, strings.Join(f.values, " ")))
+		case !f.bool:
+			others = append(others, "-"+f.name)
+		}
+	}
+	var names []string
+	for _, c := range commands {
+		names = append(names, c.name)
+	}
+
+	fmt.Fprintf(w, `# bash completion for go2proto. Load it with: source <(go2proto completion bash)
+_go2proto_dirs() {
+	case "$cur" in
+		/*|.*) COMPREPLY=($(compgen -d -- "$cur")) ;;
+		*) COMPREPLY=($(compgen -d -- "$cur" | sed 's|^|./|')) ;;
+	esac
+}
+
+_go2proto() {
+	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"
+	case "$prev" in
+		%s) _go2proto_dirs; return ;;
+		%s) COMPREPLY=($(compgen -f -- "$cur")); return ;;
+%s		%s) return ;;
+	esac
+	if [[ $COMP_CWORD -eq 2 && "${COMP_WORDS[1]}" == completion ]]; then
+		COMPREPLY=($(compgen -W %q -- "$cur"))
+	elif [[ $COMP_CWORD -eq 1 && "$cur" != -* ]]; then
+		COMPREPLY=($(compgen -W %q -- "$cur"))
+	elif [[ "$cur" == -* ]]; then
+		COMPREPLY=($(compgen -W %q -- "$cur"))
+	fi
+}
+complete -o default -F _go2proto go2proto
+`, strings.Join(dirs, "|"), strings.Join(files, "|"), strings.Join(values, ""), strings.Join(others, "|"),
+		strings.Join(completionShells, " "), strings.Join(names, " "), strings.Join(flagNames, " "))
+}
+
+func writeZshCompletion(w io.Writer, flags []completionFlag) {
+	escape := strings.NewReplacer(`'`, `'\''`, `[`, `\[`, `]`, `\]`, `:`, `\:`)
+	fmt.Fprint(w, `#compdef go2proto
+# zsh completion for go2proto. Load it with: source <(go2proto completion zsh)
+
+_go2proto_dirs() {
+	local -a dirs
+	dirs=(${PREFIX}*(N-/))
+	[[ $PREFIX == (/|.)* ]] || dirs=(./${^dirs})
+	compadd -S / -- $dirs
+}
+
+_go2proto() {
+	local state
+	_arguments \
+`)
+	for _, f := range flags {
+		spec := fmt.Sprintf("-%s[%s]", f.name, escape.Replace(f.description))
+		switch {
+		case f.bool:
+		case dirFlags[f.name]:
+			spec += ":directory:_go2proto_dirs"
+		case fileFlags[f.name]:
+			spec += ":file:_files"
+		case f.values != nil:
+			spec += fmt.Sprintf(":%s:(%s)", f.name, strings.Join(f.values, " "))
+		default:
+			spec += ":" + f.name + ": "
+		}
+		// Go flags may be given more than once, the last one winning.
+		fmt.Fprintf(w, "\t\t'*%s' \\\n", spec)
+	}
+	var described []string
+	for _, c := range commands {
+		described = append(described, fmt.Sprintf(`%s\:%q`, c.name, c.summary))
+	}
+	fmt.Fprintf(w, `		'1::command:((%s))' \
+		'2::shell:->shell'
+	if [[ $state == shell && $words[2] == completion ]]; then
+		_values shell %s
+	fi
+}
+
+if [[ $zsh_eval_context[-1] == loadautofunc ]]; then
+	_go2proto "$@"
+else
+	compdef _go2proto go2proto
+fi
+`, strings.Join(described, " "), strings.Join(completionShells, " "))
+}
+
+func writeFishCompletion(w io.Writer, flags []completionFlag) {
+	quote := func(s string) string { return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'" }
+	fmt.Fprint(w, "# fish completion for go2proto. Load it with: go2proto completion fish | source\ncomplete -c go2proto -f\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "complete -c go2proto -n __fish_use_subcommand -a %s -d %s\n", c.name, quote(c.summary))
+	}
+	fmt.Fprintf(w, "complete -c go2proto -n '__fish_seen_subcommand_from completion' -a %s\n", quote(strings.Join(completionShells, " ")))
+	for _, f := range flags {
+		line := fmt.Sprintf("complete -c go2proto -o %s -d %s", f.name, quote(f.description))
+		switch {
+		case f.bool:
+		case dirFlags[f.name]:
+			line += ` -x -a '(string replace -r "^(?![./])" "./" -- (__fish_complete_directories (commandline -ct)))'`
+		case fileFlags[f.name]:
+			line += " -r -F"
+		case f.values != nil:
+			line += " -x -a " + quote(strings.Join(f.values, " "))
+		default:
+			line += " -x"
+		}
+		fmt.Fprintln(w, line)
+	}
+}
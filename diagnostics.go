@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/beam-cloud/go2proto/pkg/generator"
+)
+
+// Values of -diag-format.
+const (
+	diagFormatText  = "text"
+	diagFormatJSON  = "json"
+	diagFormatSARIF = "sarif"
+)
+
+// Codes of the diagnostics reported by the command rather than the generator.
+const (
+	codeSkipped  = "skipped"
+	codeBreaking = "breaking-change"
+)
+
+// diagOutput receives the diagnostics printed with -diag-format json or sarif.
+var diagOutput io.Writer = os.Stderr
+
+// sarifDiagnostics collects the diagnostics written as a SARIF log when the run ends.
+var (
+	sarifMu          sync.Mutex
+	sarifDiagnostics []*generator.Diagnostic
+)
+
+// jsonDiagnostic is the line printed for a diagnostic with -diag-format json.
+type jsonDiagnostic struct {
+	Code     string `json:"code"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Package  string `json:"package,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Field    string `json:"field,omitempty"`
+}
+
+// reportDiagnostic logs d as a warning, or renders it with a source excerpt when diagnostics
+// are pretty. With -diag-format json it prints d as a JSON object on a line of its own
+// instead, and with -diag-format sarif it keeps d for flushDiagnostics.
+func reportDiagnostic(d *generator.Diagnostic) {
+	if *diagFormat == diagFormatText && !prettyDiagnostics {
+		warnf("%s", d)
+		return
+	}
+	if d.Severity != generator.SeverityError {
+		atomic.AddInt64(&warnings, 1)
+	}
+	if *diagFormat == diagFormatText {
+		if logLevel >= levelInfo || d.Severity == generator.SeverityError {
+			pwd, _ := os.Getwd()
+			renderDiagnostic(diagOutput, d, pwd)
+		}
+		return
+	}
+	if *diagFormat == diagFormatSARIF {
+		sarifMu.Lock()
+		sarifDiagnostics = append(sarifDiagnostics, d)
+		sarifMu.Unlock()
+		return
+	}
+	data, err := json.Marshal(jsonDiagnostic{
+		Code:     d.Code,
+		Severity: d.Severity,
+		Message:  d.Message,
+		File:     d.File,
+		Line:     d.Line,
+		Column:   d.Column,
+		Package:  d.Package,
+		Type:     d.Type,
+		Field:    d.Field,
+	})
+	if err != nil {
+		log.Printf("error: %s", err)
+		return
+	}
+	diagOutput.Write(append(data, '\n'))
+}
+
+// skippedDiagnostic turns a skipped item into a warning diagnostic.
+func skippedDiagnostic(item *generator.SkippedItem) *generator.Diagnostic {
+	return &generator.Diagnostic{
+		Package:  item.Package,
+		Type:     item.Type,
+		Field:    item.Field,
+		Code:     codeSkipped,
+		Message:  "skipped: " + item.Reason,
+		Severity: generator.SeverityWarning,
+		File:     item.File,
+		Line:     item.Line,
+		Column:   item.Column,
+	}
+}
+
+// breakingDiagnostic turns a wire-breaking change into an error diagnostic.
+func breakingDiagnostic(c generator.BreakingChange) *generator.Diagnostic {
+	return &generator.Diagnostic{
+		Code:     codeBreaking,
+		Message:  c.Message,
+		Severity: generator.SeverityError,
+		File:     c.Path,
+		Line:     c.Line,
+	}
+}
+
+// logError logs err. With -diag-format json or sarif, or pretty diagnostics, the diagnostics
+// of an ErrorList are reported in that format instead, leaving only the first line of err,
+// which counts them, in the log.
+func logError(err error) {
+	var list generator.ErrorList
+	if (*diagFormat != diagFormatText || prettyDiagnostics) && errors.As(err, &list) {
+		for _, d := range list {
+			reportDiagnostic(d)
+		}
+		summary, _, _ := strings.Cut(err.Error(), "\n")
+		log.Printf("error: %s", summary)
+		return
+	}
+	log.Printf("error: %s", err)
+}
+
+// flushDiagnostics writes the SARIF log of the diagnostics reported so far to diagOutput with
+// -diag-format sarif. Paths are made relative to the working directory, which is assumed to
+// be the root of the repository scanned.
+func flushDiagnostics() {
+	if *diagFormat != diagFormatSARIF {
+		return
+	}
+	pwd, _ := os.Getwd()
+	sarifMu.Lock()
+	defer sarifMu.Unlock()
+	if err := writeSARIF(diagOutput, sarifDiagnostics, pwd); err != nil {
+		log.Printf("error: unable to write SARIF log: %s", err)
+	}
+}
+
+// sarifRules describes the diagnostic codes as SARIF rules.
+var sarifRules = map[string]string{
+	generator.CodeLoad:                  "Package failed to load",
+	generator.CodeCgo:                   "Cgo package could not be built",
+	generator.CodeCache:                 "Cached analysis changed while running",
+	generator.CodeDuplicateName:         "Message name declared by several Go types",
+	generator.CodeInvalidIdentifier:     "Name is not a valid proto identifier",
+	generator.CodeNonASCII:              "Name holds characters outside ASCII",
+	generator.CodeInvalidMapKey:         "Map keyed by a type proto maps can't be keyed by",
+	generator.CodeInvalidMapValue:       "Map holding values proto maps can't hold",
+	generator.CodeKeptWrapper:           "Wrapper message kept by -collapse-wrappers",
+	generator.CodeUnmappedType:          "Type has no proto mapping",
+	generator.CodeIneffectiveAnnotation: "Annotation has no effect",
+	generator.CodeStreamResult:          "Streamed method does not return a slice",
+	generator.CodeEmptyService:          "Service has no rpc methods",
+	codeSkipped:                         "Field or type left out of the output",
+	codeBreaking:                        "Wire-breaking change to the generated schema",
+}
+
+// SARIF 2.1.0 log, with only the properties go2proto fills in.
+type (
+	sarifLog struct {
+		Version string     `json:"version"`
+		Schema  string     `json:"$schema"`
+		Runs    []sarifRun `json:"runs"`
+	}
+	sarifRun struct {
+		Tool    sarifTool     `json:"tool"`
+		Results []sarifResult `json:"results"`
+	}
+	sarifTool struct {
+		Driver sarifDriver `json:"driver"`
+	}
+	sarifDriver struct {
+		Name           string      `json:"name"`
+		Version        string      `json:"version"`
+		InformationURI string      `json:"informationUri"`
+		Rules          []sarifRule `json:"rules"`
+	}
+	sarifRule struct {
+		ID               string       `json:"id"`
+		ShortDescription sarifMessage `json:"shortDescription"`
+	}
+	sarifMessage struct {
+		Text string `json:"text"`
+	}
+	sarifResult struct {
+		RuleID    string          `json:"ruleId"`
+		Level     string          `json:"level"`
+		Message   sarifMessage    `json:"message"`
+		Locations []sarifLocation `json:"locations,omitempty"`
+	}
+	sarifLocation struct {
+		PhysicalLocation *sarifPhysicalLocation `json:"physicalLocation,omitempty"`
+		LogicalLocations []sarifLogicalLocation `json:"logicalLocations,omitempty"`
+	}
+	sarifPhysicalLocation struct {
+		ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+		Region           *sarifRegion          `json:"region,omitempty"`
+	}
+	sarifArtifactLocation struct {
+		URI       string `json:"uri"`
+		URIBaseID string `json:"uriBaseId,omitempty"`
+	}
+	sarifRegion struct {
+		StartLine   int `json:"startLine"`
+		StartColumn int `json:"startColumn,omitempty"`
+	}
+	sarifLogicalLocation struct {
+		FullyQualifiedName string `json:"fullyQualifiedName"`
+	}
+)
+
+// writeSARIF writes diagnostics as a SARIF log, with file paths relative to root.
+func writeSARIF(w io.Writer, diagnostics []*generator.Diagnostic, root string) error {
+	driver := sarifDriver{Name: "go2proto", Version: generator.Version(), InformationURI: "https://github.com/beam-cloud/go2proto", Rules: []sarifRule{}}
+	results := []sarifResult{}
+	seen := make(map[string]bool)
+	for _, d := range diagnostics {
+		if !seen[d.Code] {
+			seen[d.Code] = true
+			driver.Rules = append(driver.Rules, sarifRule{ID: d.Code, ShortDescription: sarifMessage{Text: sarifRules[d.Code]}})
+		}
+		result := sarifResult{RuleID: d.Code, Level: d.Severity, Message: sarifMessage{Text: d.Message}}
+		var loc sarifLocation
+		if d.File != "" {
+			uri, baseID := filepath.ToSlash(d.File), ""
+			if rel, err := filepath.Rel(root, d.File); err == nil && filepath.IsAbs(d.File) && !strings.HasPrefix(rel, "..") {
+				uri, baseID = filepath.ToSlash(rel), "%SRCROOT%"
+			}
+			loc.PhysicalLocation = &sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: uri, URIBaseID: baseID}}
+			if d.Line > 0 {
+				loc.PhysicalLocation.Region = &sarifRegion{StartLine: d.Line, StartColumn: d.Column}
+			}
+		}
+		if name := qualifiedName(d); name != "" {
+			loc.LogicalLocations = []sarifLogicalLocation{{FullyQualifiedName: name}}
+		}
+		if loc.PhysicalLocation != nil || loc.LogicalLocations != nil {
+			result.Locations = []sarifLocation{loc}
+		}
+		results = append(results, result)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{{Tool: sarifTool{Driver: driver}, Results: results}},
+	})
+}
+
+// qualifiedName names the Go package, type or field d is about, e.g. "example.com/models.User.Name".
+func qualifiedName(d *generator.Diagnostic) string {
+	name := d.Package
+	for _, part := range []string{d.Type, d.Field} {
+		if part != "" {
+			name += "." + part
+		}
+	}
+	return strings.TrimPrefix(name, ".")
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/beam-cloud/go2proto/pkg/generator"
+)
+
+// doctorTools are the external tools checked by doctor, with the argument printing their
+// version and what needs them.
+var doctorTools = []struct {
+	name, versionArg, neededBy string
+}{
+	{"go", "version", "loading packages"},
+	{"protoc", "--version", "-validate and -run-protoc"},
+	{"protoc-gen-go", "--version", "-run-protoc with the default go plugin"},
+	{"buf", "--version", "-validate, -run-buf-generate and -buf-push"},
+}
+
+// doctor loads and analyses the packages as generate does, without writing anything, and
+// reports to w whether they load, the annotated types found, the problems with them and
+// which external tools are available. It fails if the packages don't load or analyse, or no
+// annotated type matched.
+func doctor(w io.Writer, pwd string) error {
+	var result error
+	model, pkgs, err := generator.LoadAndAnalyze(generatorOptions(pwd))
+	var loadErr *generator.LoadError
+	switch {
+	case errors.As(err, &loadErr):
+		fmt.Fprintf(w, "Packages: FAIL\n%s\n", indent(loadErr.Err.Error()))
+		result = withExitCode(exitLoadError, errors.New("the packages could not be loaded"))
+	case err != nil:
+		fmt.Fprintf(w, "Packages: %d loaded\n\nAnalysis: FAIL\n%s\n", len(pkgs), indent(err.Error()))
+		result = errors.New("the annotated types could not be analysed")
+	default:
+		fmt.Fprintf(w, "Packages: %d loaded\n", len(pkgs))
+		fmt.Fprintf(w, "\nAnnotated types: %d messages, %d enums, %d services\n", len(model.Messages), len(model.Enums), len(model.Services))
+		if len(model.Messages) == 0 && len(model.Enums) == 0 {
+			result = withExitCode(exitNoTypes, errors.New("no annotated types matched; check the @go2proto annotations and -filter"))
+		} else {
+			var table strings.Builder
+			listTypes(&table, model)
+			fmt.Fprintln(w, indent(strings.TrimSuffix(table.String(), "\n")))
+		}
+
+		var problems []string
+		for _, d := range model.Diagnostics {
+			problems = append(problems, d.String())
+		}
+		for _, item := range model.Skipped {
+			if item.Warning {
+				problems = append(problems, "skipped "+item.String())
+			}
+		}
+		fmt.Fprintf(w, "\nProblems: %d\n", len(problems))
+		for _, p := range problems {
+			fmt.Fprintf(w, "  %s\n", p)
+		}
+	}
+
+	fmt.Fprintf(w, "\nTools:\n")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, tool := range doctorTools {
+		path, err := exec.LookPath(tool.name)
+		if err != nil {
+			fmt.Fprintf(tw, "  %s\tnot found, needed by %s\n", tool.name, tool.neededBy)
+			continue
+		}
+		version := "unknown version"
+		if out, err := exec.Command(path, tool.versionArg).CombinedOutput(); err == nil {
+			version, _, _ = strings.Cut(strings.TrimSpace(string(out)), "\n")
+		}
+		fmt.Fprintf(tw, "  %s\t%s (%s)\n", tool.name, version, path)
+	}
+	tw.Flush()
+	return result
+}
+
+// indent indents every line of s by two spaces.
+func indent(s string) string {
+	return "  " + strings.ReplaceAll(s, "\n", "\n  ")
+}
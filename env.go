@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix is prepended to a flag's upper-cased name to form its environment variable,
+// e.g. GO2PROTO_F for -f and GO2PROTO_WATCH_INTERVAL for -watch-interval.
+const envPrefix = "GO2PROTO_"
+
+// envName returns the environment variable configuring the named flag.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// applyEnv sets every flag that was not given on the command line from its GO2PROTO_*
+// environment variable. Repeatable flags take a space-separated list.
+func applyEnv(fs *flag.FlagSet) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || set[f.Name] || err != nil {
+			return
+		}
+		values := []string{value}
+		if _, repeatable := f.Value.(*arrFlags); repeatable {
+			values = strings.Fields(value)
+		}
+		for _, v := range values {
+			if setErr := fs.Set(f.Name, v); setErr != nil {
+				err = fmt.Errorf("invalid %s: %s", envName(f.Name), setErr)
+				return
+			}
+		}
+	})
+	return err
+}
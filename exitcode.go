@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// Exit codes, so build scripts can branch on the failure class.
+const (
+	exitOK              = 0
+	exitGenerationError = 1 // rendering or writing the output failed
+	exitUsageError      = 2 // invalid flags (also used by the flag package)
+	exitLoadError       = 3 // the packages could not be loaded
+	exitDrift           = 4 // -check or check-compiled found out-of-date files
+	exitNoTypes         = 5 // no annotated types matched
+	exitValidationError = 6 // -validate rejected the output
+	exitBreaking        = 7 // breaking found wire-breaking changes
+	exitCompileError    = 8 // -run-protoc or -run-buf-generate failed
+	exitPublishError    = 9 // -registry-url or -buf-push failed
+)
+
+// exitError is an error carrying the process exit code it should produce.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+
+func (e *exitError) Unwrap() error { return e.err }
+
+// withExitCode attaches an exit code to err.
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+// exitCode returns the exit code attached to err, defaulting to exitGenerationError.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var ee *exitError
+	if errors.As(err, &ee) {
+		return ee.code
+	}
+	return exitGenerationError
+}
+
+// exitWithError logs err and exits with its exit code.
+func exitWithError(err error) {
+	logError(err)
+	flushDiagnostics()
+	prof.stop()
+	os.Exit(exitCode(err))
+}
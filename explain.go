@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/beam-cloud/go2proto/pkg/generator"
+)
+
+// explainLogger records the mapping decisions the generator logs for the type or field
+// selected by -explain, passing every decision on to next.
+type explainLogger struct {
+	selector  string
+	next      generator.Logger
+	decisions []string
+}
+
+func (l *explainLogger) Printf(format string, args ...interface{}) {
+	l.next.Printf(format, args...)
+	msg := fmt.Sprintf(format, args...)
+	if subject, decision, ok := strings.Cut(msg, ": "); ok && explainMatches(subject, l.selector) {
+		if subject != l.selector {
+			decision = strings.TrimPrefix(subject, l.selector+".") + ": " + decision
+		}
+		l.decisions = append(l.decisions, decision)
+	}
+}
+
+// explainMatches reports whether a decision about subject, a Go type name or Type.Field,
+// is covered by selector. A type selector covers its fields.
+func explainMatches(subject, selector string) bool {
+	return subject == selector || !strings.Contains(selector, ".") && strings.HasPrefix(subject, selector+".")
+}
+
+// writeExplanation prints the decisions recorded by l, followed by what was skipped and what
+// ended up in model for the selected type or field.
+func writeExplanation(w io.Writer, l *explainLogger, model *generator.Model) error {
+	typeName, fieldName, _ := strings.Cut(l.selector, ".")
+	var lines []string
+	for _, item := range model.Skipped {
+		if item.Type == typeName && (fieldName == "" || item.Field == fieldName) {
+			lines = append(lines, "skipped: "+item.String())
+		}
+	}
+	for _, msg := range model.Messages {
+		if msg.GoName != typeName {
+			continue
+		}
+		if fieldName == "" {
+			lines = append(lines, fmt.Sprintf("result: message %s with %d fields in %s", msg.Name, len(msg.Fields), msg.PkgPath))
+			continue
+		}
+		for _, fd := range msg.Fields {
+			if fd.GoName == fieldName {
+				lines = append(lines, "result: "+describeField(msg, fd))
+			}
+		}
+	}
+	if fieldName == "" {
+		for _, ed := range model.Enums {
+			if ed.GoName == typeName {
+				lines = append(lines, fmt.Sprintf("result: enum %s with values %s in %s", ed.Name, strings.Join(ed.Values, ", "), ed.PkgPath))
+			}
+		}
+		for _, svc := range model.Services {
+			if svc.GoName == typeName {
+				lines = append(lines, fmt.Sprintf("result: service %s with %d methods in %s", svc.Name, len(svc.Methods), svc.PkgPath))
+			}
+		}
+	}
+	if len(l.decisions) == 0 && len(lines) == 0 {
+		return fmt.Errorf("nothing to explain for %s, expected an annotated Go type or Type.Field matching -filter", l.selector)
+	}
+
+	fmt.Fprintf(w, "%s:\n", l.selector)
+	for _, line := range append(l.decisions, lines...) {
+		fmt.Fprintf(w, "  %s\n", line)
+	}
+	return nil
+}
+
+// describeField formats a field as declared in msg, e.g. "repeated string tags in message User".
+func describeField(msg *generator.Message, fd *generator.Field) string {
+	decl := fd.TypeName + " " + fd.Name
+	if fd.IsRepeated {
+		decl = "repeated " + decl
+	}
+	if len(fd.EnumValues) > 0 {
+		decl += " (one of " + strings.Join(fd.EnumValues, ", ") + ")"
+	}
+	return fmt.Sprintf("%s in message %s", decl, msg.Name)
+}
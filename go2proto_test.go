@@ -1,34 +1,773 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/beam-cloud/go2proto/pkg/generator"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestLoadPackages(t *testing.T) {
-	pkgs, err := loadPackages(".", []string{"./example/in"})
+func TestScanSources(t *testing.T) {
+	pkgs, err := generator.Load(&generator.Options{Patterns: []string{"./example/in"}})
 	if err != nil {
 		t.Fatalf("error loading packages: %s", err)
 	}
 
 	assert := assert.New(t)
-	assert.True(len(pkgs) > 0, "pkgs should not be empty")
+	dirs := packageDirs(pkgs)
+	assert.Len(dirs, 1)
+
+	before := scanSources(dirs)
+	assert.Contains(before, filepath.Join(dirs[0], "model.go"))
+	assert.True(sameSnapshot(before, scanSources(dirs)))
+
+	changed := scanSources(dirs)
+	changed[filepath.Join(dirs[0], "model.go")] = time.Time{}
+	assert.False(sameSnapshot(before, changed))
+}
+
+func TestLogLevels(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer func(level int) { logLevel = level }(logLevel)
+
+	assert := assert.New(t)
+
+	logLevel = levelInfo
+	infof("info")
+	verbosef("verbose")
+	debugf("debug")
+	assert.Contains(buf.String(), "info")
+	assert.NotContains(buf.String(), "verbose")
+	assert.NotContains(buf.String(), "debug")
+
+	buf.Reset()
+	logLevel = levelDebug
+	verbosef("verbose")
+	debugf("mapping")
+	assert.Contains(buf.String(), "verbose")
+	assert.Contains(buf.String(), "debug: mapping")
+}
+
+func TestQuietAndLogFile(t *testing.T) {
+	defer log.SetOutput(os.Stderr)
+	defer func(level int) { logLevel = level }(logLevel)
+
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "go2proto.log")
+	assert.NoError(setLogFile(path))
+
+	logLevel = levelQuiet
+	infof("written")
+	logLevel = levelInfo
+	infof("visible")
+
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(err)
+	assert.NotContains(string(content), "written")
+	assert.Contains(string(content), "visible")
+}
+
+func TestGoGenerateDefaults(t *testing.T) {
+	pkgs, err := generator.Load(&generator.Options{Patterns: []string{"./example/in"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	dir := filepath.Dir(pkgs[0].GoFiles[0])
+
+	os.Unsetenv("GOPACKAGE")
+	assert.Equal(filepath.Join(dir, "in.proto"), defaultTargetFile(pkgs))
+
+	os.Setenv("GOPACKAGE", "models")
+	defer os.Unsetenv("GOPACKAGE")
+	assert.Equal(filepath.Join(dir, "models.proto"), defaultTargetFile(pkgs))
 }
 
-func TestGetMessages(t *testing.T) {
-	pkgs, err := loadPackages(".", []string{"./example/in"})
+func TestParseFilters(t *testing.T) {
+	filters := parseFilters([]string{"EventSub, account", "ArrayOfEventFieldItem"})
+	assert.Equal(t, []string{"eventsub", "account", "arrayofeventfielditem"}, filters)
+}
+
+func TestSkippedReport(t *testing.T) {
+	skipped := []*generator.SkippedItem{{Package: "second", Type: "Account", Field: "note", Reason: "not exported"}}
+
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "report.json")
+	assert.NoError(writeSkippedReport(skipped, path))
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(err)
+	assert.Contains(string(data), `"Reason": "not exported"`)
+}
+
+func TestRunStats(t *testing.T) {
+	pkgs, err := generator.Load(&generator.Options{Patterns: []string{"./pkg/generator/testdata/runtime"}})
 	if err != nil {
 		t.Fatalf("error loading packages: %s", err)
 	}
+	model, err := generator.Analyze(pkgs, &generator.Options{})
+	if err != nil {
+		t.Fatalf("error analysing packages: %s", err)
+	}
+
+	stats := &runStats{start: time.Now(), warningsAtStart: warningCount(), packages: len(pkgs), written: 1}
+	stats.collect(model)
+	warnf("counted")
+
+	assert.Contains(t, stats.String(), "summary: 1 packages, 1 types matched (1 messages, 0 enums), 2 fields, 4 skipped, 1 warnings, 1 files written in ")
+
+	stats.unchanged = 2
+	assert.Contains(t, stats.String(), ", 1 files written (2 unchanged) in ")
+	assert.NotContains(t, stats.String(), "(load")
+
+	stats.timings = generator.Timings{generator.PhaseLoad: time.Second, generator.PhaseAnalysis: 250 * time.Millisecond}
+	assert.True(t, strings.HasSuffix(stats.String(), " (load 1s, analysis 250ms)"), stats.String())
+}
+
+func TestListTypes(t *testing.T) {
+	model, _, err := generator.LoadAndAnalyze(&generator.Options{Patterns: []string{"./pkg/generator/testdata/service"}})
+	if err != nil {
+		t.Fatalf("error analysing packages: %s", err)
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, listTypes(&buf, model))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, []string{"KIND", "NAME", "PACKAGE", "FIELDS"}, strings.Fields(lines[0]))
+	assert.Len(t, lines, 9)
+	assert.Equal(t, []string{"message", "User", "github.com/beam-cloud/go2proto/pkg/generator/testdata/service", "2"}, strings.Fields(lines[7]))
+	assert.Equal(t, []string{"service", "UserService", "github.com/beam-cloud/go2proto/pkg/generator/testdata/service", "3"}, strings.Fields(lines[8]))
+}
+
+func TestExplain(t *testing.T) {
+	explainer := &explainLogger{selector: "User.Role", next: levelLogger{level: levelDebug}}
+	model, _, err := generator.LoadAndAnalyze(&generator.Options{Patterns: []string{"./pkg/generator/testdata/annotated"}, Debug: explainer})
+	if err != nil {
+		t.Fatalf("error analysing packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	assert.NoError(writeExplanation(&buf, explainer, model))
+	assert.Equal(`User.Role:
+  normalised field name to role
+  mapped Go type github.com/beam-cloud/go2proto/pkg/generator/testdata/annotated.Role to proto string role
+  type github.com/beam-cloud/go2proto/pkg/generator/testdata/annotated.Role is the enum Role, emitting as string
+  result: string role (one of admin, member) in message UserV2
+`, buf.String())
+
+	assert.True(explainMatches("User.Role", "User"))
+	assert.False(explainMatches("UserV2.Role", "User"))
+	assert.False(explainMatches("User.Tags", "User.Role"))
+
+	explainer = &explainLogger{selector: "Missing", next: levelLogger{level: levelDebug}}
+	assert.EqualError(writeExplanation(&buf, explainer, model), "nothing to explain for Missing, expected an annotated Go type or Type.Field matching -filter")
+}
+
+func TestProfiling(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	cpuPath, memPath, tracePath := filepath.Join(dir, "cpu.out"), filepath.Join(dir, "mem.out"), filepath.Join(dir, "trace.out")
+	p, err := startProfiling(cpuPath, memPath, tracePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generator.Timings{}.Span(generator.PhaseLoad)()
+	p.stop()
+	p.stop()
+
+	for _, path := range []string{cpuPath, memPath, tracePath} {
+		info, err := os.Stat(path)
+		if assert.NoError(err) {
+			assert.NotZero(info.Size(), path)
+		}
+	}
+
+	_, err = startProfiling(filepath.Join(dir, "missing", "cpu.out"), "", "")
+	assert.Error(err)
+	var none *profiler
+	none.stop()
+}
+
+func TestExitCodes(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(exitOK, exitCode(nil))
+	assert.Equal(exitGenerationError, exitCode(errors.New("boom")))
+	assert.Equal(exitDrift, exitCode(withExitCode(exitDrift, errors.New("stale"))))
+	assert.Equal(exitLoadError, exitCode(fmt.Errorf("wrapped: %w", withExitCode(exitLoadError, errors.New("load")))))
+	assert.Nil(withExitCode(exitNoTypes, nil))
+}
+
+func TestValidateOutput(t *testing.T) {
+	assert := assert.New(t)
+
+	commands, err := validatorCommands("protoc", filepath.Join("out", "api.proto"))
+	assert.NoError(err)
+	assert.Equal([][]string{{"protoc", "-I", "out", "--descriptor_set_out=" + os.DevNull, "api.proto"}}, commands)
+
+	commands, err = validatorCommands("buf", "api.proto")
+	assert.NoError(err)
+	assert.Equal([][]string{{"buf", "build", "api.proto"}, {"buf", "lint", "api.proto"}}, commands)
+
+	_, err = validatorCommands("javac", "api.proto")
+	assert.Error(err)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("fake validator is a shell script")
+	}
+
+	// A fake protoc that rejects everything surfaces its output.
+	bin := t.TempDir()
+	script := "#!/bin/sh\necho 'api.proto:3:1: Expected top-level statement.' >&2\nexit 1\n"
+	assert.NoError(ioutil.WriteFile(filepath.Join(bin, "protoc"), []byte(script), 0755))
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", bin)
+
+	err = validateOutput([]*generator.File{{Path: filepath.Join(bin, "api.proto")}}, "auto")
+	assert.Error(err)
+	assert.Contains(err.Error(), "Expected top-level statement.")
+}
+
+func TestRunPlugin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake plugin is a shell script")
+	}
+	assert := assert.New(t)
+	dir := t.TempDir()
+
+	plugin := filepath.Join(dir, "go2proto-gen-echo")
+	script := "#!/bin/sh\ncat > " + filepath.Join(dir, "request.json") + "\n" +
+		`echo '{"Files":[{"Name":"nested/out.txt","Content":"hello"}]}'` + "\n"
+	assert.NoError(ioutil.WriteFile(plugin, []byte(script), 0755))
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	req := &pluginRequest{ProtoPackage: "acme", Messages: []*generator.Message{{Name: "User", GoName: "User"}}}
+	written, err := runPlugin("echo=lang=go", req, filepath.Join(dir, "out"))
+	assert.NoError(err)
+	assert.Equal([]string{filepath.Join(dir, "out", "nested", "out.txt")}, written)
+
+	content, err := ioutil.ReadFile(written[0])
+	assert.NoError(err)
+	assert.Equal("hello", string(content))
+
+	var got pluginRequest
+	data, err := ioutil.ReadFile(filepath.Join(dir, "request.json"))
+	assert.NoError(err)
+	assert.NoError(json.Unmarshal(data, &got))
+	assert.Equal("lang=go", got.Parameter)
+	assert.Equal("User", got.Messages[0].Name)
+
+	bad := filepath.Join(dir, "escape")
+	assert.NoError(ioutil.WriteFile(bad, []byte("#!/bin/sh\necho '{\"Files\":[{\"Name\":\"../x\",\"Content\":\"\"}]}'\n"), 0755))
+	_, err = runPlugin(bad, req, filepath.Join(dir, "out"))
+	assert.Error(err)
+
+	_, err = runPlugin("missing", req, dir)
+	assert.Error(err)
+}
+
+func TestProgress(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("[===============>              ] 1/2 acme/a", progressBar(1, 2, "acme/a"))
+	assert.Equal("[==============================] 2/2 acme/b", progressBar(2, 2, "acme/b"))
+
+	_, err := newProgressReporter("spinner", time.Second)
+	assert.Error(err)
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	// Log lines are throttled, but the final one is always printed.
+	r, err := newProgressReporter("log", time.Hour)
+	assert.NoError(err)
+	r.report(1, 3, "acme/a")
+	r.report(2, 3, "acme/b")
+	r.report(3, 3, "acme/c")
+	assert.Contains(buf.String(), "analysed 1/3 packages (acme/a)")
+	assert.NotContains(buf.String(), "acme/b")
+	assert.Contains(buf.String(), "analysed 3/3 packages (acme/c)")
+}
+
+func TestJSONDiagnostics(t *testing.T) {
+	var logBuf, diagBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	diagOutput = &diagBuf
+	*diagFormat = diagFormatJSON
+	defer func() {
+		log.SetOutput(os.Stderr)
+		diagOutput = os.Stderr
+		*diagFormat = diagFormatText
+	}()
+
+	assert := assert.New(t)
+	start := warningCount()
+	reportDiagnostic(&generator.Diagnostic{Package: "acme", Type: "User", Field: "type", Code: generator.CodeInvalidIdentifier,
+		Message: "renamed", Severity: generator.SeverityWarning, File: "user.go", Line: 3, Column: 2})
+	logSkipped([]*generator.SkippedItem{{Package: "acme", Type: "User", Field: "Done", Reason: "channel type", Warning: true}}, false)
+	logError(fmt.Errorf("error fetching packages: %w", generator.ErrorList{{Package: "acme", Code: generator.CodeLoad, Message: "undefined: x", Severity: generator.SeverityError}}))
+
+	assert.Equal(`{"code":"invalid-identifier","severity":"warning","message":"renamed","file":"user.go","line":3,"column":2,"package":"acme","type":"User","field":"type"}
+{"code":"skipped","severity":"warning","message":"skipped: channel type","package":"acme","type":"User","field":"Done"}
+{"code":"load","severity":"error","message":"undefined: x","package":"acme"}
+`, diagBuf.String())
+	assert.Equal(int64(2), warningCount()-start)
+	assert.Contains(logBuf.String(), "error: error fetching packages: 1 error, 0 warnings\n")
+	assert.NotContains(logBuf.String(), "undefined")
+}
+
+func TestSARIF(t *testing.T) {
+	root := t.TempDir()
+	diagnostics := []*generator.Diagnostic{
+		{Package: "acme", Type: "User", Field: "type", Code: generator.CodeInvalidIdentifier, Message: "renamed",
+			Severity: generator.SeverityWarning, File: filepath.Join(root, "models", "user.go"), Line: 3, Column: 2},
+		breakingDiagnostic(generator.BreakingChange{Path: "proto/acme.proto", Line: 7, Message: "field 2 removed"}),
+		{Package: "acme/cgo", Code: generator.CodeCgo, Message: "no C toolchain", Severity: generator.SeverityError},
+	}
+
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	assert.NoError(writeSARIF(&buf, diagnostics, root))
+	var sarif sarifLog
+	assert.NoError(json.Unmarshal(buf.Bytes(), &sarif))
+	assert.Equal("2.1.0", sarif.Version)
+	if !assert.Len(sarif.Runs, 1) {
+		return
+	}
+	run := sarif.Runs[0]
+	assert.Equal("go2proto", run.Tool.Driver.Name)
+	assert.Equal([]sarifRule{
+		{ID: "invalid-identifier", ShortDescription: sarifMessage{Text: "Name is not a valid proto identifier"}},
+		{ID: "breaking-change", ShortDescription: sarifMessage{Text: "Wire-breaking change to the generated schema"}},
+		{ID: "cgo", ShortDescription: sarifMessage{Text: "Cgo package could not be built"}},
+	}, run.Tool.Driver.Rules)
+	if !assert.Len(run.Results, 3) {
+		return
+	}
+	assert.Equal(sarifResult{RuleID: "invalid-identifier", Level: "warning", Message: sarifMessage{Text: "renamed"}, Locations: []sarifLocation{{
+		PhysicalLocation: &sarifPhysicalLocation{
+			ArtifactLocation: sarifArtifactLocation{URI: "models/user.go", URIBaseID: "%SRCROOT%"},
+			Region:           &sarifRegion{StartLine: 3, StartColumn: 2},
+		},
+		LogicalLocations: []sarifLogicalLocation{{FullyQualifiedName: "acme.User.type"}},
+	}}}, run.Results[0])
+	assert.Equal("proto/acme.proto", run.Results[1].Locations[0].PhysicalLocation.ArtifactLocation.URI)
+	assert.Equal("error", run.Results[1].Level)
+	assert.Nil(run.Results[2].Locations[0].PhysicalLocation)
+	assert.Equal("acme/cgo", run.Results[2].Locations[0].LogicalLocations[0].FullyQualifiedName)
+}
+
+// TestSARIFRules checks that every diagnostic code declared by the generator has a rule.
+func TestSARIFRules(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "pkg/generator/report.go", nil, 0)
+	if err != nil {
+		t.Fatalf("error parsing report.go: %s", err)
+	}
+	codes := 0
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			for i, name := range spec.(*ast.ValueSpec).Names {
+				if !strings.HasPrefix(name.Name, "Code") {
+					continue
+				}
+				codes++
+				code, _ := strconv.Unquote(spec.(*ast.ValueSpec).Values[i].(*ast.BasicLit).Value)
+				assert.NotEmpty(t, sarifRules[code], "no SARIF rule for %s", name.Name)
+			}
+		}
+	}
+	assert.NotZero(t, codes)
+}
+
+func TestRenderDiagnostic(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, "models", "user.go")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+	assert.NoError(t, ioutil.WriteFile(file, []byte("package models\n\ntype User struct {\n\tMessage string\n}\n"), 0644))
+
+	var buf bytes.Buffer
+	renderDiagnostic(&buf, &generator.Diagnostic{Package: "acme/models", Type: "User", Field: "Message", Code: generator.CodeInvalidIdentifier,
+		Message: "renamed", Severity: generator.SeverityWarning, File: file, Line: 4, Column: 2}, root)
+	assert.Equal(t, "\033[1;33mwarning[invalid-identifier]\033[0m\033[1m: renamed\033[0m\n"+
+		"  \033[1;34m-->\033[0m models/user.go:4:2\n"+
+		"  \033[1;34m|\033[0m\n"+
+		"\033[1;34m4 |\033[0m \tMessage string\n"+
+		"  \033[1;34m|\033[0m \t\033[1;33m^\033[0m\n", buf.String())
+
+	buf.Reset()
+	renderDiagnostic(&buf, &generator.Diagnostic{Package: "acme/cgo", Code: generator.CodeCgo, Message: "no C toolchain", Severity: generator.SeverityError}, root)
+	assert.Equal(t, "\033[1;31merror[cgo]\033[0m\033[1m: no C toolchain\033[0m\n  \033[1;34m-->\033[0m acme/cgo\n", buf.String())
+
+	for mode, want := range map[string]bool{colorAlways: true, colorNever: false} {
+		color, err := useColor(mode)
+		assert.NoError(t, err)
+		assert.Equal(t, want, color, mode)
+	}
+	_, err := useColor("sometimes")
+	assert.Error(t, err)
+}
+
+func TestCompletion(t *testing.T) {
+	fs := flag.NewFlagSet("go2proto", flag.ContinueOnError)
+	var pkgs arrFlags
+	fs.Var(&pkgs, "p", "Packages to analyse. Repeatable.")
+	fs.String("f", "", "Output file.")
+	fs.String("format", generator.FormatProto, "Output format.")
+	fs.Bool("q", false, "Quiet: only log errors.")
+	fs.String("t", "package", "Protobuf package name")
+
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	assert.NoError(writeCompletion(&buf, "bash", fs))
+	assert.Contains(buf.String(), "\t\t-p) _go2proto_dirs; return ;;\n")
+	assert.Contains(buf.String(), "\t\t-f) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n")
+	assert.Contains(buf.String(), `-format) COMPREPLY=($(compgen -W "avro dts flatbuffers graphql jsonschema openapi proto thrift" -- "$cur"))`)
+	assert.Contains(buf.String(), "\t\t-t) return ;;\n")
+	assert.Contains(buf.String(), `compgen -W "generate check diff list breaking check-compiled init doctor proto2go completion"`)
+	assert.Contains(buf.String(), `compgen -W "-f -format -p -q -t"`)
+
+	buf.Reset()
+	assert.NoError(writeCompletion(&buf, "zsh", fs))
+	assert.Contains(buf.String(), "\t\t'*-p[Packages to analyse]:directory:_go2proto_dirs' \\\n")
+	assert.Contains(buf.String(), "\t\t'*-q[Quiet\\: only log errors]' \\\n")
+	assert.Contains(buf.String(), "\t\t'*-t[Protobuf package name]:t: ' \\\n")
+
+	buf.Reset()
+	assert.NoError(writeCompletion(&buf, "fish", fs))
+	assert.Contains(buf.String(), "complete -c go2proto -o f -d 'Output file' -r -F\n")
+	assert.Contains(buf.String(), "complete -c go2proto -o q -d 'Quiet: only log errors'\n")
+	assert.Contains(buf.String(), "complete -c go2proto -n __fish_use_subcommand -a proto2go -d 'Convert a .proto file into annotated Go structs'\n")
 
-	msgs, enums := getProtobufTypes(pkgs, "")
+	assert.EqualError(writeCompletion(&buf, "tcsh", fs), "unknown shell tcsh, expected bash, zsh, fish")
+}
+
+func TestCommands(t *testing.T) {
+	assert := assert.New(t)
+	cmd, args := parseCommand([]string{"-p", "./models"})
+	assert.Equal("generate", cmd.name)
+	assert.Equal([]string{"-p", "./models"}, args)
+	cmd, args = parseCommand([]string{"check", "-f", "api.proto"})
+	assert.Equal("check", cmd.name)
+	assert.Equal([]string{"-f", "api.proto"}, args)
+	cmd, _ = parseCommand([]string{"check-breaking"})
+	assert.Equal("breaking", cmd.name, "former command names keep working")
+	cmd, _ = parseCommand([]string{"completion", "bash"})
+	assert.NotNil(cmd.run)
+
+	fs := flag.NewFlagSet("go2proto", flag.ContinueOnError)
+	fs.String("f", "", "Output file.")
+	fs.String("t", "package", "Protobuf package name")
+	fs.Bool("merge", false, "Preserve manual sections.")
+	fs.String("pb-dir", "", "Directory of the .pb.go files.")
+	fs.Bool("list", false, "Only print the annotated types.")
+
+	var buf bytes.Buffer
+	printCommandUsage(&buf, lookupCommand("breaking"), fs)
+	assert.Equal(`Usage: go2proto breaking [flags]
+
+Fail on wire-breaking changes to the generated messages.
 
-	for _, msg := range msgs {
-		t.Logf("message: %s", msg.Name)
+Schema flags:
+  -t string
+    	Protobuf package name (default "package")
+
+Output flags:
+  -f string
+    	Output file.
+`, buf.String())
+
+	buf.Reset()
+	printCommandUsage(&buf, lookupCommand("generate"), fs)
+	assert.Contains(buf.String(), "\nCommands:\n  generate        Write the schema of the annotated Go types\n")
+	assert.Contains(buf.String(), "\nOutput flags:\n  -f string\n    \tOutput file.\n  -merge\n")
+	assert.Contains(buf.String(), "\nOther flags:\n  -list\n    \tOnly print the annotated types.\n  -pb-dir string\n")
+}
+
+func TestDoctor(t *testing.T) {
+	pwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
 	}
+	defer func() { pkgFlags = nil }()
+
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	pkgFlags = arrFlags{"./pkg/generator/testdata/service"}
+	assert.NoError(doctor(&buf, pwd))
+	assert.Contains(buf.String(), "Packages: 1 loaded\n\nAnnotated types: 7 messages, 0 enums, 1 services\n  KIND ")
+	assert.Contains(buf.String(), "\nProblems: 3\n  skipped ")
+	assert.Contains(buf.String(), "UserService.Rename: parameter type string is not a struct\n")
+	assert.Regexp(`\nTools:\n  go +go version `, buf.String())
+
+	buf.Reset()
+	pkgFlags = arrFlags{"./nonexistent"}
+	err = doctor(&buf, pwd)
+	assert.Equal(exitLoadError, exitCode(err))
+	assert.Contains(buf.String(), "Packages: FAIL\n")
+	assert.Contains(buf.String(), "\nTools:\n")
+}
+
+func TestApplyEnv(t *testing.T) {
+	assert := assert.New(t)
+	fs := flag.NewFlagSet("go2proto", flag.ContinueOnError)
+	target := fs.String("f", "", "")
+	proto := fs.String("t", "package", "")
+	interval := fs.Duration("watch-interval", time.Second, "")
+	var pkgs arrFlags
+	fs.Var(&pkgs, "p", "")
+
+	os.Setenv("GO2PROTO_F", "env.proto")
+	os.Setenv("GO2PROTO_T", "acme.env")
+	os.Setenv("GO2PROTO_WATCH_INTERVAL", "5s")
+	os.Setenv("GO2PROTO_P", "./a ./b")
+	defer func() {
+		for _, name := range []string{"GO2PROTO_F", "GO2PROTO_T", "GO2PROTO_WATCH_INTERVAL", "GO2PROTO_P"} {
+			os.Unsetenv(name)
+		}
+	}()
+
+	assert.NoError(fs.Parse([]string{"-t", "acme.flag"}))
+	assert.NoError(applyEnv(fs))
+	assert.Equal("env.proto", *target)
+	assert.Equal("acme.flag", *proto, "command line flags win over the environment")
+	assert.Equal(5*time.Second, *interval)
+	assert.Equal(arrFlags{"./a", "./b"}, pkgs)
+
+	os.Setenv("GO2PROTO_WATCH_INTERVAL", "soon")
+	fs = flag.NewFlagSet("go2proto", flag.ContinueOnError)
+	fs.Duration("watch-interval", time.Second, "")
+	assert.EqualError(applyEnv(fs), "invalid GO2PROTO_WATCH_INTERVAL: parse error")
+}
+
+func TestParseScalars(t *testing.T) {
+	assert := assert.New(t)
+	scalars, err := parseScalars([]string{"google.protobuf.Timestamp=DateTime", "int64=Int64"})
+	assert.NoError(err)
+	assert.Equal(map[string]string{"google.protobuf.Timestamp": "DateTime", "int64": "Int64"}, scalars)
 
-	for _, enum := range enums {
-		t.Logf("enum: %s", enum.Name)
+	for _, invalid := range []string{"DateTime", "=DateTime", "int64="} {
+		_, err := parseScalars([]string{invalid})
+		assert.Error(err, invalid)
 	}
 }
+
+func TestParseMode(t *testing.T) {
+	assert := assert.New(t)
+	mode, err := parseMode("0644")
+	assert.NoError(err)
+	assert.Equal(os.FileMode(0644), mode)
+	mode, err = parseMode("")
+	assert.NoError(err)
+	assert.Zero(mode)
+	for _, invalid := range []string{"644x", "0", "01777", "rw-r--r--"} {
+		_, err := parseMode(invalid)
+		assert.EqualError(err, `invalid -mode "`+invalid+`", expected octal permissions such as 0644`)
+	}
+}
+
+func TestParseOutputs(t *testing.T) {
+	assert := assert.New(t)
+	packages, err := parseProtoPackages([]string{"github.com/acme/users=acme.users"})
+	assert.NoError(err)
+	assert.Equal(map[string]string{"github.com/acme/users": "acme.users"}, packages)
+	_, err = parseProtoPackages([]string{"acme.users"})
+	assert.EqualError(err, `invalid -proto-package "acme.users", expected import/path=proto.package`)
+
+	assert.Equal(map[string][]string{
+		"":                {"acme/options.proto", "buf/validate/validate.proto"},
+		"api/users.proto": {"acme/resource.proto"},
+	}, parseImports([]string{"acme/options.proto", "api/users.proto=acme/resource.proto", "buf/validate/validate.proto"}))
+
+	outputs, err := parseOutputs([]string{"github.com/acme/users=api/users.proto", "github.com/acme/billing=api/billing/billing.proto"})
+	assert.NoError(err)
+	assert.Equal(map[string]string{"github.com/acme/users": "api/users.proto", "github.com/acme/billing": "api/billing/billing.proto"}, outputs)
+
+	for _, invalid := range []string{"api/users.proto", "=api/users.proto", "github.com/acme/users="} {
+		_, err := parseOutputs([]string{invalid})
+		assert.Error(err, invalid)
+	}
+
+	model := &generator.Model{
+		Messages: []*generator.Message{{Name: "User", PkgPath: "github.com/acme/users"}},
+		Services: []*generator.Service{{Name: "Billing", PkgPath: "github.com/acme/billing"}},
+	}
+	assert.Equal([]string{"github.com/acme/orders", "github.com/acme/teams"}, unmatchedPackages(model, map[string]string{
+		"github.com/acme/users":   "users.proto",
+		"github.com/acme/billing": "billing.proto",
+		"github.com/acme/teams":   "teams.proto",
+		"github.com/acme/orders":  "orders.proto",
+	}))
+}
+
+func TestIsNameAffix(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(isNameAffix("", true))
+	assert.True(isNameAffix("Api", true))
+	assert.True(isNameAffix("Pb", false))
+	assert.True(isNameAffix("_V2", false))
+	assert.False(isNameAffix("_Api", true))
+	assert.False(isNameAffix("2Api", true))
+	assert.False(isNameAffix("Api.", true))
+	assert.False(isNameAffix("-pb", false))
+}
+
+func TestCompileCommands(t *testing.T) {
+	assert := assert.New(t)
+	files := []*generator.File{{Path: filepath.Join("api", "api.proto")}, {Path: filepath.Join("api", "billing.proto")}}
+
+	args, err := protocCommand(files, nil)
+	assert.NoError(err)
+	assert.Equal([]string{"protoc", "-I", "api", "--go_out=paths=source_relative:api", filepath.Join("api", "api.proto"), filepath.Join("api", "billing.proto")}, args)
+
+	args, err = protocCommand(files[:1], []string{"go=./gen", "go-grpc=require_unimplemented_servers=false:./gen"})
+	assert.NoError(err)
+	assert.Equal([]string{"protoc", "-I", "api", "--go_out=./gen", "--go_grpc_out=require_unimplemented_servers=false:./gen", filepath.Join("api", "api.proto")}, args)
+
+	_, err = protocCommand(files, []string{"go"})
+	assert.Error(err)
+
+	assert.Equal([]string{"buf", "generate", "--template", "buf.gen.yaml", "api"}, bufGenerateCommand(files, "buf.gen.yaml"))
+	assert.Equal([]string{"buf", "generate", "api"}, bufGenerateCommand(files, ""))
+
+	if runtime.GOOS == "windows" {
+		t.Skip("fake compiler is a shell script")
+	}
+	bin := t.TempDir()
+	script := "#!/bin/sh\necho 'protoc-gen-go: program not found or is not executable' >&2\nexit 1\n"
+	assert.NoError(ioutil.WriteFile(filepath.Join(bin, "protoc"), []byte(script), 0755))
+	err = runCompiler([]string{filepath.Join(bin, "protoc"), "-I", "api"})
+	assert.Error(err)
+	assert.Contains(err.Error(), "protoc-gen-go: program not found")
+}
+
+func TestInitBufLayout(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(filepath.Join("proto", "acme", "events", "v1", "events.proto"), bufLayoutPath("acme.events.v1"))
+	assert.Equal(filepath.Join("proto", "acme", "events", "events.proto"), bufLayoutPath("acme.events"))
+	assert.Equal(filepath.Join("proto", "models", "models.proto"), bufLayoutPath("models"))
+	assert.Equal(filepath.Join("proto", "acme", "events", "v2beta1", "events.proto"), bufLayoutPath("acme.events.v2beta1"))
+	assert.Equal(filepath.Join("proto", "acme", "v8engine", "v8engine.proto"), bufLayoutPath("acme.v8engine"), "only versions buf lint accepts are versions")
+	assert.Equal(filepath.Join("proto", "acme", "events", "v1", "events.proto"), bufLayoutPath(generator.VersionedPackage("acme.events", "v1")))
+
+	dir := t.TempDir()
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "buf.gen.yaml"), []byte("custom"), 0666))
+	assert.NoError(writeBufConfig(dir, "acme.events.v1", false))
+
+	bufYAML, err := ioutil.ReadFile(filepath.Join(dir, "buf.yaml"))
+	assert.NoError(err)
+	assert.Contains(string(bufYAML), "# Generated by go2proto init for acme.events.v1.\nversion: v2\n")
+	assert.Contains(string(bufYAML), "  - path: proto\n")
+	genYAML, err := ioutil.ReadFile(filepath.Join(dir, "buf.gen.yaml"))
+	assert.NoError(err)
+	assert.Equal("custom", string(genYAML), "existing files are kept")
+
+	dir = t.TempDir()
+	assert.NoError(writeBufConfig(dir, "acme.events.v1", true))
+	genYAML, err = ioutil.ReadFile(filepath.Join(dir, "buf.gen.yaml"))
+	assert.NoError(err)
+	assert.Contains(string(genYAML), "  - directory: proto\n")
+	assert.Contains(string(genYAML), "  - local: protoc-gen-go\n")
+	assert.Contains(string(genYAML), "  - local: protoc-gen-connect-go\n    out: gen\n")
+}
+
+func TestWriteConnectConfig(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	assert.NoError(writeConnectConfig(dir, "acme.users.v1", filepath.Join(dir, "api", "users")))
+	genYAML, err := ioutil.ReadFile(filepath.Join(dir, "buf.gen.yaml"))
+	assert.NoError(err)
+	assert.True(strings.HasPrefix(string(genYAML), "# Generated by go2proto -connect for acme.users.v1.\n"))
+	assert.Contains(string(genYAML), "  - directory: api/users\n", "the output directory is relative to the config")
+	assert.Contains(string(genYAML), "  - local: protoc-gen-connect-go\n")
+
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "buf.gen.yaml"), []byte("custom"), 0666))
+	warnings := warningCount()
+	assert.NoError(writeConnectConfig(dir, "acme.users.v1", "api"))
+	assert.Equal(warnings+1, warningCount(), "an existing config without the connect-go plugin is reported")
+	genYAML, err = ioutil.ReadFile(filepath.Join(dir, "buf.gen.yaml"))
+	assert.NoError(err)
+	assert.Equal("custom", string(genYAML))
+}
+
+func TestPublishSchemas(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	files := []*generator.File{
+		{Path: filepath.Join(dir, "api.proto"), ProtoPackage: "acme.api", Imports: []string{"billing.proto"}},
+		{Path: filepath.Join(dir, "billing.proto"), ProtoPackage: "acme.billing"},
+	}
+	for _, f := range files {
+		assert.NoError(ioutil.WriteFile(f.Path, []byte("// "+filepath.Base(f.Path)), 0666))
+	}
+	assert.Equal("acme.api.api", registrySubject("{package}.{file}", files[0]))
+	assert.Equal("Order-value", registrySubject("{file}-value", &generator.File{Path: "Order.schema.json"}))
+
+	var requests []string
+	registered := make(map[string]registrySchema)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		assert.Equal("key:secret", user+":"+password)
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		var body registrySchema
+		json.NewDecoder(r.Body).Decode(&body)
+		switch {
+		case r.URL.Path == "/subjects/broken-value/versions":
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `{"error_code":409,"message":"Schema being registered is incompatible with an earlier schema"}`)
+		case strings.HasSuffix(r.URL.Path, "/versions"):
+			registered[r.URL.Path] = body
+			fmt.Fprint(w, `{"id":7}`)
+		case strings.HasPrefix(r.URL.Path, "/subjects/"):
+			fmt.Fprint(w, `{"id":7,"version":3}`)
+		}
+	}))
+	defer srv.Close()
+
+	client, err := newRegistryClient(strings.Replace(srv.URL, "://", "://key:secret@", 1) + "/")
+	assert.NoError(err)
+	assert.NoError(publishSchemas(client, files, "{file}-value", "BACKWARD"))
+	assert.Equal([]string{
+		"PUT /config/billing-value", "POST /subjects/billing-value/versions", "POST /subjects/billing-value",
+		"PUT /config/api-value", "POST /subjects/api-value/versions", "POST /subjects/api-value",
+	}, requests)
+	assert.Equal(registrySchema{
+		SchemaType: "PROTOBUF",
+		Schema:     "// api.proto",
+		References: []registryReference{{Name: "billing.proto", Subject: "billing-value", Version: 3}},
+	}, registered["/subjects/api-value/versions"])
+
+	err = publishSchemas(client, files, "events", "")
+	assert.EqualError(err, `api.proto and billing.proto both map to subject "events", use {file} in -registry-subject`)
+
+	err = publishSchemas(client, files[1:], "broken-value", "")
+	assert.Error(err)
+	assert.Contains(err.Error(), "409 Conflict: Schema being registered is incompatible with an earlier schema")
+
+	_, err = newRegistryClient("localhost:8081")
+	assert.Error(err)
+}
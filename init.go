@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/beam-cloud/go2proto/pkg/generator"
+)
+
+// bufModuleDir is the directory `go2proto init` lays the generated files out in.
+const bufModuleDir = "proto"
+
+// bufLayoutPath returns where a proto package lives in a buf module, with directories
+// matching the package as buf lint expects: acme.events.v1 goes to
+// proto/acme/events/v1/events.proto.
+func bufLayoutPath(protoPackage string) string {
+	parts := strings.Split(protoPackage, ".")
+	name := parts[len(parts)-1]
+	if len(parts) > 1 && generator.IsPackageVersion(name) {
+		name = parts[len(parts)-2]
+	}
+	return filepath.Join(append(append([]string{bufModuleDir}, parts...), name+".proto")...)
+}
+
+const bufYAML = `# Generated by go2proto init for %s.
+version: v2
+modules:
+  - path: %s
+lint:
+  use:
+    - STANDARD
+breaking:
+  use:
+    - FILE
+`
+
+const bufGenYAML = `# Generated by go2proto %s for %s.
+version: v2
+inputs:
+  - directory: %s
+plugins:
+  - local: protoc-gen-go
+    out: gen
+    opt: paths=source_relative
+`
+
+// connectPlugin generates the connect-go handlers and clients of the services, into a
+// <package>connect package beside the protoc-gen-go code.
+const connectPlugin = "protoc-gen-connect-go"
+
+const connectGenYAML = `  - local: ` + connectPlugin + `
+    out: gen
+    opt: paths=source_relative
+`
+
+// bufGenConfig returns a buf.gen.yaml generating Go code for the proto files in input, and
+// connect-go code with connect. command names the go2proto invocation in its header.
+func bufGenConfig(command, protoPackage, input string, connect bool) string {
+	config := fmt.Sprintf(bufGenYAML, command, protoPackage, input)
+	if connect {
+		config += connectGenYAML
+	}
+	return config
+}
+
+// writeBufConfig writes starter buf.yaml and buf.gen.yaml files into dir for the module
+// holding protoPackage, the latter generating connect-go code too with connect. Existing
+// files are left untouched.
+func writeBufConfig(dir, protoPackage string, connect bool) error {
+	if err := writeConfigFile(filepath.Join(dir, "buf.yaml"), fmt.Sprintf(bufYAML, protoPackage, bufModuleDir)); err != nil {
+		return err
+	}
+	return writeGenConfig(dir, bufGenConfig("init", protoPackage, bufModuleDir, connect), connect)
+}
+
+// writeConnectConfig writes a buf.gen.yaml generating Go and connect-go code for the proto
+// files in outDir into dir, for -connect outside of init. An existing file is left untouched.
+func writeConnectConfig(dir, protoPackage, outDir string) error {
+	input := outDir
+	if rel, err := filepath.Rel(dir, outDir); err == nil && filepath.IsAbs(outDir) {
+		input = rel
+	}
+	return writeGenConfig(dir, bufGenConfig("-connect", protoPackage, filepath.ToSlash(input), true), true)
+}
+
+// writeGenConfig writes config as the buf.gen.yaml of dir, warning with connect when an
+// existing one, which is left untouched, doesn't run connectPlugin.
+func writeGenConfig(dir, config string, connect bool) error {
+	path := filepath.Join(dir, "buf.gen.yaml")
+	if existing, err := ioutil.ReadFile(path); err == nil && connect && !strings.Contains(string(existing), connectPlugin) {
+		warnf("%s has no %s plugin, add it to generate the connect-go code of the services", path, connectPlugin)
+	}
+	return writeConfigFile(path, config)
+}
+
+// writeConfigFile writes a starter config file unless path already exists.
+func writeConfigFile(path, contents string) error {
+	if _, err := os.Stat(path); err == nil {
+		infof("%s already exists, leaving it unchanged", path)
+		return nil
+	}
+	if err := ioutil.WriteFile(path, []byte(contents), 0666); err != nil {
+		return fmt.Errorf("unable to write %s: %w", path, err)
+	}
+	infof("buf config written to ===> %s", path)
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+)
+
+// Log levels, from least to most chatty.
+const (
+	levelQuiet = iota - 1
+	levelInfo
+	levelVerbose
+	levelDebug
+)
+
+// warnings counts the warnings emitted so far, including suppressed ones.
+var warnings int64
+
+// logLevel is the highest level that is printed; set from -q / -v / -vv.
+var logLevel = levelInfo
+
+// infof logs progress messages that are shown unless -q is set.
+func infof(format string, args ...interface{}) {
+	logAt(levelInfo, "", format, args...)
+}
+
+// warnf logs problems that don't stop generation but likely need attention.
+func warnf(format string, args ...interface{}) {
+	atomic.AddInt64(&warnings, 1)
+	logAt(levelInfo, "warning: ", format, args...)
+}
+
+// warningCount returns the number of warnings emitted so far.
+func warningCount() int64 {
+	return atomic.LoadInt64(&warnings)
+}
+
+// verbosef logs what was loaded and matched (-v).
+func verbosef(format string, args ...interface{}) {
+	logAt(levelVerbose, "", format, args...)
+}
+
+// debugf logs individual mapping decisions (-vv).
+func debugf(format string, args ...interface{}) {
+	logAt(levelDebug, "debug: ", format, args...)
+}
+
+// setLogFile redirects all log output to path, appending to any existing content.
+func setLogFile(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return fmt.Errorf("unable to open log file %s: %w", path, err)
+	}
+	log.SetOutput(f)
+	return nil
+}
+
+// fatalf logs a usage error and exits with exitUsageError.
+func fatalf(format string, args ...interface{}) {
+	log.Printf("error: "+format, args...)
+	os.Exit(exitUsageError)
+}
+
+// levelLogger hands the generator's log messages to logAt.
+type levelLogger struct {
+	level  int
+	prefix string
+}
+
+func (l levelLogger) Printf(format string, args ...interface{}) {
+	logAt(l.level, l.prefix, format, args...)
+}
+
+// logAt prints the message if level is enabled.
+func logAt(level int, prefix string, format string, args ...interface{}) {
+	if level > logLevel {
+		return
+	}
+	log.Print(prefix + fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,1250 @@
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/iancoleman/strcase"
+	"golang.org/x/tools/go/packages"
+)
+
+// packageModel holds the messages and enums discovered in a single package,
+// along with everything that was left out.
+type packageModel struct {
+	PkgPath     string
+	Messages    []*Message
+	Enums       []*Enum
+	Services    []*Service
+	Skipped     []*SkippedItem
+	Diagnostics []*Diagnostic
+}
+
+// Analyze collects both struct-based messages and named types we treat as "enums" from the
+// loaded packages, and reports every field and type that was skipped.
+func Analyze(pkgs []*packages.Package, opts *Options) (*Model, error) {
+	defer opts.Timings.Span(PhaseAnalysis)()
+	pkgNames := make(map[string]string, len(pkgs))
+	for _, p := range pkgs {
+		pkgNames[p.PkgPath] = p.Name
+	}
+	return buildModel(analyzePackages(pkgs, opts), pkgNames, opts)
+}
+
+// buildModel merges the models of the analysed packages, in order, and resolves what spans
+// packages: duplicate names, enum references and missing references. pkgNames maps the
+// import path of every package to its name.
+func buildModel(pms []*packageModel, pkgNames map[string]string, opts *Options) (*Model, error) {
+	model := &Model{}
+
+	// Map for enumerations: qualified Go name -> *Enum
+	enumMap := make(map[string]*Enum)
+
+	var errs ErrorList
+	for _, pm := range pms {
+		model.Skipped = append(model.Skipped, pm.Skipped...)
+		for _, d := range pm.Diagnostics {
+			if d.Severity == SeverityError {
+				errs = append(errs, d)
+			} else {
+				model.Diagnostics = append(model.Diagnostics, d)
+			}
+		}
+		for _, ed := range pm.Enums {
+			enumMap[ed.PkgPath+"."+ed.GoName] = ed
+			model.Enums = append(model.Enums, ed)
+		}
+		model.Messages = append(model.Messages, pm.Messages...)
+		model.Services = append(model.Services, pm.Services...)
+	}
+
+	if len(opts.ProtoPackages) > 0 {
+		applyProtoPackages(model, opts)
+	}
+	errs = append(errs, resolveDuplicates(model.Messages, pkgNames, opts)...)
+	// References are resolved by Go name when the output is planned, so renaming the
+	// messages renames them everywhere.
+	if opts.MessagePrefix != "" || opts.MessageSuffix != "" {
+		for _, msg := range model.Messages {
+			msg.Name = opts.MessagePrefix + msg.Name + opts.MessageSuffix
+		}
+	}
+	for _, msg := range model.Messages {
+		if msg.Name != msg.GoName {
+			opts.debugf("%s: emitting as message %s", msg.GoName, msg.Name)
+		}
+	}
+
+	// Enums may be declared in a different package than the fields using them,
+	// so they can only be resolved once every package has been analysed.
+	resolveEnums(model.Messages, enumMap, opts)
+	if opts.CollapseWrappers {
+		var kept []*Diagnostic
+		model.Messages, kept = collapseWrappers(model.Messages, opts)
+		model.Diagnostics = append(model.Diagnostics, kept...)
+	}
+	for i, hook := range opts.Hooks {
+		if err := hook(model); err != nil {
+			return nil, fmt.Errorf("hook %d: %w", i+1, err)
+		}
+	}
+
+	// Sort for stable output
+	sort.Slice(model.Messages, func(i, j int) bool { return model.Messages[i].Name < model.Messages[j].Name })
+	sort.Slice(model.Enums, func(i, j int) bool { return model.Enums[i].Name < model.Enums[j].Name })
+	sort.Slice(model.Services, func(i, j int) bool { return model.Services[i].Name < model.Services[j].Name })
+
+	for _, d := range append(missingReferences(model.Messages), missingServiceReferences(model.Services, model.Messages)...) {
+		if opts.Strict {
+			d.Severity = SeverityError
+			errs = append(errs, d)
+		} else {
+			model.Diagnostics = append(model.Diagnostics, d)
+		}
+	}
+
+	if errs.Errors() > 0 {
+		return nil, append(errs, model.Diagnostics...)
+	}
+	return model, nil
+}
+
+// applyProtoPackages places the messages, enums and services of the Go packages mapped by
+// opts.ProtoPackages in their proto package, unless their annotation sets one.
+func applyProtoPackages(model *Model, opts *Options) {
+	packageFor := func(goName, pkgPath, current string) string {
+		pkg, ok := opts.ProtoPackages[pkgPath]
+		if !ok || current != "" || pkg == opts.ProtoPackage {
+			return current
+		}
+		opts.debugf("%s: placed in proto package %s of %s", goName, pkg, pkgPath)
+		return pkg
+	}
+	for _, msg := range model.Messages {
+		msg.Package = packageFor(msg.GoName, msg.PkgPath, msg.Package)
+	}
+	for _, e := range model.Enums {
+		e.Package = packageFor(e.GoName, e.PkgPath, e.Package)
+	}
+	for _, svc := range model.Services {
+		svc.Package = packageFor(svc.GoName, svc.PkgPath, svc.Package)
+	}
+}
+
+// protoScalars are the scalar value types of proto3.
+var protoScalars = map[string]bool{
+	"double": true, "float": true, "int32": true, "int64": true, "uint32": true, "uint64": true,
+	"sint32": true, "sint64": true, "fixed32": true, "fixed64": true, "sfixed32": true,
+	"sfixed64": true, "bool": true, "string": true, "bytes": true,
+}
+
+// missingReferences reports the fields whose named type maps to neither a scalar, a
+// well-known type, an enum nor an annotated message, so the output would reference a
+// message that is never defined.
+func missingReferences(msgs []*Message) []*Diagnostic {
+	known := make(map[string]bool, len(msgs))
+	for _, msg := range msgs {
+		known[msg.PkgPath+"."+msg.GoName] = true
+	}
+
+	var result []*Diagnostic
+	for _, msg := range msgs {
+		for _, fd := range msg.Fields {
+			if fd.NamedType == "" || known[fd.NamedType] || len(fd.EnumValues) > 0 ||
+				protoScalars[fd.TypeName] || strings.HasPrefix(fd.TypeName, "google.protobuf.") {
+				continue
+			}
+			d := &Diagnostic{
+				Package:  msg.PkgPath,
+				Type:     msg.GoName,
+				Field:    fd.GoName,
+				Code:     CodeUnmappedType,
+				Message:  fmt.Sprintf("type %s is not annotated with %s and has no proto mapping", fd.NamedType, annotationMarker),
+				Severity: SeverityWarning,
+				File:     fd.Pos.Filename,
+				Line:     fd.Pos.Line,
+				Column:   fd.Pos.Column,
+			}
+			result = append(result, d)
+		}
+	}
+	return result
+}
+
+// analyzePackages analyses every package concurrently, returning the models in the same order as pkgs
+// so that merging them stays deterministic.
+func analyzePackages(pkgs []*packages.Package, opts *Options) []*packageModel {
+	models := make([]*packageModel, len(pkgs))
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(pkgs) {
+		workers = len(pkgs)
+	}
+
+	var mu sync.Mutex
+	done := 0
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				models[i] = analyzePackageCached(pkgs[i], opts)
+				if opts.Progress != nil {
+					mu.Lock()
+					done++
+					opts.Progress(done, len(pkgs), pkgs[i].PkgPath)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range pkgs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return models
+}
+
+// analyzePackageCached returns the package's model from the analysis cache if enabled and
+// up to date, analysing (and caching) the package otherwise.
+func analyzePackageCached(p *packages.Package, opts *Options) *packageModel {
+	if opts.CacheDir == "" {
+		return analyzePackage(p, opts)
+	}
+	c := &cache{dir: opts.CacheDir}
+
+	key, err := c.key(p, opts)
+	if err != nil {
+		opts.verbosef("cache disabled for %s: %s", p.PkgPath, err)
+		return analyzePackage(p, opts)
+	}
+	if model, ok := c.get(p.PkgPath, key); ok {
+		opts.verbosef("using cached analysis for %s", p.PkgPath)
+		return model
+	}
+	if p.Types == nil {
+		// Load found the entry up to date, but it changed since.
+		return &packageModel{PkgPath: p.PkgPath, Diagnostics: []*Diagnostic{{
+			Package:  p.PkgPath,
+			Code:     CodeCache,
+			Message:  "cached analysis changed while running, run again",
+			Severity: SeverityError,
+		}}}
+	}
+
+	model := analyzePackage(p, opts)
+	if err := c.put(p.PkgPath, key, model); err != nil {
+		opts.verbosef("unable to cache analysis for %s: %s", p.PkgPath, err)
+	}
+	return model
+}
+
+// analyzePackage collects the annotated messages and enums declared in a single package.
+func analyzePackage(p *packages.Package, opts *Options) *packageModel {
+	model := &packageModel{PkgPath: p.PkgPath}
+
+	annotated := annotatedTypes(p.Syntax)
+	packageConstMap := gatherConstValues(p.Syntax)
+	naming := opts.naming()
+
+	// Enums of annotated const blocks come first, so their types aren't turned into enums
+	// a second time below.
+	constEnums := make(map[string]bool)
+	for _, ed := range constBlockEnums(p, opts) {
+		if !matchesFilters(ed.Name, opts.Filters) {
+			opts.debugf("%s: annotated const block does not match -filter, skipping", ed.Name)
+			continue
+		}
+		opts.verbosef("matched const block enum %s (%d values)", ed.Name, len(ed.Values))
+		constEnums[ed.PkgPath+"."+ed.GoName] = true
+		model.Enums = append(model.Enums, ed)
+	}
+
+	// Scope names are sorted, which keeps the analysis order deterministic.
+	var defs []types.Object
+	scope := p.Types.Scope()
+	for _, name := range scope.Names() {
+		def, ok := scope.Lookup(name).(*types.TypeName)
+		if !ok {
+			continue
+		}
+		if _, ok := annotated[name]; !ok {
+			continue
+		}
+		if !matchesFilters(name, opts.Filters) {
+			opts.debugf("%s: annotated type does not match -filter, skipping", name)
+			model.Skipped = append(model.Skipped, newSkippedItem(p.Fset, def.Pos(), p.PkgPath, name, "", "does not match -filter"))
+			continue
+		}
+		defs = append(defs, def)
+	}
+
+	model.Diagnostics = append(model.Diagnostics, ineffectiveAnnotations(p, annotated)...)
+	generated := make(map[string]bool, len(defs))
+
+	// **First Pass: Collect all enum-like types**
+	for _, def := range defs {
+		// **Check if the type is a named type with a basic underlying type**
+		if named, ok := def.Type().(*types.Named); ok {
+			if _, ok := named.Underlying().(*types.Basic); ok {
+				if constEnums[p.PkgPath+"."+def.Name()] {
+					generated[def.Name()] = true
+					continue
+				}
+				enumValues := packageConstMap[def.Name()]
+				if len(enumValues) == 0 {
+					opts.debugf("%s: annotated type has no typed constants, not an enum", def.Name())
+					continue
+				}
+				opts.verbosef("matched enum %s.%s (%d values)", p.PkgPath, def.Name(), len(enumValues))
+
+				ann := annotated[def.Name()]
+				ed := &Enum{
+					Name:       naming.EnumName(named.Obj().Name()),
+					GoName:     named.Obj().Name(),
+					PkgPath:    p.PkgPath,
+					Package:    ann.Package,
+					Values:     enumValueNames(enumValues, naming),
+					Deprecated: ann.Deprecated,
+					Pos:        p.Fset.Position(def.Pos()),
+				}
+				if ann.Name != "" {
+					ed.Name = ann.Name
+				}
+				generated[def.Name()] = true
+				model.Enums = append(model.Enums, ed)
+			}
+		}
+	}
+
+	// **Second Pass: Process structs and their fields**
+	for _, def := range defs {
+		if s, ok := def.Type().Underlying().(*types.Struct); ok && !annotated[def.Name()].Service {
+			opts.verbosef("matched message %s.%s", p.PkgPath, def.Name())
+			addMessage(p, def, s, annotated[def.Name()], model, opts)
+			generated[def.Name()] = true
+		}
+	}
+
+	// **Third Pass: Services, whose requests and responses may need messages of their own**
+	for _, def := range defs {
+		if _, ok := def.Type().Underlying().(*types.Struct); ok && annotated[def.Name()].Service {
+			opts.verbosef("matched service %s.%s", p.PkgPath, def.Name())
+			addService(p, def.(*types.TypeName), annotated[def.Name()], model, opts)
+			generated[def.Name()] = true
+		}
+	}
+
+	for _, def := range defs {
+		if generated[def.Name()] {
+			continue
+		}
+		reason := fmt.Sprintf("%s types are neither messages nor enums", typeKind(def.Type()))
+		if _, ok := def.Type().Underlying().(*types.Basic); ok {
+			reason = fmt.Sprintf("%s has no constants of its type, so it is not an enum", def.Name())
+		}
+		model.Diagnostics = append(model.Diagnostics, newDiagnostic(p.Fset, def.Pos(), p.PkgPath, def.Name(), "", CodeIneffectiveAnnotation,
+			fmt.Sprintf("%s has no effect: %s", annotationMarker, reason)))
+	}
+
+	return model
+}
+
+// addMessage adds the message of struct def, with the arguments of its annotation applied.
+func addMessage(p *packages.Package, def types.Object, s *types.Struct, ann annotation, model *packageModel, opts *Options) *Message {
+	msg := appendMessage(p, def, s, model, opts)
+	if ann.Name != "" {
+		msg.Name = ann.Name
+	}
+	if name, d := asciiIdentifier("message", msg.Name, opts.NonASCII); d != nil {
+		model.Diagnostics = append(model.Diagnostics, d.at(p.Fset, def.Pos(), p.PkgPath, def.Name(), ""))
+		msg.Name = name
+	}
+	if name, ok := escapeIdentifier(msg.Name); ok {
+		model.Diagnostics = append(model.Diagnostics, newDiagnostic(p.Fset, def.Pos(), p.PkgPath, def.Name(), "", CodeInvalidIdentifier,
+			fmt.Sprintf("message name %q is not a valid proto identifier, emitting it as %q", msg.Name, name)))
+		msg.Name = name
+	}
+	msg.Package = ann.Package
+	msg.Constraints = ann.Constraints
+	msg.Deprecated = ann.Deprecated
+	msg.Options = append(msg.Options, ann.Options...)
+	model.Diagnostics = append(model.Diagnostics, groupOneofs(p, def, s, msg, ann.Oneofs)...)
+	msg.Pos = p.Fset.Position(def.Pos())
+	model.Messages = append(model.Messages, msg)
+	return msg
+}
+
+// groupOneofs places the fields of msg listed by oneofs in their oneof, moving the fields
+// of each oneof next to its first one. It returns warnings for the listed fields it can't
+// place: fields left out of the message, repeated or not pointers, or already in a oneof.
+func groupOneofs(p *packages.Package, def types.Object, s *types.Struct, msg *Message, oneofs []oneofGroup) []*Diagnostic {
+	if len(oneofs) == 0 {
+		return nil
+	}
+	var result []*Diagnostic
+	for _, group := range oneofs {
+		for _, name := range group.Fields {
+			var fd *Field
+			for _, f := range msg.Fields {
+				if f.GoName == name {
+					fd = f
+				}
+			}
+			var goField *types.Var
+			pos := def.Pos()
+			for i := 0; i < s.NumFields(); i++ {
+				if s.Field(i).Name() == name {
+					goField, pos = s.Field(i), s.Field(i).Pos()
+				}
+			}
+			warn := func(reason string) {
+				result = append(result, newDiagnostic(p.Fset, pos, p.PkgPath, def.Name(), name, CodeIneffectiveAnnotation,
+					fmt.Sprintf("%s oneof %s: field %s %s, leaving it out of the oneof", annotationMarker, group.Name, name, reason)))
+			}
+			switch {
+			case goField == nil:
+				warn("is not a field of the struct")
+			case fd == nil:
+				warn("was left out of the message")
+			case fd.IsRepeated:
+				warn("is repeated, which oneof fields can't be")
+			case fd.MapKey != "":
+				warn("is a map, which oneof fields can't be")
+			case fd.Oneof != "":
+				warn("is already in oneof " + fd.Oneof)
+			default:
+				if _, ok := goField.Type().Underlying().(*types.Pointer); !ok {
+					warn("is not a pointer, so it is always set")
+					continue
+				}
+				fd.Oneof = group.Name
+			}
+		}
+	}
+
+	fields := make([]*Field, 0, len(msg.Fields))
+	placed := make(map[string]bool)
+	for _, fd := range msg.Fields {
+		switch {
+		case fd.Oneof == "":
+			fields = append(fields, fd)
+		case !placed[fd.Oneof]:
+			placed[fd.Oneof] = true
+			for _, member := range msg.Fields {
+				if member.Oneof == fd.Oneof {
+					fields = append(fields, member)
+				}
+			}
+		}
+	}
+	msg.Fields = fields
+	return result
+}
+
+// ineffectiveAnnotations reports the annotations on declarations that never produce output:
+// funcs other than streaming service methods, vars and const blocks without enum=. It also
+// reports the malformed arguments and directives every annotation leaves out.
+func ineffectiveAnnotations(p *packages.Package, annotated map[string]annotation) []*Diagnostic {
+	var result []*Diagnostic
+	report := func(pos token.Pos, name, reason string) {
+		result = append(result, newDiagnostic(p.Fset, pos, p.PkgPath, name, "", CodeIneffectiveAnnotation,
+			fmt.Sprintf("%s has no effect: %s", annotationMarker, reason)))
+	}
+	// reportProblems reports the arguments and directives left out of ann.
+	reportProblems := func(ann annotation, name string) {
+		for _, problem := range ann.Problems {
+			result = append(result, newDiagnostic(p.Fset, problem.Pos, p.PkgPath, name, "", CodeIneffectiveAnnotation, problem.Reason))
+		}
+	}
+	for _, file := range p.Syntax {
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				ann, ok := parseAnnotation(decl.Doc)
+				reportProblems(ann, decl.Name.Name)
+				switch {
+				case !ok:
+				case !annotated[receiverTypeName(decl)].Service:
+					report(decl.Pos(), decl.Name.Name, "funcs are neither messages nor enums")
+				case !ann.Stream && ann.Idempotency == "":
+					report(decl.Pos(), decl.Name.Name, "methods of a service only take stream and idempotency")
+				}
+			case *ast.GenDecl:
+				ann, ok := parseAnnotation(decl.Doc)
+				name := ""
+				if decl.Tok == token.TYPE && !decl.Lparen.IsValid() {
+					name = decl.Specs[0].(*ast.TypeSpec).Name.Name
+				}
+				reportProblems(ann, name)
+				switch {
+				case !ok:
+				case decl.Tok == token.VAR:
+					report(decl.Pos(), "", "vars are neither messages nor enums")
+				case decl.Tok == token.CONST && ann.Enum == "":
+					report(decl.Pos(), "", "const blocks need enum=<name> to define an enum")
+				}
+				for _, spec := range decl.Specs {
+					if typeSpec, ok := spec.(*ast.TypeSpec); ok {
+						ann, _ := parseAnnotation(typeSpec.Doc)
+						reportProblems(ann, typeSpec.Name.Name)
+					}
+				}
+			}
+		}
+	}
+	return result
+}
+
+// typeKind describes the kind of t for diagnostics, e.g. "func" or "map".
+func typeKind(t types.Type) string {
+	switch t.Underlying().(type) {
+	case *types.Signature:
+		return "func"
+	case *types.Interface:
+		return "interface"
+	case *types.Map:
+		return "map"
+	case *types.Slice:
+		return "slice"
+	case *types.Array:
+		return "array"
+	case *types.Chan:
+		return "channel"
+	case *types.Pointer:
+		return "pointer"
+	default:
+		return t.Underlying().String()
+	}
+}
+
+// matchesFilters reports whether name contains any of the filters, ignoring case.
+// An empty filter list matches everything.
+func matchesFilters(name string, filters []string) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	name = strings.ToLower(name)
+	for _, f := range filters {
+		if strings.Contains(name, strings.ToLower(f)) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveDuplicates finds messages emitted under the same name in the same proto package.
+// With opts.Duplicates set to DuplicatesPrefix each of them is prefixed with its Go package
+// name ("Config" becomes "BillingConfig"); otherwise, or if prefixing doesn't separate them,
+// every declaration is reported as an error.
+func resolveDuplicates(msgs []*Message, pkgNames map[string]string, opts *Options) []*Diagnostic {
+	groups := duplicateMessages(msgs)
+	if len(groups) > 0 && opts.Duplicates == DuplicatesPrefix {
+		for _, dups := range groups {
+			for _, msg := range dups {
+				name := strcase.ToCamel(pkgNames[msg.PkgPath]) + msg.Name
+				opts.verbosef("renaming duplicate message %s.%s to %s", msg.PkgPath, msg.GoName, name)
+				msg.Name = name
+			}
+		}
+		// Packages sharing a name still collide.
+		groups = duplicateMessages(msgs)
+	}
+
+	var result []*Diagnostic
+	for _, dups := range groups {
+		for _, msg := range dups {
+			var others []string
+			for _, other := range dups {
+				if other != msg {
+					others = append(others, other.PkgPath+"."+other.GoName)
+				}
+			}
+			result = append(result, &Diagnostic{
+				Package: msg.PkgPath,
+				Type:    msg.GoName,
+				Code:    CodeDuplicateName,
+				Message: fmt.Sprintf("message %s is also declared by %s, rename it with @go2proto(name=...) or prefix it with its package",
+					msg.Name, strings.Join(others, " and ")),
+				Severity: SeverityError,
+				File:     msg.Pos.Filename,
+				Line:     msg.Pos.Line,
+				Column:   msg.Pos.Column,
+			})
+		}
+	}
+	return result
+}
+
+// duplicateMessages groups the messages sharing a name within a proto package, in the order
+// they were first seen.
+func duplicateMessages(msgs []*Message) [][]*Message {
+	byName := make(map[string][]*Message)
+	var keys []string
+	for _, msg := range msgs {
+		key := msg.Package + " " + msg.Name
+		if _, ok := byName[key]; !ok {
+			keys = append(keys, key)
+		}
+		byName[key] = append(byName[key], msg)
+	}
+
+	var groups [][]*Message
+	for _, key := range keys {
+		if len(byName[key]) > 1 {
+			groups = append(groups, byName[key])
+		}
+	}
+	return groups
+}
+
+// resolveEnums turns fields whose Go type is a recognized enum into strings listing the possible values.
+func resolveEnums(msgs []*Message, enumMap map[string]*Enum, opts *Options) {
+	for _, msg := range msgs {
+		for _, fd := range msg.Fields {
+			if ed, ok := enumMap[fd.NamedType]; ok {
+				opts.debugf("%s.%s: type %s is the enum %s, emitting as string", msg.GoName, fd.GoName, fd.NamedType, ed.Name)
+				fd.TypeName = "string"
+				fd.EnumValues = ed.Values
+			}
+		}
+	}
+}
+
+// constBlockEnums returns the enums of the const blocks annotated with "@go2proto enum=Name".
+// The constants may be untyped, or of a type declared in another package: fields of that
+// type then list the enum's values. Values are the constants' strings, or their names for
+// other kinds.
+func constBlockEnums(p *packages.Package, opts *Options) []*Enum {
+	naming := opts.naming()
+	var result []*Enum
+	for _, file := range p.Syntax {
+		for _, decl := range file.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Tok != token.CONST {
+				continue
+			}
+			ann, ok := parseAnnotation(genDecl.Doc)
+			if !ok {
+				continue
+			}
+			if ann.Enum == "" {
+				continue // reported by ineffectiveAnnotations
+			}
+
+			ed := &Enum{Name: ann.Enum, GoName: ann.Enum, PkgPath: p.PkgPath, Package: ann.Package, Deprecated: ann.Deprecated,
+				Pos: p.Fset.Position(genDecl.Pos())}
+			for _, spec := range genDecl.Specs {
+				vspec := spec.(*ast.ValueSpec)
+				if hasSkipDirective(vspec.Doc, vspec.Comment) {
+					continue
+				}
+				for _, name := range vspec.Names {
+					c, ok := p.Types.Scope().Lookup(name.Name).(*types.Const)
+					if !ok {
+						continue // blank identifiers
+					}
+					if named, ok := c.Type().(*types.Named); ok && len(ed.Values) == 0 && named.Obj().Pkg() != nil {
+						ed.GoName = named.Obj().Name()
+						ed.PkgPath = named.Obj().Pkg().Path()
+					}
+					value := c.Name()
+					if c.Val().Kind() == constant.String {
+						value = constant.StringVal(c.Val())
+					}
+					ed.Values = append(ed.Values, value)
+				}
+			}
+			if len(ed.Values) > 0 {
+				ed.Values = enumValueNames(ed.Values, naming)
+				result = append(result, ed)
+			}
+		}
+	}
+	return result
+}
+
+// enumValueNames applies naming to the values of an enum.
+func enumValueNames(values []string, naming Naming) []string {
+	result := make([]string, len(values))
+	for i, v := range values {
+		result[i] = naming.EnumValue(v)
+	}
+	return result
+}
+
+// gatherConstValues scans AST for const blocks, collecting any constants declared with a named type.
+// Blank constants and those marked with skipDirective are left out.
+func gatherConstValues(files []*ast.File) map[string][]string {
+	result := make(map[string][]string)
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range genDecl.Specs {
+				vspec, ok := spec.(*ast.ValueSpec)
+				if !ok {
+					continue
+				}
+				var typeName string
+				if vspec.Type != nil {
+					if ident, ok := vspec.Type.(*ast.Ident); ok {
+						typeName = ident.Name
+					}
+				}
+				if typeName == "" {
+					// If there's no explicit type, skip
+					continue
+				}
+				if hasSkipDirective(vspec.Doc, vspec.Comment) {
+					continue
+				}
+				for i, name := range vspec.Names {
+					if name.Name == "_" {
+						continue
+					}
+					// Default to the identifier name in case there's no assigned value
+					valStr := name.Name
+
+					// If we have a literal and it's a string, take that as the actual value
+					if i < len(vspec.Values) {
+						if lit, ok := vspec.Values[i].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+							valStr = strings.Trim(lit.Value, `"`)
+						}
+					}
+
+					result[typeName] = append(result[typeName], valStr)
+				}
+			}
+		}
+	}
+	return result
+}
+
+// annotatedTypes returns the annotation of every type with a "@go2proto" comment above it, keyed by type name.
+// The package's files are already parsed with comments, so this is a single pass over the syntax.
+//
+// A comment above a grouped declaration, type ( A struct{...}; B struct{...} ), annotates
+// every type in the group; a comment above one type of the group annotates that type, and
+// takes precedence over the group's.
+func annotatedTypes(files []*ast.File) map[string]annotation {
+	result := make(map[string]annotation)
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Tok != token.TYPE {
+				continue
+			}
+			groupAnn, grouped := parseAnnotation(genDecl.Doc)
+			for _, spec := range genDecl.Specs {
+				typeSpec, ok := spec.(*ast.TypeSpec)
+				if !ok {
+					continue
+				}
+				if ann, ok := parseAnnotation(typeSpec.Doc); ok {
+					result[typeSpec.Name.Name] = ann
+				} else if grouped {
+					result[typeSpec.Name.Name] = groupAnn
+				}
+			}
+		}
+	}
+	return result
+}
+
+// appendMessage builds a "message" object from a struct, recording the fields it left out
+// or renamed in model.
+func appendMessage(p *packages.Package, def types.Object, s *types.Struct, model *packageModel, opts *Options) *Message {
+	naming := opts.naming()
+	msg := &Message{
+		Name:    naming.MessageName(def.Name()),
+		GoName:  def.Name(),
+		PkgPath: p.PkgPath,
+		Fields:  make([]*Field, 0, s.NumFields()),
+	}
+	// pinned maps the numbers set by number tags to the Go names of their fields.
+	pinned := make(map[int]string)
+	for i := 0; i < s.NumFields(); i++ {
+		fld := s.Field(i)
+		if !fld.Exported() {
+			opts.verbosef("%s.%s: skipping field, not exported", def.Name(), fld.Name())
+			model.Skipped = append(model.Skipped, newSkippedItem(p.Fset, fld.Pos(), p.PkgPath, def.Name(), fld.Name(), "not exported"))
+			continue
+		}
+		if reason := unsupportedFieldType(fld.Type()); reason != "" {
+			item := newSkippedItem(p.Fset, fld.Pos(), p.PkgPath, def.Name(), fld.Name(), reason)
+			item.Warning = true
+			model.Skipped = append(model.Skipped, item)
+			continue
+		}
+		if reason := invalidMapKey(fld.Type()); reason != "" {
+			model.Diagnostics = append(model.Diagnostics, (&Diagnostic{Code: CodeInvalidMapKey, Message: reason, Severity: SeverityError}).
+				at(p.Fset, fld.Pos(), p.PkgPath, def.Name(), fld.Name()))
+			continue
+		}
+		fd := &Field{
+			Name:       naming.FieldName(fld.Name()),
+			GoName:     fld.Name(),
+			Pos:        p.Fset.Position(fld.Pos()),
+			Order:      i + 1,
+			IsRepeated: isRepeated(fld),
+		}
+		opts.debugf("%s.%s: normalised field name to %s", def.Name(), fld.Name(), fd.Name)
+		tag := fieldTag(s.Tag(i))
+		if name := tag["name"]; name != "" {
+			opts.debugf("%s.%s: field name %s set by its tag", def.Name(), fld.Name(), name)
+			fd.Name = name
+		}
+		if name, d := asciiIdentifier("field", fd.Name, opts.NonASCII); d != nil {
+			model.Diagnostics = append(model.Diagnostics, d.at(p.Fset, fld.Pos(), p.PkgPath, def.Name(), fld.Name()))
+			fd.Name = name
+		}
+		if name, ok := escapeIdentifier(fd.Name); ok {
+			opts.debugf("%s.%s: %s is not a valid proto identifier, emitting it as %s", def.Name(), fld.Name(), fd.Name, name)
+			model.Diagnostics = append(model.Diagnostics, newDiagnostic(p.Fset, fld.Pos(), p.PkgPath, def.Name(), fld.Name(), CodeInvalidIdentifier,
+				fmt.Sprintf("field name %q is not a valid proto identifier, emitting it as %q", fd.Name, name)))
+			fd.Name = name
+		}
+
+		// determine the type name (may become "string" if recognized as an enum)
+		fd.TypeName = toProtoFieldTypeName(fld, fd)
+		if reason := mapField(fld.Type(), fd); reason != "" {
+			model.Diagnostics = append(model.Diagnostics, (&Diagnostic{Code: CodeInvalidMapValue, Message: reason, Severity: SeverityError}).
+				at(p.Fset, fld.Pos(), p.PkgPath, def.Name(), fld.Name()))
+			continue
+		}
+		if isFieldMask(fd.NamedType, opts) {
+			fd.TypeName = "google.protobuf.FieldMask"
+		}
+		problems := []string{
+			applyNumber(fd, tag["number"], pinned),
+			applyDuration(fd, tag["duration"], opts),
+			applyTime(fd, tag["time"], opts),
+			applyAnyStrategy(fd, fld.Type(), tag["any"], opts),
+		}
+		for _, problem := range problems {
+			if problem != "" {
+				model.Diagnostics = append(model.Diagnostics, newDiagnostic(p.Fset, fld.Pos(), p.PkgPath, def.Name(), fld.Name(), CodeIneffectiveAnnotation, problem))
+			}
+		}
+		opts.debugf("%s.%s: mapped Go type %s to proto %s %s", def.Name(), fld.Name(), fld.Type(), fd.TypeName, fd.Name)
+
+		msg.Fields = append(msg.Fields, fd)
+	}
+	avoidPinnedNumbers(msg)
+	return msg
+}
+
+// unsupportedFieldType returns why a field of type t can't be represented in proto
+// (channels, funcs and unsafe pointers hold runtime state, not data), or "" if it can.
+func unsupportedFieldType(t types.Type) string {
+	t, _ = elemType(t, nil)
+	switch under := t.Underlying().(type) {
+	case *types.Chan:
+		return fmt.Sprintf("channel type %s has no proto representation", t)
+	case *types.Signature:
+		return fmt.Sprintf("func type %s has no proto representation", t)
+	case *types.Basic:
+		if under.Kind() == types.UnsafePointer {
+			return "unsafe.Pointer has no proto representation"
+		}
+	}
+	return ""
+}
+
+// elemType returns the type the pointers, slices and arrays t is made of hold, down to the
+// first type stop, if not nil, reports true for, and whether that took a slice or an array.
+// Recursive types such as type L []L hold themselves, so it ends at the first type seen twice.
+func elemType(t types.Type, stop func(types.Type) bool) (elem types.Type, repeated bool) {
+	seen := make(map[types.Type]bool)
+	for !seen[t] && (stop == nil || !stop(t)) {
+		seen[t] = true
+		switch under := t.Underlying().(type) {
+		case *types.Pointer:
+			t = under.Elem()
+		case *types.Slice:
+			t, repeated = under.Elem(), true
+		case *types.Array:
+			t, repeated = under.Elem(), true
+		default:
+			return t, repeated
+		}
+	}
+	return t, repeated
+}
+
+// fieldMaskType is the Go type of google.protobuf.FieldMask.
+const fieldMaskType = "google.golang.org/protobuf/types/known/fieldmaskpb.FieldMask"
+
+// isFieldMask reports whether the named type, as import/path.Name, is emitted as
+// google.protobuf.FieldMask.
+func isFieldMask(named string, opts *Options) bool {
+	if named == fieldMaskType {
+		return true
+	}
+	for _, t := range opts.FieldMaskTypes {
+		if named == t {
+			return true
+		}
+	}
+	return false
+}
+
+// applyNumber pins fd to the field number of its number tag, if not empty, valid and not
+// already pinned to another field of the message. It returns what is wrong with the tag, if anything.
+func applyNumber(fd *Field, tagged string, pinned map[int]string) (problem string) {
+	if tagged == "" {
+		return ""
+	}
+	n, err := strconv.Atoi(tagged)
+	switch {
+	case err != nil || n < 1 || n > maxFieldNumber || (n >= firstReservedFieldNum && n <= lastReservedFieldNum):
+		return fmt.Sprintf("%s tag number=%s has no effect: expected a field number from 1 to %d, outside %d-%d", fieldTagKey, tagged, maxFieldNumber, firstReservedFieldNum, lastReservedFieldNum)
+	case pinned[n] != "":
+		return fmt.Sprintf("%s tag number=%s has no effect: field %s already has number %d", fieldTagKey, tagged, pinned[n], n)
+	}
+	pinned[n] = fd.GoName
+	fd.Order, fd.Pinned = n, true
+	return ""
+}
+
+// applyDuration represents the time.Duration field fd as set by its duration tag, if not
+// empty and valid, or opts.Durations. It returns what is wrong with the tag, if anything.
+func applyDuration(fd *Field, tagged string, opts *Options) (problem string) {
+	isDuration := fd.NamedType == "time.Duration"
+	mode := opts.Durations
+	switch {
+	case tagged == "":
+	case !isDuration:
+		return fmt.Sprintf("%s tag duration=%s has no effect: the field is not a time.Duration", fieldTagKey, tagged)
+	case tagged != DurationNanos && tagged != DurationMillis && tagged != DurationWellKnown:
+		problem = fmt.Sprintf("%s tag duration=%s has no effect: expected %s, %s or %s", fieldTagKey, tagged, DurationNanos, DurationMillis, DurationWellKnown)
+	default:
+		mode = tagged
+	}
+	if !isDuration {
+		return ""
+	}
+	// Pointers and slices of durations are named after the Go type, not its int64.
+	switch mode {
+	case DurationMillis:
+		opts.debugf("%s: emitting time.Duration as int64 milliseconds", fd.GoName)
+		fd.TypeName, fd.Comment = "int64", "duration in milliseconds"
+	case DurationWellKnown:
+		opts.debugf("%s: emitting time.Duration as google.protobuf.Duration", fd.GoName)
+		fd.TypeName = "google.protobuf.Duration"
+	default:
+		fd.TypeName = "int64"
+	}
+	return problem
+}
+
+// applyTime represents the time.Time field fd as set by its time tag, if not empty and
+// valid, or opts.Times. It returns what is wrong with the tag, if anything.
+func applyTime(fd *Field, tagged string, opts *Options) (problem string) {
+	isTime := fd.NamedType == "time.Time"
+	mode := opts.Times
+	switch {
+	case tagged == "":
+	case !isTime:
+		return fmt.Sprintf("%s tag time=%s has no effect: the field is not a time.Time", fieldTagKey, tagged)
+	case tagged != TimeTimestamp && tagged != TimeUnixSeconds && tagged != TimeUnixMillis:
+		problem = fmt.Sprintf("%s tag time=%s has no effect: expected %s, %s or %s", fieldTagKey, tagged, TimeTimestamp, TimeUnixSeconds, TimeUnixMillis)
+	default:
+		mode = tagged
+	}
+	if !isTime {
+		return ""
+	}
+	switch mode {
+	case TimeUnixSeconds:
+		opts.debugf("%s: emitting time.Time as int64 unix seconds", fd.GoName)
+		fd.TypeName, fd.Comment = "int64", "unix time in seconds"
+	case TimeUnixMillis:
+		opts.debugf("%s: emitting time.Time as int64 unix milliseconds", fd.GoName)
+		fd.TypeName, fd.Comment = "int64", "unix time in milliseconds"
+	default:
+		fd.TypeName = "google.protobuf.Timestamp"
+	}
+	return problem
+}
+
+// Kinds of dynamic types, see dynamicType.
+const (
+	dynamicValue  = "value"
+	dynamicStruct = "struct"
+)
+
+// rawJSONTypes hold encoded JSON values. json.RawMessage is an alias of jsontext.Value
+// with the JSON v2 experiment.
+var rawJSONTypes = map[string]bool{"encoding/json.RawMessage": true, "encoding/json/jsontext.Value": true}
+
+// dynamicType returns the kind of dynamic type t, or the type its pointers or slices hold,
+// is: dynamicStruct for map[string]interface{}, dynamicValue for interface{} and
+// json.RawMessage, or "" for other types. repeated reports whether t is a slice of them.
+func dynamicType(t types.Type) (kind string, repeated bool) {
+	t, repeated = elemType(t, func(t types.Type) bool {
+		_, isArray := t.Underlying().(*types.Array)
+		return isArray || isRawJSON(t)
+	})
+	if isRawJSON(t) {
+		return dynamicValue, repeated
+	}
+	switch under := t.Underlying().(type) {
+	case *types.Interface:
+		if under.Empty() {
+			return dynamicValue, repeated
+		}
+	case *types.Map:
+		key, ok := under.Key().Underlying().(*types.Basic)
+		value, isInterface := under.Elem().Underlying().(*types.Interface)
+		if ok && key.Info()&types.IsString != 0 && isInterface && value.Empty() {
+			return dynamicStruct, repeated
+		}
+	}
+	return "", false
+}
+
+// isRawJSON reports whether t is one of rawJSONTypes.
+func isRawJSON(t types.Type) bool {
+	// Both *types.Named and *types.Alias name their type.
+	named, ok := t.(interface{ Obj() *types.TypeName })
+	return ok && rawJSONTypes[qualifiedName(named.Obj())]
+}
+
+// applyAnyStrategy represents the field fd of type t, if dynamic, as set by its any tag,
+// if not empty and valid, or opts.AnyStrategy. It returns what is wrong with the tag, if
+// anything.
+func applyAnyStrategy(fd *Field, t types.Type, tagged string, opts *Options) (problem string) {
+	kind, repeated := dynamicType(t)
+	strategy := opts.AnyStrategy
+	switch {
+	case tagged == "":
+	case kind == "":
+		return fmt.Sprintf("%s tag any=%s has no effect: the field is not an interface{}, map[string]interface{} or json.RawMessage", fieldTagKey, tagged)
+	case tagged != AnyStrategyAny && tagged != AnyStrategyStruct && tagged != AnyStrategyBytes:
+		problem = fmt.Sprintf("%s tag any=%s has no effect: expected %s, %s or %s", fieldTagKey, tagged, AnyStrategyAny, AnyStrategyStruct, AnyStrategyBytes)
+	default:
+		strategy = tagged
+	}
+	if kind == "" {
+		return ""
+	}
+	fd.IsRepeated = repeated
+	switch {
+	case strategy == AnyStrategyAny:
+		fd.TypeName = "google.protobuf.Any"
+	case strategy == AnyStrategyBytes:
+		fd.TypeName, fd.Comment = "bytes", "JSON-encoded"
+	case kind == dynamicStruct:
+		fd.TypeName = "google.protobuf.Struct"
+	default:
+		fd.TypeName = "google.protobuf.Value"
+	}
+	opts.debugf("%s: emitting dynamic field as %s", fd.GoName, fd.TypeName)
+	return problem
+}
+
+// invalidMapKey returns why the map type t, or the map its pointers, slices or arrays hold,
+// can't be a proto map because of its key type, with a workaround, or "" if it can. Proto
+// maps are keyed by integers, bools or strings only.
+func invalidMapKey(t types.Type) string {
+	t, _ = elemType(t, nil)
+	m, ok := t.Underlying().(*types.Map)
+	if !ok {
+		return ""
+	}
+	key := types.TypeString(m.Key(), (*types.Package).Name)
+	var workaround string
+	switch k := m.Key().Underlying().(type) {
+	case *types.Basic:
+		// uintptr is an integer in Go, but has no proto counterpart.
+		if k.Info()&(types.IsInteger|types.IsBoolean|types.IsString) != 0 && k.Kind() != types.Uintptr {
+			return ""
+		}
+		workaround = fmt.Sprintf("format the keys as strings, or use a repeated entry message with a %s key field and a value field instead", key)
+	case *types.Struct, *types.Array:
+		workaround = "use a repeated entry message with the key's fields and a value field instead, or key the map by a string encoding the key"
+	case *types.Interface:
+		workaround = "key the map by a concrete integer or string type"
+	default:
+		workaround = "use a repeated entry message with a key and a value field instead"
+	}
+	return fmt.Sprintf("map key type %s can't key a proto map, whose keys are integers, bools or strings: %s", key, workaround)
+}
+
+// mapField makes fd the proto map the Go map type t, or the map its pointer holds, stands
+// for: MapKey is set to its key type, and TypeName and NamedType to its value type. It
+// returns why t can't be a proto map, or "" if it can or isn't a map. Keys are checked by
+// invalidMapKey, and map[string]interface{} is left to applyAnyStrategy.
+func mapField(t types.Type, fd *Field) string {
+	if kind, _ := dynamicType(t); kind != "" {
+		return ""
+	}
+	t, repeated := elemType(t, nil)
+	m, ok := t.Underlying().(*types.Map)
+	if !ok {
+		return ""
+	}
+	if repeated {
+		return fmt.Sprintf("lists of maps such as %s can't be proto fields, as maps can't be repeated: wrap the map in a message", types.TypeString(t, (*types.Package).Name))
+	}
+
+	value := m.Elem()
+	if ptr, ok := value.Underlying().(*types.Pointer); ok {
+		value = ptr.Elem()
+	}
+	name := types.TypeString(value, (*types.Package).Name)
+	fd.NamedType = ""
+	if named, ok := value.(interface{ Obj() *types.TypeName }); ok {
+		fd.NamedType = qualifiedName(named.Obj())
+	}
+	switch under := value.Underlying().(type) {
+	case *types.Basic:
+		if under.Info()&(types.IsInteger|types.IsFloat|types.IsBoolean|types.IsString) == 0 {
+			return fmt.Sprintf("map value type %s has no proto representation", name)
+		}
+		fd.TypeName = normalizeType(types.Typ[under.Kind()].Name()) // byte and rune by their names
+	case *types.Struct:
+		if fd.NamedType == "" {
+			return "anonymous struct map values have no proto representation: declare the struct as a type"
+		}
+		fd.TypeName = value.(interface{ Obj() *types.TypeName }).Obj().Name()
+		if fd.NamedType == "time.Time" {
+			fd.TypeName = "google.protobuf.Timestamp"
+		}
+	case *types.Slice:
+		if basic, ok := under.Elem().Underlying().(*types.Basic); !ok || basic.Kind() != types.Byte {
+			return fmt.Sprintf("map value type %s can't be a proto map value, as map values can't be repeated: wrap the values in a message", name)
+		}
+		fd.TypeName, fd.NamedType = "bytes", ""
+	case *types.Map:
+		return fmt.Sprintf("map value type %s can't be a proto map value, as map values can't be maps: wrap the inner map in a message", name)
+	case *types.Interface:
+		return fmt.Sprintf("map value type %s has no proto representation: key the map by strings to emit it as a google.protobuf.Struct", name)
+	default:
+		return fmt.Sprintf("map value type %s has no proto representation", name)
+	}
+	key := m.Key().Underlying().(*types.Basic) // checked by invalidMapKey
+	fd.MapKey = normalizeType(types.Typ[key.Kind()].Name())
+	return ""
+}
+
+// isRepeated returns true if the field is a slice.
+func isRepeated(f *types.Var) bool {
+	_, ok := f.Type().Underlying().(*types.Slice)
+	return ok
+}
+
+// toProtoFieldName transforms the Go field name into snake_case for proto, handling numbers correctly.
+func toProtoFieldName(name string) string {
+	// Use strcase to convert to snake_case
+	snake := strcase.ToSnake(name)
+
+	// Adjust for numbers: remove underscores before numbers
+	var result []rune
+	for i, r := range snake {
+		if unicode.IsDigit(r) && i > 0 && result[len(result)-1] == '_' {
+			result = result[:len(result)-1] // Remove the underscore before a digit
+		}
+		result = append(result, r)
+	}
+
+	return string(result)
+}
+
+// toProtoFieldTypeName maps the field's Go type to a proto type, recording named types so
+// enums can be resolved later (see resolveEnums).
+func toProtoFieldTypeName(f *types.Var, fd *Field) string {
+	t := f.Type()
+
+	if named := elemNamed(t); named != nil {
+		fd.NamedType = qualifiedName(named.Obj())
+	}
+
+	switch under := t.Underlying().(type) {
+	case *types.Basic:
+		return normalizeType(under.String())
+	case *types.Slice:
+		name := splitNameHelper(f)
+		return normalizeType(strings.TrimLeft(name, "[]"))
+	case *types.Pointer, *types.Struct:
+		name := splitNameHelper(f)
+		if name == "Time" {
+			return "google.protobuf.Timestamp"
+		}
+		return normalizeType(name)
+	default:
+		return t.String()
+	}
+}
+
+// qualifiedName returns the object's name qualified by its package path, e.g. "example.com/models.User".
+func qualifiedName(obj types.Object) string {
+	if obj.Pkg() == nil {
+		return obj.Name()
+	}
+	return obj.Pkg().Path() + "." + obj.Name()
+}
+
+// elemNamed returns the named type behind t, looking through pointers and slices.
+func elemNamed(t types.Type) *types.Named {
+	for {
+		switch tt := t.(type) {
+		case *types.Named:
+			return tt
+		case *types.Pointer:
+			t = tt.Elem()
+		case *types.Slice:
+			t = tt.Elem()
+		default:
+			return nil
+		}
+	}
+}
+
+// splitNameHelper extracts the final portion of the type by splitting on "." and trimming "*" or "[]".
+func splitNameHelper(f *types.Var) string {
+	parts := strings.Split(f.Type().String(), ".")
+	name := parts[len(parts)-1]
+	name = strings.TrimPrefix(name, "*")
+	name = strings.TrimPrefix(name, "[]")
+	return name
+}
+
+// normalizeType shrinks certain root types into their proto equivalents.
+func normalizeType(name string) string {
+	switch name {
+	case "int":
+		return "int64"
+	case "int8", "int16":
+		return "int32"
+	case "uint":
+		return "uint32"
+	case "uint8", "uint16":
+		return "uint32"
+	case "float32":
+		return "float"
+	case "float64":
+		return "double"
+	case "string":
+		return "string"
+	default:
+		return name
+	}
+}
@@ -0,0 +1,319 @@
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// annotationMarker is the comment marker that selects a type for generation.
+const annotationMarker = "@go2proto"
+
+// celDirective adds a protovalidate CEL constraint to an annotated struct:
+//
+//	// go2proto:cel <id> "<message>" <expression>
+//
+// The message is optional, as for expressions that evaluate to the error message.
+const celDirective = "go2proto:cel"
+
+// skipDirective leaves a constant out of its enum, from a comment above the constant or
+// at the end of its line:
+//
+//	statusCount // go2proto:skip
+const skipDirective = "go2proto:skip"
+
+// hasSkipDirective reports whether one of the comment groups carries skipDirective.
+func hasSkipDirective(groups ...*ast.CommentGroup) bool {
+	for _, group := range groups {
+		if group == nil {
+			continue
+		}
+		for _, comment := range group.List {
+			if strings.Contains(comment.Text, skipDirective) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// annotation holds the arguments of a "@go2proto(key=value, ...)" or
+// "@go2proto key=value ..." comment.
+type annotation struct {
+	// Name overrides the emitted message (or enum) name.
+	Name string
+	// Package places the type in a different proto package than -t.
+	Package string
+	// Deprecated marks the emitted message (or enum) deprecated.
+	Deprecated bool
+	// Enum names the enum defined by an annotated const block.
+	Enum string
+	// Service turns a struct's exported methods into the RPCs of a service.
+	Service bool
+	// Stream makes a service method returning a slice a server-streaming RPC of its elements.
+	Stream bool
+	// Idempotency is the idempotency_level of a service method, from
+	// "idempotency=no-side-effects" or "idempotency=idempotent".
+	Idempotency string
+	// Constraints are the message-level CEL constraints of the celDirective lines.
+	Constraints []*Constraint
+	// Oneofs group pointer fields of which at most one is set, from "oneof=name:A,B".
+	Oneofs []oneofGroup
+	// Options are emitted verbatim as message options, from
+	// `option=(acme.resource).type="users"`.
+	Options []string
+	// Problems are the arguments and directives left out for being malformed, reported by
+	// ineffectiveAnnotations.
+	Problems []annotationProblem
+}
+
+// annotationProblem is why an argument or directive of an annotation was left out, at the
+// comment holding it.
+type annotationProblem struct {
+	Pos    token.Pos
+	Reason string
+}
+
+// oneofGroup is a oneof of a message and the Go names of its fields.
+type oneofGroup struct {
+	Name   string
+	Fields []string
+}
+
+// fieldTagKey is the struct tag key of field options, e.g. `go2proto:"duration=millis"`.
+const fieldTagKey = "go2proto"
+
+// fieldTag returns the key=value options of a struct field's go2proto tag.
+func fieldTag(tag string) map[string]string {
+	value, ok := reflect.StructTag(tag).Lookup(fieldTagKey)
+	if !ok {
+		return nil
+	}
+	result := make(map[string]string)
+	for _, arg := range strings.Split(value, ",") {
+		key, value, _ := strings.Cut(arg, "=")
+		if key = strings.TrimSpace(key); key != "" {
+			result[key] = strings.TrimSpace(value)
+		}
+	}
+	return result
+}
+
+// parseAnnotation looks for the marker in the comment group and parses its arguments,
+// along with any celDirective lines. It returns false if the group carries no annotation.
+func parseAnnotation(doc *ast.CommentGroup) (annotation, bool) {
+	var ann annotation
+	if doc == nil {
+		return ann, false
+	}
+	found := false
+	for _, comment := range doc.List {
+		if idx := strings.Index(comment.Text, celDirective); idx >= 0 {
+			if c, ok := parseConstraint(comment.Text[idx+len(celDirective):]); ok {
+				ann.Constraints = append(ann.Constraints, c)
+			} else {
+				ann.problem(comment, fmt.Sprintf("malformed %s directive %q, ignoring it", celDirective, comment.Text))
+			}
+			continue
+		}
+		idx := strings.Index(comment.Text, annotationMarker)
+		if idx < 0 || found {
+			continue
+		}
+		found = true
+		rest := comment.Text[idx+len(annotationMarker):]
+		if strings.HasPrefix(rest, "(") {
+			end := closingParen(rest)
+			if end < 0 {
+				ann.problem(comment, fmt.Sprintf("unterminated %s arguments in %q, ignoring them", annotationMarker, comment.Text))
+				continue
+			}
+			args := splitArgs(rest[1:end], func(r rune) bool { return r == ',' })
+			for i := 0; i < len(args); i++ {
+				arg := args[i]
+				// The fields of a oneof are comma separated too: "oneof=result:Success,Error".
+				for strings.HasPrefix(strings.TrimSpace(arg), "oneof=") && i+1 < len(args) && isOneofField(args[i+1]) {
+					i++
+					arg += "," + args[i]
+				}
+				ann.problem(comment, ann.set(arg))
+			}
+			continue
+		}
+		// "@go2proto name=X package=y": space separated arguments, up to the first word
+		// that isn't one, so the marker can still be followed by prose.
+		for _, arg := range splitArgs(rest, unicode.IsSpace) {
+			if !strings.Contains(arg, "=") && !annotationFlags[arg] {
+				break
+			}
+			ann.problem(comment, ann.set(arg))
+		}
+	}
+	return ann, found
+}
+
+// problem records the reason an argument or directive of comment was left out, if not empty.
+func (a *annotation) problem(comment *ast.Comment, reason string) {
+	if reason != "" {
+		a.Problems = append(a.Problems, annotationProblem{Pos: comment.Pos(), Reason: reason})
+	}
+}
+
+// annotationFlags are the arguments that take no value, e.g. "@go2proto deprecated".
+var annotationFlags = map[string]bool{"deprecated": true, "service": true, "stream": true}
+
+// idempotencyLevels maps the values of the idempotency argument to the idempotency_level
+// option of methods.
+var idempotencyLevels = map[string]string{"no-side-effects": "NO_SIDE_EFFECTS", "idempotent": "IDEMPOTENT"}
+
+// closingParen returns the index of the parenthesis closing the one s starts with, or -1.
+// Parentheses inside arguments, such as option names, and quoted strings are skipped.
+func closingParen(s string) int {
+	depth, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitArgs splits s into the arguments separated by runes for which sep returns true,
+// keeping separators inside quoted strings and parentheses, e.g. in option values.
+func splitArgs(s string, sep func(rune) bool) []string {
+	var args []string
+	var arg strings.Builder
+	depth, quoted, escaped := 0, false, false
+	for _, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+		case depth == 0 && sep(r):
+			if arg.Len() > 0 {
+				args = append(args, arg.String())
+				arg.Reset()
+			}
+			continue
+		}
+		arg.WriteRune(r)
+	}
+	if arg.Len() > 0 {
+		args = append(args, arg.String())
+	}
+	return args
+}
+
+// isOneofField reports whether the annotation argument arg continues the field list of a
+// oneof rather than being an argument of its own.
+func isOneofField(arg string) bool {
+	arg = strings.TrimSpace(arg)
+	return arg != "" && !strings.Contains(arg, "=") && !annotationFlags[arg]
+}
+
+// parseConstraint parses the `<id> "<message>" <expression>` arguments of a celDirective.
+func parseConstraint(args string) (*Constraint, bool) {
+	args = strings.TrimSpace(args)
+	end := strings.IndexAny(args, " \t")
+	if end <= 0 {
+		return nil, false
+	}
+	c := &Constraint{ID: args[:end]}
+	rest := strings.TrimSpace(args[end:])
+	if strings.HasPrefix(rest, `"`) {
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return nil, false
+		}
+		c.Message, _ = strconv.Unquote(quoted)
+		rest = strings.TrimSpace(rest[len(quoted):])
+	}
+	c.Expression = rest
+	return c, c.Expression != ""
+}
+
+// set applies a single "key=value" argument. It returns why the argument was left out, if it was.
+func (a *annotation) set(arg string) (problem string) {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return ""
+	}
+	kv := strings.SplitN(arg, "=", 2)
+	key := strings.TrimSpace(kv[0])
+	value := ""
+	if len(kv) == 2 {
+		value = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+	}
+
+	switch key {
+	case "option":
+		// The option is kept verbatim, quotes included.
+		option := ""
+		if len(kv) == 2 {
+			option = strings.TrimSpace(kv[1])
+		}
+		if name, _, ok := strings.Cut(option, "="); !ok || strings.TrimSpace(name) == "" {
+			return fmt.Sprintf("invalid %s option %q, expected name=value, ignoring it", annotationMarker, option)
+		}
+		a.Options = append(a.Options, option)
+	case "name":
+		a.Name = value
+	case "package":
+		a.Package = value
+	case "enum":
+		a.Enum = value
+	case "service":
+		a.Service = true
+	case "stream":
+		a.Stream = true
+	case "idempotency":
+		level, ok := idempotencyLevels[value]
+		if !ok {
+			return fmt.Sprintf("invalid %s idempotency value %q, expected no-side-effects or idempotent, ignoring it", annotationMarker, value)
+		}
+		a.Idempotency = level
+	case "oneof":
+		name, list, _ := strings.Cut(value, ":")
+		group := oneofGroup{Name: strings.TrimSpace(name)}
+		for _, field := range strings.Split(list, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				group.Fields = append(group.Fields, field)
+			}
+		}
+		if group.Name == "" || len(group.Fields) == 0 {
+			return fmt.Sprintf("invalid %s oneof value %q, expected name:Field,Field, ignoring it", annotationMarker, value)
+		}
+		a.Oneofs = append(a.Oneofs, group)
+	case "deprecated":
+		deprecated, err := strconv.ParseBool(value)
+		if value != "" && err != nil {
+			return fmt.Sprintf("invalid %s deprecated value %q, ignoring it", annotationMarker, value)
+		}
+		a.Deprecated = value == "" || deprecated
+	default:
+		return fmt.Sprintf("unknown %s argument %q, ignoring it", annotationMarker, key)
+	}
+	return ""
+}
@@ -0,0 +1,70 @@
+package generator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// appendToFile inserts the file's messages into existing proto source: messages that already
+// exist (matched by name) are replaced in place, new ones are appended at the end, and missing
+// imports are added after the header. Everything else in existing is left untouched.
+func appendToFile(existing string, f *File) (string, error) {
+	parsed, err := parseProto(existing)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse existing file: %w", err)
+	}
+
+	type replacement struct {
+		span protoSpan
+		text string
+	}
+	var replacements []replacement
+	var appended []string
+
+	for _, msg := range f.Messages {
+		block, err := renderMessage(msg, f)
+		if err != nil {
+			return "", err
+		}
+		if old := parsed.message(msg.Name); old != nil {
+			replacements = append(replacements, replacement{old.Span, string(block)})
+			continue
+		}
+		appended = append(appended, string(block))
+	}
+
+	have := make(map[string]bool)
+	for _, imp := range parsed.Imports {
+		have[imp.Path] = true
+	}
+	var imports []string
+	for _, imp := range f.fileImports() {
+		if !have[imp] {
+			imports = append(imports, fmt.Sprintf("\nimport %q;", imp))
+		}
+	}
+	for _, imp := range f.PublicImports {
+		if !have[imp] {
+			imports = append(imports, fmt.Sprintf("\nimport public %q;", imp))
+		}
+	}
+	if len(imports) > 0 {
+		replacements = append(replacements, replacement{protoSpan{parsed.HeaderEnd, parsed.HeaderEnd}, strings.Join(imports, "")})
+	}
+
+	// Apply from the end so earlier offsets stay valid.
+	sort.Slice(replacements, func(i, j int) bool { return replacements[i].span.Start > replacements[j].span.Start })
+	out := existing
+	for _, r := range replacements {
+		out = out[:r.span.Start] + r.text + out[r.span.End:]
+	}
+
+	if len(appended) > 0 {
+		out = strings.TrimRight(out, "\n") + "\n"
+		for _, block := range appended {
+			out += "\n" + block + "\n"
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,92 @@
+package generator
+
+import "encoding/json"
+
+// avroPrimitives maps proto scalar and well-known types to Avro types.
+var avroPrimitives = map[string]interface{}{
+	"double":                    "double",
+	"float":                     "float",
+	"int32":                     "int",
+	"int64":                     "long",
+	"uint32":                    "long",
+	"uint64":                    "long",
+	"bool":                      "boolean",
+	"string":                    "string",
+	"bytes":                     "bytes",
+	"google.protobuf.Timestamp": map[string]string{"type": "long", "logicalType": "timestamp-millis"},
+	"google.protobuf.Duration":  "long",
+	"google.protobuf.FieldMask": "string",
+	"google.protobuf.Any":       "string",
+	"google.protobuf.Struct":    "string",
+	"google.protobuf.Value":     "string",
+}
+
+type avroRecord struct {
+	Type      string       `json:"type"`
+	Name      string       `json:"name"`
+	Namespace string       `json:"namespace,omitempty"`
+	Fields    []*avroField `json:"fields"`
+}
+
+type avroField struct {
+	Name    string          `json:"name"`
+	Type    interface{}     `json:"type"`
+	Default json.RawMessage `json:"default,omitempty"`
+}
+
+type avroEnum struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name"`
+	Symbols []string `json:"symbols"`
+}
+
+// renderAvro renders the file's messages as an Avro schema: a JSON array of records in
+// dependency order, so every record is defined before the records using it. Message fields
+// are nullable, as in proto, and enums are defined inline where first used.
+func renderAvro(f *File) ([]byte, error) {
+	names := messageNames(f.Messages)
+	enums := enumsByGoName(f.Enums)
+	defined := make(map[string]bool)
+
+	var records []*avroRecord
+	for _, msg := range orderByDependency(f.Messages) {
+		record := &avroRecord{Type: "record", Name: msg.Name, Namespace: f.ProtoPackage, Fields: []*avroField{}}
+		for _, fd := range msg.Fields {
+			field := &avroField{Name: fd.Name}
+			switch {
+			case len(fd.EnumValues) > 0 && enums[fd.NamedType] != nil:
+				e := enums[fd.NamedType]
+				if defined[e.Name] {
+					field.Type = e.Name
+				} else {
+					defined[e.Name] = true
+					field.Type = &avroEnum{Type: "enum", Name: e.Name, Symbols: enumSymbols(fd.EnumValues)}
+				}
+			case len(fd.EnumValues) > 0:
+				field.Type = "string"
+			case names[fd.NamedType] != "":
+				field.Type = names[fd.NamedType]
+			case avroPrimitives[fd.TypeName] != nil:
+				field.Type = avroPrimitives[fd.TypeName]
+			default:
+				field.Type = "bytes"
+			}
+			switch {
+			case fd.IsRepeated:
+				field.Type = map[string]interface{}{"type": "array", "items": field.Type}
+				field.Default = json.RawMessage("[]")
+			case fd.MapKey != "":
+				field.Type = map[string]interface{}{"type": "map", "values": field.Type}
+				field.Default = json.RawMessage("{}")
+			case names[fd.NamedType] != "":
+				field.Type = []interface{}{"null", field.Type}
+				field.Default = json.RawMessage("null")
+			}
+			record.Fields = append(record.Fields, field)
+		}
+		records = append(records, record)
+	}
+
+	out, err := json.MarshalIndent(records, "", "  ")
+	return append(out, '\n'), err
+}
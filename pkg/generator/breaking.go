@@ -0,0 +1,110 @@
+package generator
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// BreakingChange describes one wire-incompatible difference between two versions of a file.
+type BreakingChange struct {
+	Path    string
+	Line    int
+	Message string
+}
+
+// String formats the change as "path:line: message".
+func (b BreakingChange) String() string {
+	if b.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", b.Path, b.Line, b.Message)
+	}
+	return fmt.Sprintf("%s: %s", b.Path, b.Message)
+}
+
+// CheckBreaking renders every file and compares it against the previously generated file
+// on disk, returning the wire-breaking changes. Files that don't exist yet are skipped.
+func CheckBreaking(files []*File) ([]BreakingChange, error) {
+	var changes []BreakingChange
+	for _, f := range files {
+		existing, err := ioutil.ReadFile(f.Path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read file %s: %w", f.Path, err)
+		}
+		previous, err := parseProto(string(existing))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse %s: %w", f.Path, err)
+		}
+
+		out, err := f.contents()
+		if err != nil {
+			return nil, err
+		}
+		current, err := parseProto(string(out))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse generated %s: %w", f.Path, err)
+		}
+
+		for _, msg := range breakingChanges(previous, current) {
+			changes = append(changes, BreakingChange{Path: f.Path, Line: msg.Line, Message: msg.Message})
+		}
+	}
+	return changes, nil
+}
+
+// breakingChanges compares the messages of two parsed files: removed messages, fields removed
+// without reserving their number, and field numbers reused with a different type or label.
+func breakingChanges(previous, current *protoFile) []BreakingChange {
+	var changes []BreakingChange
+	for _, old := range previous.Messages {
+		msg := current.message(old.Name)
+		if msg == nil {
+			changes = append(changes, BreakingChange{Message: fmt.Sprintf("message %s was removed", old.Name)})
+			continue
+		}
+
+		byNumber := make(map[int]*protoField, len(msg.Fields))
+		for _, fd := range msg.Fields {
+			byNumber[fd.Number] = fd
+		}
+		for _, oldField := range old.Fields {
+			fd, ok := byNumber[oldField.Number]
+			if !ok {
+				if !msg.Reserved.hasNumber(oldField.Number) {
+					changes = append(changes, BreakingChange{
+						Line:    oldField.Line,
+						Message: fmt.Sprintf("%s: field %d (%s) was removed without reserving its number", old.Name, oldField.Number, oldField.Name),
+					})
+				}
+				continue
+			}
+			oldType := normalizeProtoType(oldField.Type, previous.Package)
+			newType := normalizeProtoType(fd.Type, current.Package)
+			if oldType != newType {
+				changes = append(changes, BreakingChange{
+					Line:    fd.Line,
+					Message: fmt.Sprintf("%s: field %d changed type from %s to %s", old.Name, fd.Number, oldField.Type, fd.Type),
+				})
+			}
+			if oldField.Label != fd.Label {
+				changes = append(changes, BreakingChange{
+					Line:    fd.Line,
+					Message: fmt.Sprintf("%s: field %d changed label from %q to %q", old.Name, fd.Number, oldField.Label, fd.Label),
+				})
+			}
+		}
+	}
+	return changes
+}
+
+// normalizeProtoType strips a leading dot and the file's own package from a type reference.
+func normalizeProtoType(typ, pkg string) string {
+	typ = strings.TrimPrefix(typ, ".")
+	if pkg != "" {
+		typ = strings.TrimPrefix(typ, pkg+".")
+	}
+	return typ
+}
@@ -0,0 +1,166 @@
+package generator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// cacheFormat is bumped whenever the cached model changes shape.
+const cacheFormat = "28"
+
+// cache is an on-disk store of package models keyed by a hash of their inputs.
+type cache struct {
+	dir string
+}
+
+// cacheEntry is the on-disk representation of one package's cached analysis.
+type cacheEntry struct {
+	Key   string
+	Model *packageModel
+}
+
+// key hashes everything the package's analysis depends on: the tool version, the
+// analysis options, the version and go.mod of the package's module, the contents of its
+// source files and those of the packages of the same module it imports, directly or not.
+// Analysis reads the types of those from compiler export data, which isn't part of the
+// package's files, so a change to them must invalidate its entry.
+func (c *cache) key(p *packages.Package, opts *Options) (string, error) {
+	naming, ok := opts.namingKey()
+	if !ok {
+		return "", fmt.Errorf("analyses named by %T are not cached", opts.Naming)
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "format=%s\nversion=%s\npkg=%s\nfilter=%s\nnaming=%q\nnonascii=%s\ndurations=%s\ntimes=%s\nfieldmasks=%s\nany=%s\n", cacheFormat, Version(), p.PkgPath,
+		strings.Join(opts.Filters, ","), naming, opts.NonASCII, opts.Durations, opts.Times, strings.Join(opts.FieldMaskTypes, ","), opts.AnyStrategy)
+
+	files := append([]string(nil), p.GoFiles...)
+	sort.Strings(files)
+	imports, err := hashFiles(h, files)
+	if err != nil {
+		return "", err
+	}
+	if p.Module == nil {
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	fmt.Fprintf(h, "module=%s@%s\n", p.Module.Path, p.Module.Version)
+	if p.Module.GoMod != "" {
+		// The versions of the other modules the package's types come from.
+		if _, err := hashFiles(h, []string{p.Module.GoMod}); err != nil {
+			return "", err
+		}
+	}
+	if p.Module.Dir == "" {
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	seen := map[string]bool{p.PkgPath: true}
+	for len(imports) > 0 {
+		path := imports[0]
+		imports = imports[1:]
+		if seen[path] || (path != p.Module.Path && !strings.HasPrefix(path, p.Module.Path+"/")) {
+			continue
+		}
+		seen[path] = true
+		// Every file of the directory is hashed, whatever its build constraints, which at
+		// worst invalidates the entry for nothing.
+		dir := filepath.Join(p.Module.Dir, filepath.FromSlash(strings.TrimPrefix(path, p.Module.Path)))
+		names, err := filepath.Glob(filepath.Join(dir, "*.go"))
+		if err != nil {
+			return "", err
+		}
+		var deps []string
+		for _, name := range names {
+			if !strings.HasSuffix(name, "_test.go") {
+				deps = append(deps, name)
+			}
+		}
+		fmt.Fprintf(h, "import=%s\n", path)
+		depImports, err := hashFiles(h, deps)
+		if err != nil {
+			return "", err
+		}
+		imports = append(imports, depImports...)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashFiles writes the names and contents of files to h, and returns the paths the Go
+// files among them import.
+func hashFiles(h io.Writer, files []string) ([]string, error) {
+	var imports []string
+	fset := token.NewFileSet()
+	for _, name := range files {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(h, "file=%s\n", filepath.Base(name))
+		h.Write(data)
+		if !strings.HasSuffix(name, ".go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, name, data, parser.ImportsOnly)
+		if err != nil {
+			continue // the load reports syntax errors
+		}
+		for _, imp := range f.Imports {
+			if path, err := strconv.Unquote(imp.Path.Value); err == nil {
+				imports = append(imports, path)
+			}
+		}
+	}
+	return imports, nil
+}
+
+// path returns the cache file holding the analysis of pkgPath.
+func (c *cache) path(pkgPath string) string {
+	sum := sha256.Sum256([]byte(pkgPath))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// get returns the cached model for pkgPath if it was stored under key.
+func (c *cache) get(pkgPath, key string) (*packageModel, bool) {
+	data, err := ioutil.ReadFile(c.path(pkgPath))
+	if err != nil {
+		return nil, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Key != key || entry.Model == nil {
+		return nil, false
+	}
+	return entry.Model, true
+}
+
+// has reports whether an up-to-date analysis of p is cached.
+func (c *cache) has(p *packages.Package, opts *Options) bool {
+	key, err := c.key(p, opts)
+	if err != nil {
+		return false
+	}
+	_, ok := c.get(p.PkgPath, key)
+	return ok
+}
+
+// put stores the model for pkgPath under key, replacing any previous entry.
+func (c *cache) put(pkgPath, key string, model *packageModel) error {
+	data, err := json.Marshal(cacheEntry{Key: key, Model: model})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	return ioutil.WriteFile(c.path(pkgPath), data, 0666)
+}
@@ -0,0 +1,246 @@
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Drift describes one difference between the messages go2proto generates now and the
+// types protoc-gen-go compiled from a previous output.
+type Drift struct {
+	Path    string
+	Line    int
+	Message string
+}
+
+// String formats the drift as "path:line: message".
+func (d Drift) String() string {
+	if d.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", d.Path, d.Line, d.Message)
+	}
+	return fmt.Sprintf("%s: %s", d.Path, d.Message)
+}
+
+// compiledMessage is a message as read from a protoc-gen-go struct.
+type compiledMessage struct {
+	Path   string
+	Line   int
+	Fields []*compiledField
+}
+
+// compiledField is a struct field carrying a protobuf:"..." tag.
+type compiledField struct {
+	Name     string
+	Number   int
+	Encoding string
+	Repeated bool
+	Line     int
+}
+
+// wireEncodings maps the proto types go2proto emits to the encoding protoc-gen-go records
+// in its struct tags; message types are "bytes".
+var wireEncodings = map[string]string{
+	"double": "fixed64",
+	"float":  "fixed32",
+	"int32":  "varint",
+	"int64":  "varint",
+	"uint32": "varint",
+	"uint64": "varint",
+	"bool":   "varint",
+	"string": "bytes",
+	"bytes":  "bytes",
+}
+
+// CheckCompiled compares the messages of every proto file against the .pb.go files in
+// pbDir, or beside the file when pbDir is empty, returning the fields whose name, number,
+// type or label drifted since the .pb.go files were compiled. Files without compiled
+// .pb.go files are skipped.
+func CheckCompiled(files []*File, pbDir string) ([]Drift, error) {
+	var drifts []Drift
+	for _, f := range files {
+		dir := pbDir
+		if dir == "" {
+			dir = filepath.Dir(f.Path)
+		}
+		compiled, err := loadCompiled(dir)
+		if err != nil {
+			return nil, err
+		}
+		if len(compiled) == 0 {
+			continue
+		}
+
+		out, err := f.contents()
+		if err != nil {
+			return nil, err
+		}
+		current, err := parseProto(string(out))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse generated %s: %w", f.Path, err)
+		}
+		for _, msg := range current.Messages {
+			cm, ok := compiled[goCamelCase(msg.Name)]
+			if !ok {
+				drifts = append(drifts, Drift{Path: f.Path, Message: fmt.Sprintf("message %s is not compiled into %s", msg.Name, dir)})
+				continue
+			}
+			drifts = append(drifts, compiledDrift(msg, cm)...)
+		}
+	}
+	return drifts, nil
+}
+
+// compiledDrift compares a generated message with its compiled struct, field by number.
+func compiledDrift(msg *protoMessage, cm *compiledMessage) []Drift {
+	var drifts []Drift
+	add := func(line int, format string, args ...interface{}) {
+		drifts = append(drifts, Drift{Path: cm.Path, Line: line, Message: msg.Name + ": " + fmt.Sprintf(format, args...)})
+	}
+
+	byNumber := make(map[int]*compiledField, len(cm.Fields))
+	for _, cf := range cm.Fields {
+		byNumber[cf.Number] = cf
+	}
+	numbers := make(map[int]bool, len(msg.Fields))
+	for _, fd := range msg.Fields {
+		numbers[fd.Number] = true
+		cf, ok := byNumber[fd.Number]
+		if !ok {
+			add(cm.Line, "field %d (%s) is not compiled", fd.Number, fd.Name)
+			continue
+		}
+		if cf.Name != fd.Name {
+			add(cf.Line, "field %d is %s in the source but %s in the compiled type", fd.Number, fd.Name, cf.Name)
+		}
+		encoding, ok := wireEncodings[fd.Type]
+		if !ok {
+			encoding = "bytes"
+		}
+		if cf.Encoding != encoding {
+			add(cf.Line, "field %d (%s) is %s in the source but compiled as %s", fd.Number, fd.Name, fd.Type, cf.Encoding)
+		}
+		if repeated := fd.Label == "repeated"; cf.Repeated != repeated {
+			add(cf.Line, "field %d (%s) is %s in the source but %s in the compiled type", fd.Number, fd.Name, cardinality(repeated), cardinality(cf.Repeated))
+		}
+	}
+	for _, cf := range cm.Fields {
+		if !numbers[cf.Number] {
+			add(cf.Line, "compiled field %d (%s) no longer exists in the source", cf.Number, cf.Name)
+		}
+	}
+	return drifts
+}
+
+// cardinality names a field's label for drift messages.
+func cardinality(repeated bool) string {
+	if repeated {
+		return "repeated"
+	}
+	return "singular"
+}
+
+// loadCompiled parses the .pb.go files in dir and returns their message structs by Go name.
+// Only the syntax is needed, so the package doesn't have to build.
+func loadCompiled(dir string) (map[string]*compiledMessage, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pb.go"))
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	result := make(map[string]*compiledMessage)
+	for _, path := range paths {
+		file, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse %s: %w", path, err)
+		}
+		for _, decl := range file.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range genDecl.Specs {
+				typeSpec := spec.(*ast.TypeSpec)
+				st, ok := typeSpec.Type.(*ast.StructType)
+				if !ok {
+					continue
+				}
+				cm := &compiledMessage{Path: path, Line: fset.Position(typeSpec.Pos()).Line}
+				for _, field := range st.Fields.List {
+					if cf := parseCompiledField(field); cf != nil {
+						cf.Line = fset.Position(field.Pos()).Line
+						cm.Fields = append(cm.Fields, cf)
+					}
+				}
+				if len(cm.Fields) > 0 {
+					result[typeSpec.Name.Name] = cm
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// parseCompiledField reads a `protobuf:"bytes,1,rep,name=items,proto3"` tag, returning
+// nil for fields without one (internal state, oneof wrappers).
+func parseCompiledField(field *ast.Field) *compiledField {
+	if field.Tag == nil {
+		return nil
+	}
+	tag, err := strconv.Unquote(field.Tag.Value)
+	if err != nil {
+		return nil
+	}
+	parts := strings.Split(reflect.StructTag(tag).Get("protobuf"), ",")
+	if len(parts) < 3 {
+		return nil
+	}
+	number, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil
+	}
+	cf := &compiledField{Encoding: parts[0], Number: number, Repeated: parts[2] == "rep"}
+	for _, part := range parts[3:] {
+		if strings.HasPrefix(part, "name=") {
+			cf.Name = strings.TrimPrefix(part, "name=")
+		}
+	}
+	return cf
+}
+
+// goCamelCase returns the Go name protoc-gen-go gives a message, e.g. "_1Password" becomes
+// "X1Password" (the algorithm of google.golang.org/protobuf/internal/strs.GoCamelCase).
+func goCamelCase(s string) string {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '.' && i+1 < len(s) && isASCIILower(s[i+1]):
+		case c == '.':
+			b = append(b, '_')
+		case c == '_' && (i == 0 || s[i-1] == '.'):
+			b = append(b, 'X')
+		case c == '_' && i+1 < len(s) && isASCIILower(s[i+1]):
+		case isDigit(c):
+			b = append(b, c)
+		default:
+			if isASCIILower(c) {
+				c -= 'a' - 'A'
+			}
+			b = append(b, c)
+			for ; i+1 < len(s) && isASCIILower(s[i+1]); i++ {
+				b = append(b, s[i+1])
+			}
+		}
+	}
+	return string(b)
+}
+
+func isASCIILower(c byte) bool {
+	return 'a' <= c && c <= 'z'
+}
@@ -0,0 +1,77 @@
+package generator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// dtsTypes maps proto scalar and well-known types to the TypeScript types of their
+// protojson encoding: 64-bit integers and bytes are strings, timestamps RFC 3339 strings.
+var dtsTypes = map[string]string{
+	"double":                    "number",
+	"float":                     "number",
+	"int32":                     "number",
+	"uint32":                    "number",
+	"int64":                     "string",
+	"uint64":                    "string",
+	"bool":                      "boolean",
+	"string":                    "string",
+	"bytes":                     "string",
+	"google.protobuf.Timestamp": "string",
+	"google.protobuf.Duration":  "string",
+	"google.protobuf.FieldMask": "string",
+	"google.protobuf.Any":       "Record<string, unknown>",
+	"google.protobuf.Struct":    "Record<string, unknown>",
+	"google.protobuf.Value":     "unknown",
+}
+
+// renderDTS renders the file as TypeScript declarations of the protojson payloads: a
+// string union type per enum and an interface per message, with field names in
+// lowerCamelCase. Every field is optional, as protojson leaves out default values.
+func renderDTS(f *File) ([]byte, error) {
+	names := messageNames(f.Messages)
+	enums := enumsByGoName(f.Enums)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Code generated by %s. DO NOT EDIT.\n\n", f.generatedBy())
+	for _, e := range f.Enums {
+		values := make([]string, len(e.Values))
+		for i, v := range e.Values {
+			values[i] = strconv.Quote(v)
+		}
+		if e.Deprecated {
+			b.WriteString("/** @deprecated */\n")
+		}
+		fmt.Fprintf(&b, "export type %s = %s;\n\n", e.Name, strings.Join(values, " | "))
+	}
+
+	for _, msg := range f.Messages {
+		if msg.Deprecated {
+			b.WriteString("/** @deprecated */\n")
+		}
+		fmt.Fprintf(&b, "export interface %s {\n", msg.Name)
+		for _, fd := range msg.Fields {
+			typ, comment := dtsTypes[fd.TypeName], ""
+			switch {
+			case len(fd.EnumValues) > 0 && enums[fd.NamedType] != nil:
+				typ = enums[fd.NamedType].Name
+			case len(fd.EnumValues) > 0:
+				typ = "string"
+			case names[fd.NamedType] != "":
+				typ = names[fd.NamedType]
+			case typ == "":
+				typ, comment = "unknown", " // "+fd.TypeName+" has no TypeScript mapping"
+			}
+			switch {
+			case fd.IsRepeated:
+				typ += "[]"
+			case fd.MapKey != "":
+				typ = "Record<string, " + typ + ">"
+			}
+			fmt.Fprintf(&b, "  %s?: %s;%s\n", jsonName(fd.Name), typ, comment)
+		}
+		b.WriteString("}\n\n")
+	}
+	return []byte(strings.TrimSuffix(b.String(), "\n")), nil
+}
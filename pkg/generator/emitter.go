@@ -0,0 +1,73 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Emitter writes a model in an output format. Register one with RegisterEmitter to add a
+// format without changing the analysis.
+type Emitter interface {
+	Emit(model *Model, w io.Writer) error
+}
+
+// EmitterFunc adapts a function to an Emitter.
+type EmitterFunc func(model *Model, w io.Writer) error
+
+// Emit calls fn(model, w).
+func (fn EmitterFunc) Emit(model *Model, w io.Writer) error { return fn(model, w) }
+
+// ProtoEmitter is the default Emitter, writing the file of Options.ProtoPackage with the
+// proto template as Generate does.
+type ProtoEmitter struct {
+	Options *Options
+}
+
+// Emit writes the proto file of the messages and services of e.Options.ProtoPackage to w.
+func (e *ProtoEmitter) Emit(model *Model, w io.Writer) error {
+	opts := *e.Options
+	opts.Format = FormatProto
+	return Generate(w, model, &opts)
+}
+
+// emitters are the formats added with RegisterEmitter.
+var (
+	emittersMu sync.RWMutex
+	emitters   = make(map[string]Emitter)
+)
+
+// RegisterEmitter adds the output format named format, written by e as a single file at
+// Options.Output. Files of the format are given a model holding every message, in the order
+// set by Options.Alphabetical, enum and service. It fails if format is already defined.
+func RegisterEmitter(format string, e Emitter) error {
+	emittersMu.Lock()
+	defer emittersMu.Unlock()
+	if _, ok := formatRenderers[format]; ok || format == "" || format == FormatProto {
+		return fmt.Errorf("format %q is built in", format)
+	}
+	if _, ok := emitters[format]; ok {
+		return fmt.Errorf("format %q is already registered", format)
+	}
+	emitters[format] = e
+	return nil
+}
+
+// registeredEmitter returns the Emitter registered for format, if any.
+func registeredEmitter(format string) (Emitter, bool) {
+	emittersMu.RLock()
+	defer emittersMu.RUnlock()
+	e, ok := emitters[format]
+	return e, ok
+}
+
+// emit renders f with the Emitter e.
+func (f *File) emit(e Emitter) ([]byte, error) {
+	var buf bytes.Buffer
+	model := &Model{Messages: f.Messages, Enums: f.Enums, Services: f.Services}
+	if err := e.Emit(model, &buf); err != nil {
+		return nil, fmt.Errorf("%s: %w", f.Path, err)
+	}
+	return buf.Bytes(), nil
+}
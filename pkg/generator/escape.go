@@ -0,0 +1,90 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// protoKeywords are the words of the proto language that can't be used as identifiers
+// without confusing protoc or the code generators behind it.
+var protoKeywords = map[string]bool{
+	"syntax": true, "edition": true, "import": true, "weak": true, "public": true, "package": true,
+	"option": true, "repeated": true, "optional": true, "required": true, "message": true,
+	"enum": true, "oneof": true, "map": true, "reserved": true, "to": true, "max": true,
+	"service": true, "rpc": true, "returns": true, "stream": true, "extend": true,
+	"extensions": true, "group": true, "true": true, "false": true, "inf": true, "nan": true,
+}
+
+// escapeIdentifier returns a valid proto identifier for name and true if name had to change:
+// keywords get a trailing underscore ("message_") and names starting with a digit a leading one.
+func escapeIdentifier(name string) (string, bool) {
+	escaped := name
+	if protoKeywords[escaped] {
+		escaped += "_"
+	}
+	if escaped != "" && unicode.IsDigit(rune(escaped[0])) {
+		escaped = "_" + escaped
+	}
+	return escaped, escaped != name
+}
+
+// asciiFolds maps the letters outside ASCII that transliterate knows to their ASCII spelling.
+var asciiFolds = map[rune]string{
+	'ß': "ss", 'Æ': "AE", 'æ': "ae", 'Œ': "OE", 'œ': "oe", 'Þ': "Th", 'þ': "th", 'Ĳ': "IJ", 'ĳ': "ij",
+}
+
+func init() {
+	for ascii, letters := range map[rune]string{
+		'A': "ÀÁÂÃÄÅĀĂĄ", 'a': "àáâãäåāăą", 'C': "ÇĆĈĊČ", 'c': "çćĉċč", 'D': "ÐĎĐ", 'd': "ðďđ",
+		'E': "ÈÉÊËĒĔĖĘĚ", 'e': "èéêëēĕėęě", 'G': "ĜĞĠĢ", 'g': "ĝğġģ", 'H': "ĤĦ", 'h': "ĥħ",
+		'I': "ÌÍÎÏĨĪĬĮİ", 'i': "ìíîïĩīĭįı", 'J': "Ĵ", 'j': "ĵ", 'K': "Ķ", 'k': "ķĸ",
+		'L': "ĹĻĽĿŁ", 'l': "ĺļľŀł", 'N': "ÑŃŅŇŊ", 'n': "ñńņňŉŋ", 'O': "ÒÓÔÕÖØŌŎŐ", 'o': "òóôõöøōŏő",
+		'R': "ŔŖŘ", 'r': "ŕŗř", 'S': "ŚŜŞŠ", 's': "śŝşšſ", 'T': "ŢŤŦ", 't': "ţťŧ",
+		'U': "ÙÚÛÜŨŪŬŮŰŲ", 'u': "ùúûüũūŭůűų", 'W': "Ŵ", 'w': "ŵ", 'Y': "ÝŶŸ", 'y': "ýÿŷ",
+		'Z': "ŹŻŽ", 'z': "źżž",
+	} {
+		for _, r := range letters {
+			asciiFolds[r] = string(ascii)
+		}
+	}
+}
+
+// transliterate spells the Latin letters of name outside ASCII with ASCII letters, "Größe"
+// becoming "Grosse", and replaces the other characters proto identifiers can't hold with
+// underscores.
+func transliterate(name string) string {
+	var sb strings.Builder
+	for _, r := range name {
+		switch {
+		case r <= unicode.MaxASCII:
+			sb.WriteRune(r)
+		case asciiFolds[r] != "":
+			sb.WriteString(asciiFolds[r])
+		default:
+			sb.WriteByte('_')
+		}
+	}
+	return sb.String()
+}
+
+// asciiIdentifier checks name, the proto name of a kind of declaration ("message",
+// "field"...), for characters outside ASCII. If it holds any, it returns the diagnostic
+// reporting them along with the name to emit: transliterated with NonASCIITransliterate,
+// otherwise unchanged and the diagnostic is an error. The caller locates the diagnostic.
+func asciiIdentifier(kind, name, mode string) (string, *Diagnostic) {
+	i := strings.IndexFunc(name, func(r rune) bool { return r > unicode.MaxASCII })
+	if i < 0 {
+		return name, nil
+	}
+	if mode == NonASCIITransliterate {
+		ascii := transliterate(name)
+		return ascii, &Diagnostic{Code: CodeInvalidIdentifier, Severity: SeverityWarning,
+			Message: fmt.Sprintf("%s name %q is not ASCII, emitting it as %q", kind, name, ascii)}
+	}
+	r, _ := utf8.DecodeRuneInString(name[i:])
+	return name, &Diagnostic{Code: CodeNonASCII, Severity: SeverityError,
+		Message: fmt.Sprintf("%s name %q holds %q (%U) at byte %d, but proto identifiers are ASCII: rename it, or transliterate it with -non-ascii %s",
+			kind, name, r, r, i, NonASCIITransliterate)}
+}
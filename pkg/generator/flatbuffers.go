@@ -0,0 +1,85 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// flatBuffersTypes maps proto scalar and well-known types to FlatBuffers scalar types.
+var flatBuffersTypes = map[string]string{
+	"double":                    "double",
+	"float":                     "float",
+	"int32":                     "int",
+	"int64":                     "long",
+	"uint32":                    "uint",
+	"uint64":                    "ulong",
+	"bool":                      "bool",
+	"string":                    "string",
+	"bytes":                     "[ubyte]",
+	"google.protobuf.Timestamp": "long",
+	"google.protobuf.Duration":  "long",
+	"google.protobuf.FieldMask": "string",
+	"google.protobuf.Any":       "string",
+	"google.protobuf.Struct":    "string",
+	"google.protobuf.Value":     "string",
+}
+
+// renderFlatBuffers renders the file as a FlatBuffers schema: an enum per enum and a table
+// per message. Fields keep their proto order, which decides their slot in the table.
+func renderFlatBuffers(f *File) ([]byte, error) {
+	names := messageNames(f.Messages)
+	enums := enumsByGoName(f.Enums)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Code generated by %s. DO NOT EDIT.\n\n", f.generatedBy())
+	if f.ProtoPackage != "" {
+		fmt.Fprintf(&b, "namespace %s;\n\n", f.ProtoPackage)
+	}
+	for _, e := range f.Enums {
+		underlying := "ubyte"
+		if len(e.Values) > 256 {
+			underlying = "ushort"
+		}
+		fmt.Fprintf(&b, "enum %s : %s {\n", e.Name, underlying)
+		for i, symbol := range enumSymbols(e.Values) {
+			fmt.Fprintf(&b, "  %s = %d,\n", symbol, i)
+		}
+		b.WriteString("}\n\n")
+	}
+
+	for _, msg := range f.Messages {
+		fmt.Fprintf(&b, "table %s {\n", msg.Name)
+		for _, fd := range msg.Fields {
+			typ, comment := flatBuffersTypes[fd.TypeName], ""
+			switch {
+			case len(fd.EnumValues) > 0 && enums[fd.NamedType] != nil:
+				typ = enums[fd.NamedType].Name
+			case len(fd.EnumValues) > 0:
+				typ = "string"
+			case names[fd.NamedType] != "":
+				typ = names[fd.NamedType]
+			case fd.TypeName == "google.protobuf.Timestamp":
+				comment = " // unix milliseconds"
+			case fd.TypeName == "google.protobuf.Duration":
+				comment = " // milliseconds"
+			case fd.TypeName == "google.protobuf.FieldMask":
+				comment = " // comma-separated field paths"
+			case fd.TypeName == "google.protobuf.Any", fd.TypeName == "google.protobuf.Struct", fd.TypeName == "google.protobuf.Value":
+				comment = " // JSON"
+			case fd.Comment != "":
+				comment = " // " + fd.Comment
+			case typ == "":
+				typ, comment = "[ubyte]", " // "+fd.TypeName+" has no FlatBuffers mapping"
+			}
+			if fd.MapKey != "" {
+				typ, comment = "[ubyte]", " // map<"+fd.MapKey+", "+fd.TypeName+"> has no FlatBuffers mapping"
+			}
+			if fd.IsRepeated && !strings.HasPrefix(typ, "[") {
+				typ = "[" + typ + "]"
+			}
+			fmt.Fprintf(&b, "  %s:%s;%s\n", fd.Name, typ, comment)
+		}
+		b.WriteString("}\n\n")
+	}
+	return []byte(strings.TrimSuffix(b.String(), "\n")), nil
+}
@@ -0,0 +1,56 @@
+package generator
+
+import (
+	"strings"
+	"unicode"
+)
+
+// formatRenderers render the files of every format other than proto.
+var formatRenderers = map[string]func(*File) ([]byte, error){
+	FormatOpenAPI:     renderOpenAPI,
+	FormatJSONSchema:  renderJSONSchema,
+	FormatAvro:        renderAvro,
+	FormatThrift:      renderThrift,
+	FormatFlatBuffers: renderFlatBuffers,
+	FormatGraphQL:     renderGraphQL,
+	FormatDTS:         renderDTS,
+}
+
+// messageNames maps the package-qualified Go name of every message to its emitted name,
+// for resolving field references in formats without proto's package scoping.
+func messageNames(msgs []*Message) map[string]string {
+	names := make(map[string]string, len(msgs))
+	for _, msg := range msgs {
+		names[msg.PkgPath+"."+msg.GoName] = msg.Name
+	}
+	return names
+}
+
+// enumsByGoName maps the package-qualified Go name of every enum to it, matching the
+// NamedType of the fields using it.
+func enumsByGoName(enums []*Enum) map[string]*Enum {
+	result := make(map[string]*Enum, len(enums))
+	for _, e := range enums {
+		result[e.PkgPath+"."+e.GoName] = e
+	}
+	return result
+}
+
+// enumSymbols turns enum values into identifiers for formats with named enum symbols,
+// e.g. "in-progress" into "IN_PROGRESS".
+func enumSymbols(values []string) []string {
+	symbols := make([]string, len(values))
+	for i, v := range values {
+		symbol := strings.Map(func(r rune) rune {
+			if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+				return unicode.ToUpper(r)
+			}
+			return '_'
+		}, v)
+		if symbol == "" || unicode.IsDigit(rune(symbol[0])) {
+			symbol = "_" + symbol
+		}
+		symbols[i] = symbol
+	}
+	return symbols
+}
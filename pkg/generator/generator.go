@@ -0,0 +1,516 @@
+// Package generator turns Go types annotated with @go2proto into protobuf definitions.
+// It is the engine behind the go2proto command and can be embedded by other tools:
+//
+//	opts := &generator.Options{
+//		Patterns:     []string{"./models"},
+//		Output:       "models/models.proto",
+//		GoPackage:    "github.com/acme/models",
+//		ProtoPackage: "acme.models",
+//	}
+//	pkgs, err := generator.Load(opts)
+//	...
+//	model, err := generator.Analyze(pkgs, opts)
+//	...
+//	err = generator.Generate(os.Stdout, model, opts)
+package generator
+
+import (
+	"go/token"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Options configures loading, analysis and generation.
+type Options struct {
+	// Dir is the directory package patterns are resolved from; empty means the current directory.
+	Dir string
+	// Patterns are the packages to analyse, e.g. "./models" or a fully qualified import path.
+	Patterns []string
+	// BuildTags are passed to the build system as -tags, selecting files guarded by build
+	// constraints such as "//go:build integration".
+	BuildTags []string
+	// GOOS and GOARCH load the packages as for another platform; empty means the host's.
+	GOOS   string
+	GOARCH string
+	// IncludeTests also loads the packages' _test.go files, so fixture types declared in
+	// tests can be annotated.
+	IncludeTests bool
+	// DisableCgo loads packages with CGO_ENABLED=0, leaving out their cgo files, for
+	// environments without a C toolchain.
+	DisableCgo bool
+	// Filters keeps only the annotated types whose name contains one of them (case insensitive).
+	// An empty list keeps every annotated type.
+	Filters []string
+
+	// Duplicates controls messages from different packages sharing a name: DuplicatesError
+	// (the default) fails, DuplicatesPrefix prefixes them with their Go package name.
+	Duplicates string
+
+	// FieldNaming is the naming style of fields: NamingSnake (the default), NamingOriginal,
+	// NamingLowerCamel, NamingCamel or NamingScreamingSnake.
+	FieldNaming string
+	// Naming, if set, replaces FieldNaming to name messages, fields, enums and enum values;
+	// see NewNaming for the built-in styles and templates.
+	Naming Naming
+	// Durations is how time.Duration fields are represented: DurationNanos (the default) or
+	// DurationMillis as int64, or DurationWellKnown as google.protobuf.Duration. A field's
+	// go2proto:"duration=..." tag takes precedence.
+	Durations string
+	// Times is how time.Time fields are represented: TimeTimestamp (the default) as
+	// google.protobuf.Timestamp, or TimeUnixSeconds or TimeUnixMillis as int64 unix time. A
+	// field's go2proto:"time=..." tag takes precedence.
+	Times string
+	// FieldMaskTypes are Go types, as import/path.Name, emitted as google.protobuf.FieldMask
+	// besides fieldmaskpb.FieldMask, e.g. the mask type of a package wrapping it.
+	FieldMaskTypes []string
+	// AnyStrategy is how dynamic fields, of types interface{}, map[string]interface{} and
+	// json.RawMessage, are represented: AnyStrategyStruct (the default) as
+	// google.protobuf.Struct for maps and google.protobuf.Value otherwise, AnyStrategyAny as
+	// google.protobuf.Any, or AnyStrategyBytes as JSON-encoded bytes. A field's
+	// go2proto:"any=..." tag takes precedence.
+	AnyStrategy string
+	// NonASCII controls names holding letters or digits outside ASCII, which Go allows but
+	// proto doesn't: NonASCIIError (the default) fails, NonASCIITransliterate replaces them
+	// with their ASCII letters ("Größe" becomes "Grosse").
+	NonASCII string
+
+	// MessagePrefix and MessageSuffix are added to the name of every message, e.g. "Api" or
+	// "Pb", to keep generated messages apart from hand-written ones in the same package.
+	MessagePrefix string
+	MessageSuffix string
+
+	// Strict fails the analysis when a field references a type that has no proto mapping,
+	// instead of reporting it as a diagnostic.
+	Strict bool
+
+	// CollapseWrappers replaces messages that only wrap a repeated field, such as
+	// ArrayOfEventField{EventField []*EventField}, with repeated fields on their referrers.
+	CollapseWrappers bool
+
+	// Format is the output format: FormatProto (the default), FormatOpenAPI, FormatJSONSchema,
+	// FormatAvro, FormatThrift, FormatFlatBuffers, FormatGraphQL, FormatDTS or a format added
+	// with RegisterEmitter.
+	Format string
+	// GraphQLScalars overrides the GraphQL type of proto scalar and well-known types for
+	// FormatGraphQL, e.g. "google.protobuf.Timestamp" to "DateTime".
+	GraphQLScalars map[string]string
+
+	// Output is the path of the file holding the messages of ProtoPackage. Messages placed in
+	// another proto package are written beside it as <package>.proto.
+	Output string
+	// Outputs maps the import paths of Go packages to the proto files their messages and
+	// services are written to instead, e.g. "github.com/acme/users" to "api/users.proto".
+	// Files import each other relative to the directory of Output.
+	Outputs map[string]string
+	// PublicImports are re-exported by the file at Output with import public, so an umbrella
+	// file gives downstream consumers the messages of the other files, e.g. "users.proto".
+	PublicImports []string
+	// OptimizeFor sets the optimize_for option of proto files: OptimizeSpeed,
+	// OptimizeCodeSize or OptimizeLiteRuntime. Empty leaves it out.
+	OptimizeFor string
+	// Imports adds imports to the proto files, keyed by file path or "" for every file, e.g.
+	// the files defining the extensions used by Message.Options. Imports the files already
+	// carry are not repeated.
+	Imports map[string][]string
+	// GoPackage is the go_package option of the generated files.
+	GoPackage string
+	// ProtoPackage is the proto package of messages that don't set one in their annotation.
+	ProtoPackage string
+	// ProtoPackages maps the import paths of Go packages to the proto package of their
+	// messages, enums and services, e.g. "github.com/acme/users" to "acme.users". Packages
+	// set in annotations take precedence.
+	ProtoPackages map[string]string
+	// PackageVersion, e.g. "v1", is appended to ProtoPackage and to the packages set in
+	// annotations, unless they already end in a version (see VersionedPackage).
+	PackageVersion string
+	// Numbering is the field numbering strategy, NumberingOrder (the default) or NumberingHash.
+	Numbering string
+	// Alphabetical emits messages in name order instead of placing referenced messages
+	// before the messages referencing them.
+	Alphabetical bool
+	// Merge preserves the manual sections of existing output files (see ManualBegin).
+	Merge bool
+	// Append updates only the generated messages inside existing output files.
+	Append bool
+	// Backup keeps the previous version of every overwritten output file as <path>.bak.
+	Backup bool
+	// NoOverwrite fails instead of writing output files that already exist.
+	NoOverwrite bool
+	// Force replaces existing output files with the generated ones even with Merge or Append
+	// set, discarding their manual sections and other definitions.
+	Force bool
+	// FileMode sets the permissions of written files, e.g. 0644, regardless of the umask. The
+	// directories created for them get the same mode, searchable wherever it grants read
+	// (0755 for 0644). Zero keeps the permissions of existing files, creating new ones with
+	// 0644 and directories with 0755, less the umask.
+	FileMode os.FileMode
+
+	// Reproducible leaves the go2proto version out of generated headers, so the output only
+	// depends on the sources and options, not on the build of go2proto producing it.
+	Reproducible bool
+	// SourceComments adds a "// source: path:line" comment to every message and field of
+	// proto files, with the path relative to the root of the Go module declaring it.
+	SourceComments bool
+	// LineEnding ends the lines of every written file: LineEndingLF (the default) or
+	// LineEndingCRLF.
+	LineEnding string
+
+	// CacheDir caches per-package analysis results between runs; empty disables caching.
+	CacheDir string
+
+	// BatchSize is the number of packages LoadAndAnalyze loads at a time; zero means
+	// DefaultBatchSize.
+	BatchSize int
+
+	// Progress, if set, is called after each package is analysed with the number of packages
+	// done so far. Calls are serialised but may come from different goroutines.
+	Progress func(done, total int, pkgPath string)
+	// Timings, if set, accumulates the time Load, Analyze and LoadAndAnalyze spend loading
+	// and analysing packages.
+	Timings Timings
+
+	// Hooks run in order on the analysed model before Analyze or LoadAndAnalyze return it,
+	// so conventions can be enforced programmatically: renaming messages and fields,
+	// dropping messages or adding options. A hook returning an error fails the analysis.
+	Hooks []Hook
+
+	// Verbose receives what was loaded and matched; nil discards it.
+	Verbose Logger
+	// Debug receives every type mapping decision; nil discards it.
+	Debug Logger
+}
+
+// Output formats.
+const (
+	// FormatProto writes .proto files, one per proto package.
+	FormatProto = "proto"
+	// FormatOpenAPI writes a single OpenAPI 3 document with a component schema per message.
+	FormatOpenAPI = "openapi"
+	// FormatJSONSchema writes a JSON Schema document per message into the Output directory.
+	FormatJSONSchema = "jsonschema"
+	// FormatAvro writes a single Avro schema (.avsc) holding a record per message.
+	FormatAvro = "avro"
+	// FormatThrift writes a single Thrift IDL file with a struct per message.
+	FormatThrift = "thrift"
+	// FormatFlatBuffers writes a single FlatBuffers schema (.fbs) with a table per message.
+	FormatFlatBuffers = "flatbuffers"
+	// FormatGraphQL writes a single GraphQL SDL file with a type per message.
+	FormatGraphQL = "graphql"
+	// FormatDTS writes a single TypeScript declaration file (.d.ts) with an interface per message.
+	FormatDTS = "dts"
+)
+
+// Values of the optimize_for file option.
+const (
+	OptimizeSpeed       = "SPEED"
+	OptimizeCodeSize    = "CODE_SIZE"
+	OptimizeLiteRuntime = "LITE_RUNTIME"
+)
+
+// Naming styles, for FieldNaming and NewNaming.
+const (
+	// NamingSnake converts Go names to snake_case: EventFieldItemID becomes
+	// event_field_item_id.
+	NamingSnake = "snake"
+	// NamingOriginal keeps Go names as they are.
+	NamingOriginal = "original"
+	// NamingLowerCamel converts Go names to lowerCamelCase: eventFieldItemId.
+	NamingLowerCamel = "lowerCamel"
+	// NamingCamel converts Go names to UpperCamelCase: EventFieldItemId.
+	NamingCamel = "camel"
+	// NamingScreamingSnake converts Go names to SCREAMING_SNAKE_CASE: EVENT_FIELD_ITEM_ID.
+	NamingScreamingSnake = "screamingSnake"
+)
+
+// Representations of time.Duration fields.
+const (
+	DurationNanos     = "nanos"
+	DurationMillis    = "millis"
+	DurationWellKnown = "well-known"
+)
+
+// Representations of time.Time fields.
+const (
+	TimeTimestamp   = "timestamp"
+	TimeUnixSeconds = "unix-seconds"
+	TimeUnixMillis  = "unix-millis"
+)
+
+// Representations of dynamic fields.
+const (
+	AnyStrategyAny    = "any"
+	AnyStrategyStruct = "struct"
+	AnyStrategyBytes  = "bytes"
+)
+
+// Ways of handling names outside ASCII.
+const (
+	NonASCIIError         = "error"
+	NonASCIITransliterate = "transliterate"
+)
+
+// Line endings of the written files.
+const (
+	LineEndingLF   = "lf"
+	LineEndingCRLF = "crlf"
+)
+
+// Ways of handling duplicate message names.
+const (
+	DuplicatesError  = "error"
+	DuplicatesPrefix = "prefix"
+)
+
+// Logger receives log messages. *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// Hook changes the model between analysis and emission. Fields and services reference
+// messages by their Go type, so renaming a message renames its references; references to
+// a dropped message are reported as unmapped types.
+type Hook func(model *Model) error
+
+func (o *Options) verbosef(format string, args ...interface{}) {
+	if o.Verbose != nil {
+		o.Verbose.Printf(format, args...)
+	}
+}
+
+func (o *Options) debugf(format string, args ...interface{}) {
+	if o.Debug != nil {
+		o.Debug.Printf(format, args...)
+	}
+}
+
+// Model holds the messages and enums found in the analysed packages.
+type Model struct {
+	Messages []*Message
+	Enums    []*Enum
+	Services []*Service `json:",omitempty"`
+	// Skipped lists every field and type that was left out.
+	Skipped []*SkippedItem
+	// Diagnostics lists problems with the generated types that are worth a warning.
+	Diagnostics []*Diagnostic
+}
+
+// Message represents a proto message (one Go struct).
+type Message struct {
+	Name   string
+	Fields []*Field
+	// GoName is the name of the Go struct, and PkgPath the import path of its package.
+	GoName  string
+	PkgPath string
+	// Package is the proto package the message is emitted in; empty means Options.ProtoPackage.
+	Package string `json:",omitempty"`
+	// Pos is the position of the struct's declaration in the Go source.
+	Pos token.Position
+	// Constraints are emitted as protovalidate (buf.validate.message).cel options.
+	Constraints []*Constraint `json:",omitempty"`
+	// Deprecated is emitted as the message's deprecated option.
+	Deprecated bool `json:",omitempty"`
+	// Options are emitted verbatim as option statements of the message, e.g.
+	// `(acme.resource).type = "users"`.
+	Options []string `json:",omitempty"`
+}
+
+// Constraint is a message-level protovalidate CEL rule.
+type Constraint struct {
+	ID string
+	// Message is reported when Expression evaluates to false; empty if the expression
+	// itself evaluates to the error message.
+	Message    string `json:",omitempty"`
+	Expression string
+}
+
+// Field represents a field in a proto message.
+type Field struct {
+	Name       string
+	TypeName   string
+	Order      int
+	IsRepeated bool
+	EnumValues []string
+	// NamedType is the package-qualified name of the field's Go named type (or of the element
+	// type for pointers and slices), e.g. "example.com/models.User", used to resolve enums and
+	// message references once every package has been analysed.
+	NamedType string `json:",omitempty"`
+	// MapKey is the key type of map fields, whose TypeName is the type of their values.
+	MapKey string `json:",omitempty"`
+	// Oneof is the name of the oneof the field belongs to, if any.
+	Oneof string `json:",omitempty"`
+	// Pinned marks an Order set by the field's number tag, which numbering keeps.
+	Pinned bool `json:",omitempty"`
+	// NumberNote is rendered as a comment above the field, recording how its number was chosen.
+	NumberNote string `json:",omitempty"`
+	// Comment is rendered as a comment above the field, e.g. the unit of an integer holding
+	// a duration.
+	Comment string `json:",omitempty"`
+	// GoName is the name of the Go struct field, and Pos its position in the Go source.
+	GoName string
+	Pos    token.Position
+}
+
+// Enum holds information about an enum name + all of its variants (as discovered in Go).
+type Enum struct {
+	Name string
+	// GoName and PkgPath identify the Go type of the enum's constants. An untyped annotated
+	// const block uses the enum name and the block's package.
+	GoName  string
+	PkgPath string
+	Package string `json:",omitempty"`
+	Values  []string
+	// Deprecated marks the enum deprecated; proto files, which inline enums as strings,
+	// deprecate the fields of the enum instead.
+	Deprecated bool `json:",omitempty"`
+	// Pos is the position of the enum's declaration in the Go source.
+	Pos token.Position
+}
+
+// Service is a service generated from the exported methods of a struct annotated with
+// "@go2proto service".
+type Service struct {
+	Name string
+	// GoName is the name of the Go struct, and PkgPath the import path of its package.
+	GoName  string
+	PkgPath string
+	// Package is the proto package the service is emitted in; empty means Options.ProtoPackage.
+	Package string `json:",omitempty"`
+	Pos     token.Position
+	Methods []*Method
+}
+
+// Method is an RPC of a Service.
+type Method struct {
+	Name string
+	// Request and Response are the message names of the parameter and the result.
+	Request  string
+	Response string
+	// RequestType and ResponseType are the package-qualified names of the Go structs, e.g.
+	// "example.com/users.GetUserRequest", resolved to message names when the output is planned.
+	RequestType  string
+	ResponseType string
+	// ServerStreaming streams Response messages, for methods marked "@go2proto stream"
+	// that return a slice of them.
+	ServerStreaming bool `json:",omitempty"`
+	// Idempotency is the idempotency_level option of the RPC, NO_SIDE_EFFECTS or IDEMPOTENT.
+	// connect-go serves NO_SIDE_EFFECTS RPCs over HTTP GET too.
+	Idempotency string `json:",omitempty"`
+	Pos         token.Position
+}
+
+// VersionedPackage appends version to the proto package pkg as its last element, following
+// buf's PACKAGE_VERSION_SUFFIX convention: acme.users becomes acme.users.v1. Packages that
+// already end in a version, such as acme.users.v2beta1, are returned unchanged, as is pkg
+// when version is empty.
+func VersionedPackage(pkg, version string) string {
+	if version == "" || pkg == "" {
+		return pkg
+	}
+	if packageVersionPattern.MatchString(pkg[strings.LastIndex(pkg, ".")+1:]) {
+		return pkg
+	}
+	return pkg + "." + version
+}
+
+// packageVersionPattern matches the version elements buf lint accepts: v1, v1beta1, v1test,
+// v1p1alpha1.
+var packageVersionPattern = regexp.MustCompile(`^v[0-9]+(test[a-z0-9_]*|(p[0-9]+)?(alpha|beta)[0-9]*)?$`)
+
+// IsPackageVersion reports whether version is a valid PackageVersion.
+func IsPackageVersion(version string) bool {
+	return packageVersionPattern.MatchString(version)
+}
+
+// Files splits the model into the files it generates: one .proto file per proto package,
+// a schema per message for FormatJSONSchema, or a single file for the other formats.
+func Files(model *Model, opts *Options) []*File {
+	if opts.PackageVersion != "" {
+		versioned := *opts
+		versioned.ProtoPackage = VersionedPackage(opts.ProtoPackage, opts.PackageVersion)
+		versioned.PackageVersion = ""
+		for _, msg := range model.Messages {
+			if msg.Package != "" {
+				msg.Package = VersionedPackage(msg.Package, opts.PackageVersion)
+			}
+		}
+		for _, e := range model.Enums {
+			if e.Package != "" {
+				e.Package = VersionedPackage(e.Package, opts.PackageVersion)
+			}
+		}
+		for _, svc := range model.Services {
+			if svc.Package != "" {
+				svc.Package = VersionedPackage(svc.Package, opts.PackageVersion)
+			}
+		}
+		opts = &versioned
+	}
+
+	var files []*File
+	switch opts.Format {
+	case "", FormatProto:
+		files = planOutputs(model.Messages, model.Services, opts.Output, opts.GoPackage, opts.ProtoPackage, opts.Outputs)
+		if len(opts.PublicImports) > 0 {
+			files = addPublicImports(files, opts.PublicImports, opts.Output, opts.GoPackage, opts.ProtoPackage)
+		}
+		if len(opts.Imports) > 0 {
+			addExtraImports(files, opts.Imports)
+		}
+		for _, f := range files {
+			f.Merge = opts.Merge && !opts.Force
+			f.Append = opts.Append && !opts.Force
+			f.OptimizeFor = opts.OptimizeFor
+			f.Enums = model.Enums
+			if opts.CacheDir != "" {
+				f.Snapshot = snapshotPath(opts.CacheDir, f.Path)
+			}
+			if !opts.Alphabetical {
+				f.Messages = orderByDependency(f.Messages)
+			}
+			if opts.Numbering == NumberingHash {
+				applyHashNumbering(f, opts)
+			}
+		}
+	case FormatJSONSchema:
+		files = jsonSchemaFiles(model.Messages, opts.Output, opts.ProtoPackage)
+	default:
+		msgs := model.Messages
+		if !opts.Alphabetical {
+			msgs = orderByDependency(msgs)
+		}
+		files = []*File{{
+			Path:           opts.Output,
+			GoPackage:      opts.GoPackage,
+			ProtoPackage:   opts.ProtoPackage,
+			Messages:       msgs,
+			Enums:          model.Enums,
+			Services:       model.Services,
+			Format:         opts.Format,
+			GraphQLScalars: opts.GraphQLScalars,
+		}}
+	}
+	for _, f := range files {
+		f.Backup = opts.Backup
+		f.NoOverwrite = opts.NoOverwrite
+		f.Reproducible = opts.Reproducible
+		f.SourceComments = opts.SourceComments
+		f.LineEnding = opts.LineEnding
+		f.Mode = opts.FileMode
+	}
+	return files
+}
+
+// Generate writes the file holding the messages of opts.ProtoPackage to w. Use Files to
+// render the files of messages placed in other packages as well.
+func Generate(w io.Writer, model *Model, opts *Options) error {
+	pkg := VersionedPackage(opts.ProtoPackage, opts.PackageVersion)
+	for _, f := range Files(model, opts) {
+		if f.ProtoPackage == pkg {
+			_, err := f.WriteTo(w)
+			return err
+		}
+	}
+	return nil
+}
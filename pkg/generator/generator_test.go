@@ -0,0 +1,2743 @@
+package generator
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beam-cloud/go2proto/example/in"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/tools/go/packages"
+)
+
+func TestLoadPackages(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"../../example/in"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	assert.True(len(pkgs) > 0, "pkgs should not be empty")
+}
+
+func TestGetMessages(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"../../example/in"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+
+	model, err := Analyze(pkgs, &Options{})
+	if err != nil {
+		t.Fatalf("error analysing packages: %s", err)
+	}
+
+	for _, msg := range model.Messages {
+		t.Logf("message: %s", msg.Name)
+	}
+
+	for _, enum := range model.Enums {
+		t.Logf("enum: %s", enum.Name)
+	}
+}
+
+func TestCheckOutput(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"../../example/in"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, &Options{})
+	if err != nil {
+		t.Fatalf("error analysing packages: %s", err)
+	}
+	msgs := model.Messages
+
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "out.proto")
+
+	files := planOutputs(msgs, nil, path, "in", "in", nil)
+	diff, err := CheckFiles(files)
+	assert.NoError(err)
+	assert.Contains(diff, "+message EventField {", "missing file should show the whole output as added")
+
+	assert.NoError(WriteFiles(files))
+	diff, err = CheckFiles(files)
+	assert.NoError(err)
+	assert.Empty(diff, "freshly written file should be up to date")
+
+	diff, err = CheckFiles(planOutputs(msgs, nil, path, "in", "other", nil))
+	assert.NoError(err)
+	assert.Contains(diff, "-package in;")
+	assert.Contains(diff, "+package other;")
+}
+
+func TestGenerate(t *testing.T) {
+	opts := &Options{Patterns: []string{"./testdata/annotated"}, Output: "teams.proto", GoPackage: "annotated", ProtoPackage: "acme.teams.v1"}
+	pkgs, err := Load(opts)
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, opts)
+	if err != nil {
+		t.Fatalf("error analysing packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	assert.NoError(Generate(&buf, model, opts))
+	assert.Contains(buf.String(), "package acme.teams.v1;")
+	assert.Contains(buf.String(), "message TeamV2 {")
+	assert.NotContains(buf.String(), "message UserV2 {", "UserV2 lives in another package")
+
+	files := Files(model, opts)
+	assert.Len(files, 2)
+	assert.Equal("acme.users.v1.proto", files[0].Path)
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	assert := assert.New(t)
+	assert.Empty(unifiedDiff("a", "b", "x\ny\n", "x\ny\n"))
+
+	diff := unifiedDiff("a", "b", "1\n2\n3\n4\n5\n6\n7\n8\n9\n", "1\n2\n3\n4\nfive\n6\n7\n8\n9\n")
+	assert.Equal("--- a\n+++ b\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n", diff)
+}
+
+func TestVersionInHeader(t *testing.T) {
+	out, err := renderOutput(&File{GoPackage: "in", ProtoPackage: "in"})
+	if err != nil {
+		t.Fatalf("error rendering output: %s", err)
+	}
+
+	assert := assert.New(t)
+	assert.NotEmpty(Version())
+	assert.True(strings.HasPrefix(string(out), "// Code generated by go2proto "+Version()+". DO NOT EDIT.\n"))
+}
+
+func TestAnalysisCache(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"../../example/in"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	opts := &Options{CacheDir: t.TempDir()}
+	c := &cache{dir: opts.CacheDir}
+
+	want, err := Analyze(pkgs, opts)
+	assert.NoError(err)
+	assert.FileExists(c.path(pkgs[0].PkgPath))
+
+	got, err := Analyze(pkgs, opts)
+	assert.NoError(err)
+	assert.Equal(want, got, "cached analysis should produce the same messages")
+
+	// A different filter must not be served from the entry stored for another one.
+	key, err := c.key(pkgs[0], &Options{})
+	assert.NoError(err)
+	otherKey, err := c.key(pkgs[0], &Options{Filters: []string{"eventfield"}})
+	assert.NoError(err)
+	assert.NotEqual(key, otherKey)
+	namingKey, err := c.key(pkgs[0], &Options{FieldNaming: NamingOriginal})
+	assert.NoError(err)
+	assert.NotEqual(key, namingKey)
+	_, ok := c.get(pkgs[0].PkgPath, otherKey)
+	assert.False(ok)
+}
+
+func TestLoadSkipsDependencySyntax(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/validate"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	assert.NotEmpty(pkgs[0].Syntax)
+	assert.Empty(pkgs[0].Imports["time"].Syntax, "dependencies should come from export data")
+
+	model, err := Analyze(pkgs, &Options{})
+	assert.NoError(err)
+	assert.Equal("google.protobuf.Timestamp", model.Messages[0].Fields[0].TypeName)
+}
+
+func TestLoadSkipsCachedPackages(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/cached\n\ngo 1.21\n"), 0666))
+	for pkg, src := range map[string]string{"models": "../../example/in/model.go", "accounts": "testdata/second/second.go"} {
+		data, err := ioutil.ReadFile(src)
+		assert.NoError(err)
+		assert.NoError(os.MkdirAll(filepath.Join(dir, pkg), 0755))
+		assert.NoError(ioutil.WriteFile(filepath.Join(dir, pkg, pkg+".go"), data, 0666))
+	}
+
+	opts := &Options{Dir: dir, Patterns: []string{"./..."}, CacheDir: t.TempDir()}
+	// load returns the analysed model and which packages were type checked.
+	load := func() (*Model, map[string]bool) {
+		pkgs, err := Load(opts)
+		if err != nil {
+			t.Fatalf("error loading packages: %s", err)
+		}
+		checked := make(map[string]bool)
+		for _, p := range pkgs {
+			checked[p.PkgPath] = p.Types != nil
+		}
+		model, err := Analyze(pkgs, opts)
+		assert.NoError(err)
+		return model, checked
+	}
+
+	want, checked := load()
+	assert.Equal(map[string]bool{"example.com/cached/accounts": true, "example.com/cached/models": true}, checked)
+
+	got, checked := load()
+	assert.Equal(map[string]bool{"example.com/cached/accounts": false, "example.com/cached/models": false}, checked)
+	assert.Equal(want, got)
+
+	f, err := os.OpenFile(filepath.Join(dir, "accounts", "accounts.go"), os.O_APPEND|os.O_WRONLY, 0)
+	assert.NoError(err)
+	fmt.Fprintln(f, "\n// @go2proto\ntype Ledger struct{ Accounts []Account }")
+	f.Close()
+	got, checked = load()
+	assert.Equal(map[string]bool{"example.com/cached/accounts": true, "example.com/cached/models": false}, checked)
+	assert.Len(got.Messages, len(want.Messages)+1)
+}
+
+// BenchmarkLoad compares loadMode with LoadAllSyntax, which type checks every dependency
+// from source.
+func BenchmarkLoad(b *testing.B) {
+	for _, bm := range []struct {
+		name string
+		mode packages.LoadMode
+	}{{"LoadAllSyntax", packages.LoadAllSyntax}, {"loadMode", loadMode}} {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				cfg := &packages.Config{Dir: "../..", Mode: bm.mode, Fset: token.NewFileSet()}
+				if _, err := packages.Load(cfg, "./..."); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheDependencies(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	// write replaces the source of a package of the example.com/deps module.
+	write := func(pkg, src string) {
+		assert.NoError(os.MkdirAll(filepath.Join(dir, pkg), 0755))
+		assert.NoError(ioutil.WriteFile(filepath.Join(dir, pkg, pkg+".go"), []byte(src), 0666))
+	}
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/deps\n\ngo 1.21\n"), 0666))
+	write("keys", "package keys\n\ntype Key string\n")
+	write("ids", "package ids\n\nimport \"example.com/deps/keys\"\n\ntype ID string\n\ntype Ref keys.Key\n")
+	write("users", "package users\n\nimport \"example.com/deps/ids\"\n\n// @go2proto\ntype User struct {\n\tID  ids.ID\n\tRef ids.Ref\n}\n")
+
+	opts := &Options{Dir: dir, Patterns: []string{"./users"}, CacheDir: t.TempDir()}
+	// load returns the types of the User fields and whether users was type checked.
+	load := func() ([]string, bool) {
+		pkgs, err := Load(opts)
+		if err != nil {
+			t.Fatalf("error loading packages: %s", err)
+		}
+		model, err := Analyze(pkgs, opts)
+		assert.NoError(err)
+		var types []string
+		for _, fd := range model.Messages[0].Fields {
+			types = append(types, fd.TypeName)
+		}
+		return types, pkgs[0].Types != nil
+	}
+
+	types, checked := load()
+	assert.Equal([]string{"string", "string"}, types)
+	assert.True(checked)
+	_, checked = load()
+	assert.False(checked, "nothing changed")
+
+	write("ids", "package ids\n\nimport \"example.com/deps/keys\"\n\ntype ID int64\n\ntype Ref keys.Key\n")
+	types, checked = load()
+	assert.Equal([]string{"int64", "string"}, types, "a change to an imported package invalidates the entry")
+	assert.True(checked)
+
+	write("keys", "package keys\n\ntype Key uint64\n")
+	types, checked = load()
+	assert.Equal([]string{"int64", "uint64"}, types, "so does a change to an indirect import")
+	assert.True(checked)
+
+	write("keys", "package keys\n\ntype Key bool\n")
+	model, _, err := LoadAndAnalyze(opts)
+	assert.NoError(err)
+	assert.Equal("bool", model.Messages[0].Fields[1].TypeName, "batched loads check the dependencies too")
+}
+
+func TestLoadAndAnalyzeBatches(t *testing.T) {
+	assert := assert.New(t)
+	patterns := []string{"../../example/in", "./testdata/second", "./testdata/validate"}
+	pkgs, err := Load(&Options{Patterns: patterns})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	want, err := Analyze(pkgs, &Options{})
+	assert.NoError(err)
+
+	var progress []int
+	opts := &Options{Patterns: patterns, BatchSize: 2, Progress: func(done, total int, pkgPath string) {
+		assert.Equal(3, total)
+		progress = append(progress, done)
+	}}
+	got, listed, err := LoadAndAnalyze(opts)
+	assert.NoError(err)
+	assert.Equal(want, got)
+	assert.ElementsMatch([]int{1, 2, 3}, progress)
+	assert.Len(listed, 3)
+	for _, p := range listed {
+		assert.NotEmpty(p.GoFiles)
+		assert.Nil(p.Syntax, "only the listing of %s should be kept", p.PkgPath)
+		assert.Nil(p.Types)
+	}
+
+	_, _, err = LoadAndAnalyze(&Options{Patterns: []string{"./testdata/missing"}})
+	var loadErr *LoadError
+	assert.True(errors.As(err, &loadErr), "expected a LoadError, got %v", err)
+}
+
+func TestAnalyzePackagesDeterministic(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"../../example/in", "./testdata/second"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	var progress []int
+	models := analyzePackages(pkgs, &Options{Progress: func(done, total int, pkgPath string) {
+		assert.Equal(len(pkgs), total)
+		progress = append(progress, done)
+	}})
+	assert.Equal([]int{1, 2}, progress)
+	assert.Len(models, len(pkgs))
+	for i, model := range models {
+		assert.Equal(pkgs[i].PkgPath, model.PkgPath, "models must keep the order of the packages")
+	}
+
+	first, err := Analyze(pkgs, &Options{})
+	assert.NoError(err)
+	for i := 0; i < 5; i++ {
+		again, err := Analyze(pkgs, &Options{})
+		assert.NoError(err)
+		assert.Equal(first, again)
+	}
+
+	annotated := annotatedTypes(pkgs[0].Syntax)
+	assert.Contains(annotated, "EventField")
+	assert.NotContains(annotated, "User", "User is not annotated")
+}
+
+func TestMultipleFilters(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"../../example/in", "./testdata/second"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	model, err := Analyze(pkgs, &Options{Filters: []string{"EventSub", "account", "ArrayOfEventFieldItem"}})
+	assert.NoError(err)
+	var names []string
+	for _, msg := range model.Messages {
+		names = append(names, msg.Name)
+	}
+	assert.Equal([]string{"Account", "ArrayOfEventFieldItem", "EventSubForm"}, names)
+}
+
+func TestAnnotationArguments(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/annotated"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, &Options{})
+	if err != nil {
+		t.Fatalf("error analysing packages: %s", err)
+	}
+	msgs := model.Messages
+
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "teams.proto")
+	files := planOutputs(msgs, nil, path, "annotated", "acme.teams.v1", nil)
+	assert.Len(files, 2)
+
+	assert.NoError(WriteFiles(files))
+	teams, err := ioutil.ReadFile(path)
+	assert.NoError(err)
+	assert.Contains(string(teams), `import "acme.users.v1.proto";`)
+	assert.Contains(string(teams), "message TeamV2 {\n  option deprecated = true;\n")
+	assert.Contains(string(teams), "  acme.users.v1.UserV2 owner = 1;")
+	assert.Contains(string(teams), "  repeated acme.users.v1.UserV2 members = 2;")
+
+	users, err := ioutil.ReadFile(filepath.Join(filepath.Dir(path), "acme.users.v1.proto"))
+	assert.NoError(err)
+	assert.Contains(string(users), "package acme.users.v1;")
+	assert.Contains(string(users), "message UserV2 {\n  string id = 1;")
+
+	dts, err := renderDTS(&File{Messages: msgs, Enums: model.Enums})
+	assert.NoError(err)
+	assert.Contains(string(dts), "/** @deprecated */\nexport type Role = ")
+	assert.Contains(string(dts), "/** @deprecated */\nexport interface TeamV2 {")
+	assert.Contains(string(dts), "\n\nexport interface UserV2 {")
+}
+
+func TestGroupedDeclarations(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/grouped"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, &Options{})
+	if err != nil {
+		t.Fatalf("error analysing packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	packages := make(map[string]string)
+	for _, msg := range model.Messages {
+		packages[msg.GoName+" as "+msg.Name] = msg.Package
+	}
+	assert.Equal(map[string]string{
+		"Point as Point":    "",
+		"Line as Line":      "",
+		"Round as Circle":   "",
+		"Square as Square":  "acme.shapes",
+		"Rectangle as Rect": "",
+	}, packages, "the annotation of a type in a group replaces the group's")
+}
+
+func TestConstBlockEnums(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/constenum"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, &Options{})
+	if err != nil {
+		t.Fatalf("error analysing packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	if assert.Len(model.Enums, 3) {
+		for i, line := range []int{6, 24, 15} {
+			assert.Equal(line, model.Enums[i].Pos.Line, "enums point at their const block or type")
+		}
+		jobState, priority := *model.Enums[0], *model.Enums[2]
+		jobState.Pos, priority.Pos = token.Position{}, token.Position{}
+		assert.Equal(Enum{Name: "JobState", GoName: "State", PkgPath: "github.com/beam-cloud/go2proto/pkg/generator/testdata/constenum/states",
+			Values: []string{"pending", "running", "done"}}, jobState)
+		assert.Equal([]string{"batch", "stream"}, model.Enums[1].Values, "blank and go2proto:skip constants are left out")
+		assert.Equal(Enum{Name: "Priority", GoName: "Priority", PkgPath: "github.com/beam-cloud/go2proto/pkg/generator/testdata/constenum",
+			Values: []string{"Low", "Normal", "High"}, Deprecated: true}, priority)
+	}
+	if assert.Len(model.Messages, 1) {
+		state := model.Messages[0].Fields[0]
+		assert.Equal("string", state.TypeName)
+		assert.Equal([]string{"pending", "running", "done"}, state.EnumValues)
+		assert.Empty(model.Messages[0].Fields[1].EnumValues)
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(Generate(&buf, model, &Options{Output: "job.proto", GoPackage: "job", ProtoPackage: "job", SourceComments: true}))
+	assert.Contains(buf.String(), "// possible values: pending, running, done\n"+
+		"// source of enum JobState: pkg/generator/testdata/constenum/constenum.go:6\n  string state = 1;\n")
+	assert.Contains(buf.String(), "// possible values: batch, stream\n"+
+		"// source of enum Kind: pkg/generator/testdata/constenum/constenum.go:24\n"+
+		"// enum Kind is deprecated\n  string kind = 3 [deprecated = true];\n", "the fields of deprecated enums are deprecated")
+
+	model, err = Analyze(pkgs, &Options{Filters: []string{"job"}})
+	assert.NoError(err)
+	assert.Len(model.Enums, 1)
+}
+
+func TestIneffectiveAnnotations(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/ineffective"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, &Options{})
+	if err != nil {
+		t.Fatalf("error analysing packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	assert.Len(model.Messages, 4)
+	var warnings []string
+	for _, d := range model.Diagnostics {
+		assert.Equal(SeverityWarning, d.Severity)
+		assert.Equal(CodeIneffectiveAnnotation, d.Code)
+		warnings = append(warnings, fmt.Sprintf("%d: %s: %s", d.Line, d.Type, d.Message))
+	}
+	assert.ElementsMatch([]string{
+		"6: Handler: @go2proto has no effect: func types are neither messages nor enums",
+		"9: Labels: @go2proto has no effect: map types are neither messages nor enums",
+		"12: Level: @go2proto has no effect: Level has no constants of its type, so it is not an enum",
+		"15: NewRequest: @go2proto has no effect: funcs are neither messages nor enums",
+		"18: : @go2proto has no effect: vars are neither messages nor enums",
+		"21: : @go2proto has no effect: const blocks need enum=<name> to define an enum",
+		`28: Response: unknown @go2proto argument "nmae", ignoring it`,
+		`28: Response: invalid @go2proto idempotency value "bogus", expected no-side-effects or idempotent, ignoring it`,
+		`34: Page: unterminated @go2proto arguments in "// @go2proto(name=Page", ignoring them`,
+		`40: Limit: malformed go2proto:cel directive "// go2proto:cel size_positive", ignoring it`,
+	}, warnings)
+}
+
+func TestServices(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/service"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, &Options{})
+	if err != nil {
+		t.Fatalf("error analysing packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	var names []string
+	for _, msg := range model.Messages {
+		names = append(names, msg.Name)
+	}
+	assert.Equal([]string{"Event", "GetUserRequest", "GetUserResponse", "ListEventsRequest", "ListRequest", "ListUsersResponse", "User"}, names)
+	var skipped []string
+	for _, item := range model.Skipped {
+		assert.True(item.Warning)
+		skipped = append(skipped, item.Field+": "+item.Reason)
+	}
+	assert.Equal([]string{
+		"Users: result type []*github.com/beam-cloud/go2proto/pkg/generator/testdata/service.User is not a struct, mark the method with @go2proto stream to stream its elements",
+		"Close: method signature func() error is not func([context.Context,] Request) (Response[, error])",
+		"Rename: parameter type string is not a struct",
+	}, skipped)
+	assert.Empty(model.Diagnostics)
+
+	var buf bytes.Buffer
+	assert.NoError(Generate(&buf, model, &Options{GoPackage: "service", ProtoPackage: "service"}))
+	assert.Contains(buf.String(), `
+service UserService {
+  rpc GetUser(GetUserRequest) returns (GetUserResponse) {
+    option idempotency_level = NO_SIDE_EFFECTS;
+  }
+  rpc ListUsers(ListRequest) returns (ListUsersResponse) {
+    option idempotency_level = IDEMPOTENT;
+  }
+  rpc ListEvents(ListEventsRequest) returns (stream Event);
+}
+`)
+	assert.NotContains(buf.String(), "message UserService")
+	file, err := parseProto(buf.String())
+	if assert.NoError(err) && assert.Len(file.Services, 1) {
+		assert.Equal(&protoOption{Name: "idempotency_level", Value: "NO_SIDE_EFFECTS"}, file.Services[0].Methods[0].Options[0])
+	}
+
+	model.Services[0].Package = "acme.rpc"
+	files := Files(model, &Options{Output: "service.proto", GoPackage: "service", ProtoPackage: "service"})
+	if assert.Len(files, 2) {
+		assert.Equal("acme.rpc.proto", files[0].Path)
+		assert.Equal([]string{"service.proto"}, files[0].Imports)
+		assert.Empty(files[1].Services)
+		buf.Reset()
+		_, err = files[0].WriteTo(&buf)
+		assert.NoError(err)
+		assert.Contains(buf.String(), "  rpc GetUser(service.GetUserRequest) returns (service.GetUserResponse) {\n")
+	}
+}
+
+func TestWriteGraph(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/service", "./testdata/second"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, &Options{})
+	if err != nil {
+		t.Fatalf("error analysing packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	assert.NoError(WriteGraph(&buf, model))
+	graph := strings.ReplaceAll(buf.String(), "github.com/beam-cloud/go2proto/pkg/generator/testdata/", "")
+	assert.True(strings.HasPrefix(graph, "digraph go2proto {\n"))
+	assert.Contains(graph, `  "service.ListUsersRequest" [label="ListRequest", shape=box];`)
+	assert.Contains(graph, `  "second.AccountStatus" [label="AccountStatus", shape=ellipse];`)
+	assert.Contains(graph, `  "service.UserService" [label="UserService", shape=component];`)
+	assert.Contains(graph, `  "second.Account" -> "second.AccountStatus" [label="status"];`)
+	assert.Contains(graph, `  "service.ListUsersResponse" -> "service.User" [label="users[]"];`)
+	assert.Contains(graph, `  "service.UserService" -> "service.Event" [label="ListEvents response"];`)
+	assert.NotContains(graph, `"second.Account" -> "double"`, "scalar fields are not edges")
+	assert.True(strings.HasSuffix(graph, "}\n"))
+}
+
+func TestParseAnnotation(t *testing.T) {
+	assert := assert.New(t)
+	doc := func(text string) *ast.CommentGroup {
+		return &ast.CommentGroup{List: []*ast.Comment{{Text: text}}}
+	}
+
+	_, ok := parseAnnotation(doc("// just a comment"))
+	assert.False(ok)
+
+	ann, ok := parseAnnotation(doc("// @go2proto"))
+	assert.True(ok)
+	assert.Equal(annotation{}, ann)
+
+	ann, ok = parseAnnotation(doc(`// @go2proto(name=UserV2, package="acme.users.v1")`))
+	assert.True(ok)
+	assert.Equal(annotation{Name: "UserV2", Package: "acme.users.v1"}, ann)
+
+	ann, ok = parseAnnotation(doc(`// @go2proto name=UserProfileV2 package="acme.users.v2"`))
+	assert.True(ok)
+	assert.Equal(annotation{Name: "UserProfileV2", Package: "acme.users.v2"}, ann)
+
+	ann, ok = parseAnnotation(doc("// @go2proto name=UserProfileV2 until clients migrate, see go/x=y"))
+	assert.True(ok)
+	assert.Equal(annotation{Name: "UserProfileV2"}, ann, "arguments end at the first word that isn't one")
+
+	ann, ok = parseAnnotation(doc("// @go2proto deprecated name=UserProfileV2"))
+	assert.True(ok)
+	assert.Equal(annotation{Name: "UserProfileV2", Deprecated: true}, ann)
+
+	ann, ok = parseAnnotation(doc("// @go2proto idempotency=no-side-effects"))
+	assert.True(ok)
+	assert.Equal(annotation{Idempotency: "NO_SIDE_EFFECTS"}, ann)
+
+	ann, ok = parseAnnotation(doc("// @go2proto idempotency=safe"))
+	assert.True(ok)
+	assert.Equal(annotation{Problems: []annotationProblem{{Reason: `invalid @go2proto idempotency value "safe", expected no-side-effects or idempotent, ignoring it`}}}, ann,
+		"unknown idempotency levels are ignored")
+
+	ann, ok = parseAnnotation(doc("// @go2proto(deprecated=false)"))
+	assert.True(ok)
+	assert.Equal(annotation{}, ann)
+
+	ann, ok = parseAnnotation(doc("// @go2proto marks this type for generation"))
+	assert.True(ok)
+	assert.Equal(annotation{}, ann)
+
+	ann, ok = parseAnnotation(doc(`// @go2proto option=(acme.resource).type="users" option=(acme.resource).title="Team users" keeps them`))
+	assert.True(ok)
+	assert.Equal(annotation{Options: []string{`(acme.resource).type="users"`, `(acme.resource).title="Team users"`}}, ann)
+
+	ann, ok = parseAnnotation(doc(`// @go2proto(name=Team, option=(acme.resource).pattern="teams/{team}, orgs/(org)", oneof=owner:User,Group)`))
+	assert.True(ok)
+	assert.Equal(annotation{Name: "Team", Options: []string{`(acme.resource).pattern="teams/{team}, orgs/(org)"`},
+		Oneofs: []oneofGroup{{Name: "owner", Fields: []string{"User", "Group"}}}}, ann)
+
+	ann, ok = parseAnnotation(doc(`// @go2proto option=deprecated`))
+	assert.True(ok)
+	assert.Equal(annotation{Problems: []annotationProblem{{Reason: `invalid @go2proto option "deprecated", expected name=value, ignoring it`}}}, ann,
+		"options without a value are ignored")
+}
+
+func TestSkippedReport(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"../../example/in", "./testdata/second"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, &Options{Filters: []string{"Account"}})
+	if err != nil {
+		t.Fatalf("error analysing packages: %s", err)
+	}
+	skipped := model.Skipped
+
+	assert := assert.New(t)
+	var reasons []string
+	for _, item := range skipped {
+		reasons = append(reasons, item.Type+"."+item.Field+": "+item.Reason)
+	}
+	assert.Contains(reasons, "Account.note: not exported")
+	assert.Contains(reasons, "EventField.: does not match -filter")
+
+	for _, item := range skipped {
+		if item.Field == "note" {
+			assert.Equal("second.go", filepath.Base(item.File))
+			assert.Equal(8, item.Line)
+		}
+	}
+}
+
+func TestSkipRuntimeFields(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/runtime"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, &Options{})
+	if err != nil {
+		t.Fatalf("error analysing packages: %s", err)
+	}
+	msgs, skipped := model.Messages, model.Skipped
+
+	assert := assert.New(t)
+	assert.Len(msgs, 1)
+	var names []string
+	for _, fd := range msgs[0].Fields {
+		names = append(names, fd.Name)
+	}
+	assert.Equal([]string{"name", "retries"}, names)
+
+	var warned []string
+	for _, item := range skipped {
+		assert.True(item.Warning)
+		assert.NotZero(item.Line)
+		warned = append(warned, item.Field)
+	}
+	assert.Equal([]string{"Jobs", "OnDone", "Handlers", "Raw"}, warned)
+}
+
+func TestMergeManualSections(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/second"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, &Options{})
+	if err != nil {
+		t.Fatalf("error analysing packages: %s", err)
+	}
+	msgs := model.Messages
+
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "second.proto")
+	files := planOutputs(msgs, nil, path, "second", "second", nil)
+	for _, f := range files {
+		f.Merge = true
+	}
+	assert.NoError(WriteFiles(files))
+
+	generated, err := ioutil.ReadFile(path)
+	assert.NoError(err)
+	edited := strings.Replace(string(generated), "package second;\n",
+		"package second;\n"+ManualBegin+"\nimport \"acme/options.proto\";\n"+ManualEnd+"\n", 1)
+	edited += ManualBegin + "\nservice Accounts {\n  rpc Get(Account) returns (Account);\n}\n" + ManualEnd + "\n"
+	assert.NoError(ioutil.WriteFile(path, []byte(edited), 0644))
+
+	// The first merge may normalize blank lines around the sections; after that it is stable.
+	assert.NoError(WriteFiles(files))
+	diff, err := CheckFiles(files)
+	assert.NoError(err)
+	assert.Empty(diff, "manual sections must not count as drift")
+
+	// Regenerating with a changed model keeps the sections in place.
+	msgs[0].Fields = msgs[0].Fields[:1]
+	assert.NoError(WriteFiles(planOutputsMerged(msgs, path)))
+	merged, err := ioutil.ReadFile(path)
+	assert.NoError(err)
+	assert.Contains(string(merged), "package second;\n"+ManualBegin+"\nimport \"acme/options.proto\";\n"+ManualEnd+"\n")
+	assert.Contains(string(merged), "}\n"+ManualBegin+"\nservice Accounts {")
+	assert.NotContains(string(merged), "balance")
+
+	_, err = extractManualSections(ManualBegin + "\nunterminated\n")
+	assert.Error(err)
+}
+
+func planOutputsMerged(msgs []*Message, path string) []*File {
+	files := planOutputs(msgs, nil, path, "second", "second", nil)
+	for _, f := range files {
+		f.Merge = true
+	}
+	return files
+}
+
+func TestParseProto(t *testing.T) {
+	src := `// Code generated by hand.
+syntax = "proto3";
+
+package acme.users.v1; // trailing comment
+import public "google/protobuf/timestamp.proto";
+option go_package = "acme/users";
+option (acme.file).owner = { team: "core" };
+
+// User is a user.
+message User {
+  reserved 4, 8 to 10;
+  reserved "legacy";
+  string id = 1 [deprecated = true, json_name = "ID"];
+  repeated string tags = 2;
+  map<string, int64> counts = 3;
+  oneof contact {
+    string email = 5;
+    string phone = 6;
+  }
+  message Nested {
+    int32 x = 1;
+  }
+}
+
+/* block */
+enum Status {
+  STATUS_UNSPECIFIED = 0;
+  STATUS_ACTIVE = 1;
+}
+
+service Users {
+  rpc Get(User) returns (stream User);
+  rpc Watch(stream User) returns (User) {
+    option idempotency_level = NO_SIDE_EFFECTS;
+  }
+}
+`
+	f, err := parseProto(src)
+	if err != nil {
+		t.Fatalf("error parsing: %s", err)
+	}
+
+	assert := assert.New(t)
+	assert.Equal("proto3", f.Syntax)
+	assert.Equal("acme.users.v1", f.Package)
+	assert.Equal("google/protobuf/timestamp.proto", f.Imports[0].Path)
+	assert.True(f.Imports[0].Public)
+	assert.Len(f.Options, 2)
+	assert.Equal(`"acme/users"`, f.Options[0].Value)
+
+	user := f.message("User")
+	assert.NotNil(user)
+	assert.True(strings.HasPrefix(src[user.Span.Start:user.Span.End], "// User is a user.\nmessage User {"))
+	assert.True(strings.HasSuffix(src[user.Span.Start:user.Span.End], "  }\n}"))
+	assert.True(user.Reserved.hasNumber(9))
+	assert.True(user.Reserved.hasName("legacy"))
+	assert.Len(user.Fields, 5)
+	assert.Equal("deprecated", user.Fields[0].Options[0].Name)
+	assert.Equal("repeated", user.Fields[1].Label)
+	assert.Equal("int64", user.Fields[2].ValueType)
+	assert.Equal("contact", user.Fields[4].Oneof)
+	assert.Equal("Nested", user.Messages[0].Name)
+
+	assert.Equal("Status", f.Enums[0].Name)
+	assert.Equal(1, f.Enums[0].Values[1].Number)
+	assert.True(strings.HasPrefix(src[f.Enums[0].Span.Start:], "/* block */"))
+
+	assert.True(f.Services[0].Methods[0].ServerStreaming)
+	assert.True(f.Services[0].Methods[1].ClientStreaming)
+
+	_, err = parseProto("message Broken {\n  string id = ;\n}\n")
+	assert.EqualError(err, `2:15: expected number, found ";"`)
+}
+
+func TestAppendToFile(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/second"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, &Options{})
+	if err != nil {
+		t.Fatalf("error analysing packages: %s", err)
+	}
+	msgs := model.Messages
+	files := planOutputs(msgs, nil, "second.proto", "second", "second", nil)
+
+	existing := `syntax = "proto3";
+
+package second;
+
+message Other {
+  string keep = 1;
+}
+
+message Account {
+  string stale = 1;
+}
+
+service Accounts {
+  rpc Get(Account) returns (Account);
+}
+`
+	assert := assert.New(t)
+	out, err := appendToFile(existing, files[0])
+	assert.NoError(err)
+	assert.Contains(out, "package second;\nimport \"google/protobuf/timestamp.proto\";\n")
+	assert.Contains(out, "message Other {\n  string keep = 1;\n}")
+	assert.Contains(out, "message Account {\n  string id = 1;\n  double balance = 2;")
+	assert.NotContains(out, "stale")
+	assert.Contains(out, "service Accounts {")
+
+	// Appending again is a no-op.
+	again, err := appendToFile(out, files[0])
+	assert.NoError(err)
+	assert.Equal(out, again)
+}
+
+func TestBreakingChanges(t *testing.T) {
+	previous, err := parseProto(`syntax = "proto3";
+package acme;
+message User {
+  string id = 1;
+  int64 age = 2;
+  string nickname = 3;
+  repeated string tags = 4;
+  acme.Team team = 5;
+  string legacy = 6;
+}
+message Gone {
+  string id = 1;
+}
+`)
+	if err != nil {
+		t.Fatalf("error parsing: %s", err)
+	}
+	current, err := parseProto(`syntax = "proto3";
+package acme;
+message User {
+  reserved 6;
+  string id = 1;
+  string age = 2;
+  string tags = 4;
+  Team team = 5;
+}
+`)
+	if err != nil {
+		t.Fatalf("error parsing: %s", err)
+	}
+
+	var messages []string
+	for _, c := range breakingChanges(previous, current) {
+		messages = append(messages, c.Message)
+	}
+	assert.Equal(t, []string{
+		"User: field 2 changed type from int64 to string",
+		"User: field 3 (nickname) was removed without reserving its number",
+		`User: field 4 changed label from "repeated" to ""`,
+		"message Gone was removed",
+	}, messages)
+}
+
+func TestHashNumbering(t *testing.T) {
+	assert := assert.New(t)
+	msg := func(names ...string) *Message {
+		m := &Message{Name: "User"}
+		for i, name := range names {
+			m.Fields = append(m.Fields, &Field{Name: name, TypeName: "string", Order: i + 1})
+		}
+		return m
+	}
+	numbers := func(m *Message) map[string]int {
+		result := make(map[string]int)
+		for _, fd := range m.Fields {
+			assert.True(fd.Order >= 1 && fd.Order <= maxFieldNumber)
+			result[fd.Name] = fd.Order
+		}
+		return result
+	}
+
+	first, reordered := msg("id", "name", "email"), msg("email", "id", "name")
+	assignHashNumbers(first, nil)
+	assignHashNumbers(reordered, nil)
+	assert.Equal(numbers(first), numbers(reordered), "reordering must not change field numbers")
+	assert.Equal(hashFieldNumber("id"), numbers(first)["id"])
+
+	// A field kept on another's hash pushes that one to the next free number.
+	collided := msg("id", "name")
+	assignHashNumbers(collided, map[string]int{"name": hashFieldNumber("id")})
+	assert.Equal(nextFieldNumber(hashFieldNumber("id")), collided.Fields[0].Order)
+	assert.Equal(fmt.Sprintf("%s from %d", probedMarker, hashFieldNumber("id")), collided.Fields[0].NumberNote)
+
+	// The probe is recorded in the output, so regenerating keeps it.
+	path := filepath.Join(t.TempDir(), "user.proto")
+	f := &File{Path: path, GoPackage: "users", ProtoPackage: "users", Messages: []*Message{collided}}
+	assert.NoError(WriteFiles([]*File{f}))
+	regenerated := msg("name", "id")
+	f.Messages = []*Message{regenerated}
+	applyHashNumbering(f, &Options{})
+	assert.Equal(numbers(collided), numbers(regenerated))
+
+	assert.Equal(lastReservedFieldNum+1, nextFieldNumber(firstReservedFieldNum-1))
+	assert.Equal(1, nextFieldNumber(maxFieldNumber))
+}
+
+func TestDuplicateNames(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/second", "./testdata/dup"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	_, err = Analyze(pkgs, &Options{})
+	var errs ErrorList
+	assert.ErrorAs(err, &errs)
+	assert.Equal(2, errs.Errors(), "both declarations are reported")
+	assert.Contains(err.Error(), "4:6: Account: error: message Account is also declared by github.com/beam-cloud/go2proto/pkg/generator/testdata/dup.Account")
+	assert.Contains(err.Error(), "5:6: Account: error: message Account is also declared by github.com/beam-cloud/go2proto/pkg/generator/testdata/second.Account")
+
+	model, err := Analyze(pkgs, &Options{Duplicates: DuplicatesPrefix})
+	assert.NoError(err)
+	var names []string
+	for _, msg := range model.Messages {
+		names = append(names, msg.Name)
+	}
+	assert.Equal([]string{"DupAccount", "Ledger", "SecondAccount"}, names)
+
+	files := Files(model, &Options{Output: "dup.proto", GoPackage: "dup", ProtoPackage: "dup"})
+	var buf bytes.Buffer
+	_, err = files[0].WriteTo(&buf)
+	assert.NoError(err)
+	assert.Contains(buf.String(), "  repeated DupAccount accounts = 1;")
+}
+
+func TestOutputs(t *testing.T) {
+	assert := assert.New(t)
+	newModel := func(teamPackage string) *Model {
+		return &Model{Messages: []*Message{
+			{Name: "Team", GoName: "Team", PkgPath: "example.com/teams", Package: teamPackage, Fields: []*Field{
+				{Name: "owner", TypeName: "User", Order: 1, NamedType: "example.com/users.User"},
+			}},
+			{Name: "User", GoName: "User", PkgPath: "example.com/users", Fields: []*Field{
+				{Name: "id", TypeName: "string", Order: 1},
+			}},
+		}}
+	}
+
+	dir := t.TempDir()
+	opts := &Options{
+		Output:       filepath.Join(dir, "api", "api.proto"),
+		ProtoPackage: "acme",
+		Outputs: map[string]string{
+			"example.com/teams": filepath.Join(dir, "api", "teams.proto"),
+			"example.com/users": filepath.Join(dir, "api", "users", "users.proto"),
+		},
+	}
+	files := Files(newModel(""), opts)
+	if assert.Len(files, 2) {
+		assert.Equal(opts.Outputs["example.com/teams"], files[0].Path)
+		assert.Equal([]string{"users/users.proto"}, files[0].Imports)
+		assert.Equal("User", files[0].Messages[0].Fields[0].TypeName)
+		assert.Equal(opts.Outputs["example.com/users"], files[1].Path)
+		assert.Equal("acme", files[1].ProtoPackage)
+		assert.NoError(WriteFiles(files))
+		out, err := ioutil.ReadFile(files[0].Path)
+		assert.NoError(err)
+		assert.Contains(string(out), "import \"users/users.proto\";")
+		assert.Contains(string(out), "  User owner = 1;")
+	}
+
+	opts.Outputs["example.com/teams"] = opts.Outputs["example.com/users"]
+	_, err := RefreshFiles(Files(newModel("acme.teams"), opts))
+	assert.EqualError(err, opts.Outputs["example.com/users"]+" would hold the messages of proto packages acme and acme.teams, map them to different files")
+
+	// Mapped paths are cleaned, so differently spelled paths of a file share it.
+	opts.Outputs["example.com/teams"] = dir + "/api/./users//users.proto"
+	files = Files(newModel(""), opts)
+	if assert.Len(files, 1) {
+		assert.Equal(opts.Outputs["example.com/users"], files[0].Path)
+		assert.Empty(files[0].Imports)
+	}
+}
+
+func TestLineEndings(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/second"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, &Options{})
+	if err != nil {
+		t.Fatalf("error analysing packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "second.proto")
+	opts := &Options{Output: path, GoPackage: "second", ProtoPackage: "second", LineEnding: LineEndingCRLF, Merge: true}
+	assert.NoError(WriteFiles(Files(model, opts)))
+	out, err := ioutil.ReadFile(path)
+	assert.NoError(err)
+	assert.Contains(string(out), "syntax = \"proto3\";\r\n")
+	assert.Equal(strings.Count(string(out), "\n"), strings.Count(string(out), "\r\n"))
+
+	// Manual sections of files with \r\n line endings are kept.
+	edited := string(out) + ManualBegin + "\r\nservice Accounts {\r\n  rpc Get(Account) returns (Account);\r\n}\r\n" + ManualEnd + "\r\n"
+	assert.NoError(ioutil.WriteFile(path, []byte(edited), 0644))
+	assert.NoError(WriteFiles(Files(model, opts)))
+	diff, err := CheckFiles(Files(model, opts))
+	assert.NoError(err)
+	assert.Empty(diff)
+	out, err = ioutil.ReadFile(path)
+	assert.NoError(err)
+	assert.Contains(string(out), "  rpc Get(Account) returns (Account);\r\n")
+	assert.NotContains(string(out), "\r\r")
+
+	opts.LineEnding = LineEndingLF
+	assert.NoError(WriteFiles(Files(model, opts)))
+	out, err = ioutil.ReadFile(path)
+	assert.NoError(err)
+	assert.NotContains(string(out), "\r")
+	assert.Contains(string(out), "service Accounts {\n")
+}
+
+func TestPublicImports(t *testing.T) {
+	assert := assert.New(t)
+	msgs := []*Message{
+		{Name: "Team", GoName: "Team", PkgPath: "example.com/teams", Fields: []*Field{
+			{Name: "owner", TypeName: "User", Order: 1, NamedType: "example.com/users.User"},
+		}},
+		{Name: "User", GoName: "User", PkgPath: "example.com/users", Package: "acme.users", Fields: []*Field{
+			{Name: "id", TypeName: "string", Order: 1},
+		}},
+	}
+
+	dir := t.TempDir()
+	opts := &Options{Output: filepath.Join(dir, "acme.proto"), ProtoPackage: "acme", PublicImports: []string{"acme.users.proto", "extra.proto"}}
+	files := Files(&Model{Messages: msgs}, opts)
+	if assert.Len(files, 2) {
+		assert.Empty(files[0].Imports)
+		assert.Equal([]string{"acme.users.proto", "extra.proto"}, files[0].PublicImports)
+		var out strings.Builder
+		_, err := files[0].WriteTo(&out)
+		assert.NoError(err)
+		assert.Contains(out.String(), "\nimport public \"acme.users.proto\";\nimport public \"extra.proto\";\n")
+		assert.Contains(out.String(), "  acme.users.User owner = 1;")
+	}
+
+	// An umbrella file holding no message is written for its imports alone.
+	opts.Output = filepath.Join(dir, "all.proto")
+	opts.Outputs = map[string]string{"example.com/teams": filepath.Join(dir, "teams.proto")}
+	files = Files(&Model{Messages: msgs}, opts)
+	if assert.Len(files, 3) {
+		assert.Equal(opts.Output, files[1].Path)
+		assert.Empty(files[1].Messages)
+		assert.Equal([]string{"acme.users.proto", "extra.proto"}, files[1].PublicImports)
+	}
+}
+
+func TestExtraImports(t *testing.T) {
+	assert := assert.New(t)
+	msgs := []*Message{
+		{Name: "Team", GoName: "Team", PkgPath: "example.com/teams", Fields: []*Field{
+			{Name: "owner", TypeName: "User", Order: 1, NamedType: "example.com/users.User"},
+		}},
+		{Name: "User", GoName: "User", PkgPath: "example.com/users", Package: "acme.users", Fields: []*Field{
+			{Name: "id", TypeName: "string", Order: 1},
+		}},
+	}
+
+	dir := t.TempDir()
+	opts := &Options{Output: filepath.Join(dir, "acme.proto"), ProtoPackage: "acme", Imports: map[string][]string{
+		"":                                  {"acme/options.proto", "google/protobuf/timestamp.proto"},
+		dir + "/./acme.proto":               {"acme.users.proto", "acme/resource.proto"},
+		filepath.Join(dir, "missing.proto"): {"unused.proto"},
+	}}
+	files := Files(&Model{Messages: msgs}, opts)
+	if assert.Len(files, 2) {
+		assert.Equal([]string{"acme.users.proto", "acme/options.proto", "acme/resource.proto"}, files[0].Imports)
+		assert.Equal([]string{"acme/options.proto"}, files[1].Imports)
+		var out strings.Builder
+		_, err := files[0].WriteTo(&out)
+		assert.NoError(err)
+		assert.Equal(1, strings.Count(out.String(), "import \"acme.users.proto\";"))
+	}
+}
+
+func TestOptimizeFor(t *testing.T) {
+	assert := assert.New(t)
+	msgs := []*Message{{Name: "User", GoName: "User", Fields: []*Field{{Name: "id", TypeName: "string", Order: 1}}}}
+	var out strings.Builder
+	assert.NoError(Generate(&out, &Model{Messages: msgs}, &Options{Output: "users.proto", GoPackage: "users", ProtoPackage: "users", OptimizeFor: OptimizeLiteRuntime}))
+	assert.Contains(out.String(), "option go_package = \"users\";\noption optimize_for = LITE_RUNTIME;\n")
+
+	out.Reset()
+	assert.NoError(Generate(&out, &Model{Messages: msgs}, &Options{Output: "users.proto", GoPackage: "users", ProtoPackage: "users"}))
+	assert.NotContains(out.String(), "optimize_for")
+}
+
+func TestNamingOriginal(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/annotated"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	model, err := Analyze(pkgs, &Options{FieldNaming: NamingOriginal})
+	assert.NoError(err)
+	var names []string
+	for _, msg := range model.Messages {
+		for _, fd := range msg.Fields {
+			names = append(names, msg.Name+"."+fd.Name)
+		}
+	}
+	assert.Equal([]string{"TeamV2.Owner", "TeamV2.Members", "UserV2.ID", "UserV2.Tags", "UserV2.Role"}, names)
+}
+
+// upperNaming is a Naming upper-casing every name.
+type upperNaming struct{}
+
+func (upperNaming) MessageName(goName string) string { return strings.ToUpper(goName) }
+func (upperNaming) FieldName(goName string) string   { return strings.ToUpper(goName) }
+func (upperNaming) EnumName(goName string) string    { return strings.ToUpper(goName) }
+func (upperNaming) EnumValue(value string) string    { return strings.ToUpper(value) }
+
+func TestNaming(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/annotated", "./testdata/second"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	naming, err := NewNaming("Api{{.}}", NamingLowerCamel, "{{.}}Enum", "screamingSnake")
+	assert.NoError(err)
+	model, err := Analyze(pkgs, &Options{Naming: naming})
+	assert.NoError(err)
+	var names []string
+	for _, msg := range model.Messages {
+		for _, fd := range msg.Fields {
+			names = append(names, msg.Name+"."+fd.Name)
+		}
+	}
+	// Names set in annotations are kept.
+	assert.Equal([]string{"ApiAccount.id", "ApiAccount.balance", "ApiAccount.status", "TeamV2.owner", "TeamV2.members", "UserV2.id", "UserV2.tags", "UserV2.role"}, names)
+	if assert.Len(model.Enums, 2) {
+		assert.Equal("AccountStatusEnum", model.Enums[0].Name)
+		assert.Equal([]string{"OPEN", "CLOSED"}, model.Enums[0].Values)
+	}
+
+	cacheDir := t.TempDir()
+	model, err = Analyze(pkgs, &Options{Naming: upperNaming{}, CacheDir: cacheDir})
+	assert.NoError(err)
+	assert.Equal("ACCOUNT", model.Messages[0].Name)
+	assert.Equal("BALANCE", model.Messages[0].Fields[1].Name)
+	entries, err := os.ReadDir(cacheDir)
+	assert.NoError(err)
+	assert.Empty(entries, "analyses of custom namings must not be cached")
+
+	_, err = NewNaming("{{", "", "", "")
+	assert.ErrorContains(err, "invalid message naming: ")
+	_, err = NewNaming("", "{{nope .}}", "", "")
+	assert.ErrorContains(err, "invalid field naming: ")
+}
+
+func TestProtoPackages(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/second", "./testdata/dup", "./testdata/annotated"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	opts := &Options{ProtoPackage: "acme", ProtoPackages: make(map[string]string)}
+	for _, p := range pkgs {
+		opts.ProtoPackages[p.PkgPath] = map[string]string{"second": "acme.second", "dup": "acme.dup", "annotated": "acme.teams"}[p.Name]
+	}
+	model, err := Analyze(pkgs, opts)
+	assert.NoError(err)
+	packages := make(map[string]string)
+	for _, msg := range model.Messages {
+		packages[filepath.Base(msg.PkgPath)+"."+msg.Name] = msg.Package
+	}
+	// The annotation of UserV2 takes precedence.
+	assert.Equal(map[string]string{
+		"second.Account": "acme.second", "dup.Account": "acme.dup", "dup.Ledger": "acme.dup",
+		"annotated.TeamV2": "acme.teams", "annotated.UserV2": "acme.users.v1",
+	}, packages)
+
+	dir := t.TempDir()
+	opts.Output = filepath.Join(dir, "acme.proto")
+	var out strings.Builder
+	for _, f := range Files(model, opts) {
+		out.WriteString(f.ProtoPackage + ": " + filepath.Base(f.Path) + "\n")
+		_, err := f.WriteTo(&out)
+		assert.NoError(err)
+	}
+	assert.Contains(out.String(), "acme.teams: acme.teams.proto\n")
+	assert.Contains(out.String(), "  acme.users.v1.UserV2 owner = 1;")
+	assert.Contains(out.String(), "  repeated Account accounts = 1;")
+	assert.Contains(out.String(), "package acme.second;")
+}
+
+func TestMessageAffixes(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/second", "./testdata/dup", "./testdata/annotated"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	model, err := Analyze(pkgs, &Options{Duplicates: DuplicatesPrefix, MessagePrefix: "Api", MessageSuffix: "Pb"})
+	assert.NoError(err)
+	var names []string
+	for _, msg := range model.Messages {
+		names = append(names, msg.Name)
+	}
+	assert.Equal([]string{"ApiDupAccountPb", "ApiLedgerPb", "ApiSecondAccountPb", "ApiTeamV2Pb", "ApiUserV2Pb"}, names)
+
+	path := filepath.Join(t.TempDir(), "api.proto")
+	files := Files(model, &Options{Output: path, GoPackage: "api", ProtoPackage: "api"})
+	var out strings.Builder
+	for _, f := range files {
+		_, err := f.WriteTo(&out)
+		assert.NoError(err)
+	}
+	assert.Contains(out.String(), "  repeated ApiDupAccountPb accounts = 1;")
+	assert.Contains(out.String(), "  acme.users.v1.ApiUserV2Pb owner = 1;")
+}
+
+func TestHooks(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/annotated"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	var calls []string
+	opts := &Options{Hooks: []Hook{
+		func(model *Model) error {
+			calls = append(calls, "first")
+			for _, msg := range model.Messages {
+				if msg.GoName == "User" {
+					msg.Name = "Account"
+					msg.Options = append(msg.Options, `(acme.resource).type = "users"`)
+					for _, fd := range msg.Fields {
+						fd.Name = strings.ToUpper(fd.Name)
+					}
+				}
+			}
+			return nil
+		},
+		func(model *Model) error {
+			calls = append(calls, "second")
+			return nil
+		},
+	}}
+	model, err := Analyze(pkgs, opts)
+	assert.NoError(err)
+	assert.Equal([]string{"first", "second"}, calls)
+
+	path := filepath.Join(t.TempDir(), "api.proto")
+	var out strings.Builder
+	for _, f := range Files(model, &Options{Output: path, ProtoPackage: "api"}) {
+		_, err := f.WriteTo(&out)
+		assert.NoError(err)
+	}
+	assert.Contains(out.String(), "message Account {\n  option (acme.resource).type = \"users\";\n  string ID = 1;")
+	assert.Contains(out.String(), "  acme.users.v1.Account owner = 1;")
+
+	dropped, err := Analyze(pkgs, &Options{Hooks: []Hook{func(model *Model) error {
+		model.Messages = model.Messages[:1]
+		return nil
+	}}})
+	assert.NoError(err)
+	if assert.Len(dropped.Messages, 1) && assert.Len(dropped.Diagnostics, 2) {
+		assert.Equal(CodeUnmappedType, dropped.Diagnostics[0].Code)
+	}
+
+	_, err = Analyze(pkgs, &Options{Hooks: []Hook{func(*Model) error { return errors.New("no owner") }}})
+	assert.EqualError(err, "hook 1: no owner")
+}
+
+func TestEmitter(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/annotated"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, &Options{})
+	if err != nil {
+		t.Fatalf("error analysing packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	names := EmitterFunc(func(model *Model, w io.Writer) error {
+		for _, msg := range model.Messages {
+			fmt.Fprintf(w, "%s %d\n", msg.Name, len(msg.Fields))
+		}
+		for _, e := range model.Enums {
+			fmt.Fprintf(w, "%s %s\n", e.Name, strings.Join(e.Values, ","))
+		}
+		return nil
+	})
+	assert.NoError(RegisterEmitter("names", names))
+	t.Cleanup(func() { delete(emitters, "names") })
+	assert.EqualError(RegisterEmitter("names", names), `format "names" is already registered`)
+	assert.EqualError(RegisterEmitter(FormatOpenAPI, names), `format "openapi" is built in`)
+
+	files := Files(model, &Options{Output: "schema.txt", Format: "names", Alphabetical: true})
+	if assert.Len(files, 1) {
+		var out strings.Builder
+		_, err := files[0].WriteTo(&out)
+		assert.NoError(err)
+		assert.Equal("TeamV2 2\nUserV2 3\nRole admin,member\n", out.String())
+	}
+
+	opts := &Options{Output: "api.proto", ProtoPackage: "api"}
+	var want, got strings.Builder
+	assert.NoError(Generate(&want, model, opts))
+	assert.NoError((&ProtoEmitter{Options: opts}).Emit(model, &got))
+	assert.Equal(want.String(), got.String())
+}
+
+func TestPackageVersion(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("acme.users.v1", VersionedPackage("acme.users", "v1"))
+	assert.Equal("acme.users.v2beta1", VersionedPackage("acme.users.v2beta1", "v1"))
+	assert.Equal("acme.users", VersionedPackage("acme.users", ""))
+	assert.Equal("", VersionedPackage("", "v1"))
+	for _, version := range []string{"v1", "v10", "v1beta1", "v2alpha", "v1test", "v1p1alpha1"} {
+		assert.True(IsPackageVersion(version), version)
+	}
+	for _, version := range []string{"", "1", "v", "V1", "v1.2", "version1"} {
+		assert.False(IsPackageVersion(version), version)
+	}
+
+	model := &Model{Messages: []*Message{
+		{Name: "Team", GoName: "Team", PkgPath: "example.com/teams", Fields: []*Field{
+			{Name: "owner", TypeName: "User", NamedType: "example.com/users.User", Order: 1},
+			{Name: "invoice", TypeName: "Invoice", NamedType: "example.com/billing.Invoice", Order: 2},
+		}},
+		{Name: "User", GoName: "User", PkgPath: "example.com/users", Package: "acme.users"},
+		{Name: "Invoice", GoName: "Invoice", PkgPath: "example.com/billing", Package: "acme.billing.v2"},
+	}}
+	dir := t.TempDir()
+	opts := &Options{Output: filepath.Join(dir, "teams.proto"), GoPackage: "teams", ProtoPackage: "acme.teams", PackageVersion: "v1"}
+	var paths []string
+	var out strings.Builder
+	for _, f := range Files(model, opts) {
+		paths = append(paths, filepath.Base(f.Path))
+		_, err := f.WriteTo(&out)
+		assert.NoError(err)
+	}
+	assert.Equal([]string{"acme.billing.v2.proto", "acme.users.v1.proto", "teams.proto"}, paths)
+	assert.Contains(out.String(), "package acme.teams.v1;")
+	assert.Contains(out.String(), "  acme.users.v1.User owner = 1;")
+	assert.Contains(out.String(), "  acme.billing.v2.Invoice invoice = 2;")
+
+	var buf bytes.Buffer
+	assert.NoError(Generate(&buf, model, opts))
+	assert.Contains(buf.String(), "message Team {")
+}
+
+func TestEscapeIdentifiers(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/keywords"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, &Options{})
+	if err != nil {
+		t.Fatalf("error analysing packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	assert.Equal("_1Password", model.Messages[0].Name)
+	var names []string
+	for _, fd := range model.Messages[0].Fields {
+		names = append(names, fd.Name)
+	}
+	assert.Equal([]string{"message_", "option_", "name"}, names)
+
+	assert.Len(model.Diagnostics, 3)
+	assert.Equal(5, model.Diagnostics[0].Line)
+	assert.Contains(model.Diagnostics[0].String(), `Vault.Message: field name "message" is not a valid proto identifier, emitting it as "message_"`)
+
+	_, err = parseProto(renderTestFile(t, model))
+	assert.NoError(err)
+}
+
+func TestInvalidMapKeys(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/mapkeys"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	_, err = Analyze(pkgs, &Options{})
+	var errs ErrorList
+	if assert.ErrorAs(err, &errs) && assert.Len(errs, 4) {
+		for _, d := range errs {
+			assert.Equal(CodeInvalidMapKey, d.Code)
+		}
+		assert.Equal(10, errs[0].Line)
+		assert.Contains(errs[0].String(), "Chart.Weights: map key type float64 can't key a proto map, whose keys are integers, bools or strings: "+
+			"format the keys as strings, or use a repeated entry message with a float64 key field and a value field instead")
+		assert.Contains(errs[1].String(), "Chart.Cells: map key type mapkeys.Point can't key a proto map")
+		assert.Contains(errs[1].String(), "use a repeated entry message with the key's fields and a value field instead")
+		assert.Contains(errs[2].String(), "Chart.Extra: map key type any can't key a proto map, whose keys are integers, bools or strings: key the map by a concrete integer or string type")
+		assert.Contains(errs[3].String(), "Chart.Handles: map key type uintptr can't key a proto map", "uintptr is an integer without a proto type")
+	}
+}
+
+func TestRecursiveTypes(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/recursive"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	tree := pkgs[0].Types.Scope().Lookup("Tree").Type().Underlying().(*types.Struct)
+	for i := 0; i < tree.NumFields(); i++ {
+		assert.Empty(unsupportedFieldType(tree.Field(i).Type()), "types holding themselves end the unwrapping")
+		assert.Empty(invalidMapKey(tree.Field(i).Type()))
+		assert.Empty(mapField(tree.Field(i).Type(), &Field{}))
+		kind, _ := dynamicType(tree.Field(i).Type())
+		assert.Empty(kind)
+	}
+
+	model, _, err := LoadAndAnalyze(&Options{Patterns: []string{"./testdata/recursive"}})
+	if !assert.NoError(err) {
+		return
+	}
+	if assert.Len(model.Diagnostics, 2) {
+		assert.Contains(model.Diagnostics[0].String(), "Tree.Kids: type github.com/beam-cloud/go2proto/pkg/generator/testdata/recursive.L is not annotated")
+		assert.Contains(model.Diagnostics[1].String(), "Tree.Next: type github.com/beam-cloud/go2proto/pkg/generator/testdata/recursive.P is not annotated")
+	}
+}
+
+func TestMaps(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/maps"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	model, err := Analyze(pkgs, &Options{})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Empty(model.Diagnostics)
+	out := renderTestFile(t, model)
+	assert.Contains(out, `message Chart {
+  map<string, string> labels = 1;
+  map<uint32, int32> counts = 2;
+  map<int64, Point> points = 3;
+// possible values: active, paused
+  map<string, string> statuses = 4;
+  map<string, google.protobuf.Timestamp> seen = 5;
+  map<string, bytes> blobs = 6;
+  google.protobuf.Struct meta = 7;
+}`)
+
+	pkgs, err = Load(&Options{Patterns: []string{"./testdata/maps/invalid"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	_, err = Analyze(pkgs, &Options{})
+	var errs ErrorList
+	if assert.ErrorAs(err, &errs) && assert.Len(errs, 4) {
+		for _, d := range errs {
+			assert.Equal(CodeInvalidMapValue, d.Code)
+		}
+		assert.Contains(errs[0].String(), "Chart.Tags: map value type []string can't be a proto map value, as map values can't be repeated: wrap the values in a message")
+		assert.Contains(errs[1].String(), "Chart.Nested: map value type map[string]int can't be a proto map value, as map values can't be maps")
+		assert.Contains(errs[2].String(), "Chart.Series: lists of maps such as map[string]int can't be proto fields")
+		assert.Contains(errs[3].String(), "Chart.Extra: map value type any has no proto representation: key the map by strings")
+	}
+}
+
+func TestNonASCII(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/nonascii"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	_, err = Analyze(pkgs, &Options{})
+	var errs ErrorList
+	if assert.ErrorAs(err, &errs) && assert.Len(errs, 3) {
+		assert.Equal(CodeNonASCII, errs[0].Code)
+		assert.Equal(6, errs[0].Line)
+		assert.Contains(errs[0].String(), `Größe.Länge: field name "länge" holds 'ä' (U+00E4) at byte 1, but proto identifiers are ASCII`)
+		assert.Contains(errs[1].String(), `field name "Ω" holds 'Ω' (U+03A9) at byte 0`)
+		assert.Equal(4, errs[2].Line)
+		assert.Contains(errs[2].String(), `Größe: message name "Größe" holds 'ö' (U+00F6) at byte 2`)
+	}
+
+	model, err := Analyze(pkgs, &Options{NonASCII: NonASCIITransliterate})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("Grosse", model.Messages[0].Name)
+	var names []string
+	for _, fd := range model.Messages[0].Fields {
+		names = append(names, fd.Name)
+	}
+	assert.Equal([]string{"wert", "lange", "_"}, names)
+	assert.Len(model.Diagnostics, 3)
+	assert.Contains(model.Diagnostics[2].String(), `message name "Größe" is not ASCII, emitting it as "Grosse"`)
+
+	_, err = parseProto(renderTestFile(t, model))
+	assert.NoError(err)
+
+	type Straße struct{ Name string }
+	_, err = FromValues(Straße{})
+	assert.ErrorContains(err, `message name "Straße" holds 'ß' (U+00DF) at byte 4`)
+}
+
+// renderTestFile renders the model's default file for parsing.
+func renderTestFile(t *testing.T, model *Model) string {
+	var buf bytes.Buffer
+	if err := Generate(&buf, model, &Options{Output: "test.proto", GoPackage: "test", ProtoPackage: "test"}); err != nil {
+		t.Fatalf("error generating: %s", err)
+	}
+	return buf.String()
+}
+
+func TestVerifyOutput(t *testing.T) {
+	assert := assert.New(t)
+	broken := &File{Path: "broken.proto", GoPackage: "b", ProtoPackage: "b", Messages: []*Message{
+		{Name: "User", Fields: []*Field{{Name: "id", TypeName: "string", Order: 1}, {Name: "full name", TypeName: "string", Order: 2}}},
+	}}
+	var buf bytes.Buffer
+	_, err := broken.WriteTo(&buf)
+	assert.Error(err)
+	assert.Contains(err.Error(), "generated broken.proto is not valid proto: 12:15: expected \"=\", found \"name\"")
+	assert.Contains(err.Error(), ">   12 |   string full name = 2;")
+	assert.Empty(buf.String(), "nothing is written for invalid output")
+
+	err = verifyOutput("syntax = \"proto3\";\nmessage A {\n  string a = 1;\n  string b = 1;\n}\n")
+	assert.EqualError(err, "4: message A: field b reuses number 1 of a\n     2 | message A {\n     3 |   string a = 1;\n>    4 |   string b = 1;\n     5 | }")
+}
+
+func TestMissingReferences(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"../../example/in"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	model, err := Analyze(pkgs, &Options{})
+	assert.NoError(err)
+	assert.Len(model.Diagnostics, 1)
+	d := model.Diagnostics[0]
+	assert.Equal("model.go", filepath.Base(d.File))
+	assert.Equal(11, d.Line)
+	assert.Equal("EventSubForm", d.Type)
+	assert.Equal("User", d.Field)
+	assert.Equal("type github.com/beam-cloud/go2proto/example/in.User is not annotated with @go2proto and has no proto mapping", d.Message)
+
+	_, err = Analyze(pkgs, &Options{Strict: true})
+	assert.Error(err)
+	assert.Contains(err.Error(), "1 error, 0 warnings\n")
+	assert.Contains(err.Error(), "model.go:\n  11:2: EventSubForm.User: error: type ")
+}
+
+func TestBuildTagsAndPlatform(t *testing.T) {
+	assert := assert.New(t)
+	names := func(opts *Options) []string {
+		opts.Patterns = []string{"./testdata/tagged"}
+		pkgs, err := Load(opts)
+		if err != nil {
+			t.Fatalf("error loading packages: %s", err)
+		}
+		model, err := Analyze(pkgs, opts)
+		assert.NoError(err)
+		var result []string
+		for _, msg := range model.Messages {
+			result = append(result, msg.Name)
+		}
+		return result
+	}
+
+	assert.Equal([]string{"Base"}, names(&Options{GOOS: "linux"}))
+	assert.Equal([]string{"Base", "Custom"}, names(&Options{GOOS: "linux", BuildTags: []string{"custom"}}))
+	assert.Equal([]string{"Base", "Plan9Only"}, names(&Options{GOOS: "plan9", GOARCH: "amd64"}))
+}
+
+func TestCgoPackages(t *testing.T) {
+	defer os.Setenv("CC", os.Getenv("CC"))
+	os.Setenv("CC", filepath.Join(t.TempDir(), "missing-cc"))
+
+	assert := assert.New(t)
+	_, err := Load(&Options{Patterns: []string{"./testdata/native"}})
+	assert.Error(err)
+	assert.Contains(err.Error(), "testdata/native:\n  error: package uses cgo (handle.go) and could not be built")
+
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/native"}, DisableCgo: true})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, &Options{})
+	assert.NoError(err)
+	assert.Len(model.Messages, 1)
+	assert.Equal("Config", model.Messages[0].Name)
+}
+
+func TestIncludeTests(t *testing.T) {
+	assert := assert.New(t)
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/withtests"}, IncludeTests: true})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	assert.Len(pkgs, 1)
+
+	model, err := Analyze(pkgs, &Options{})
+	assert.NoError(err)
+	var names []string
+	for _, msg := range model.Messages {
+		names = append(names, msg.Name)
+	}
+	assert.Equal([]string{"Order", "OrderFixture"}, names)
+	assert.Empty(model.Diagnostics, "the fixture's reference to Order resolves")
+
+	pkgs, err = Load(&Options{Patterns: []string{"./testdata/withtests"}})
+	assert.NoError(err)
+	model, err = Analyze(pkgs, &Options{})
+	assert.NoError(err)
+	assert.Len(model.Messages, 1)
+}
+
+// writeModuleProxy lays out a file:// GOPROXY serving a single module version.
+func writeModuleProxy(t *testing.T, module, version string, files map[string]string) string {
+	proxy := t.TempDir()
+	dir := filepath.Join(proxy, module, "@v")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("list", version+"\n")
+	write(version+".info", fmt.Sprintf(`{"Version":%q,"Time":"2024-01-01T00:00:00Z"}`, version))
+	write(version+".mod", files["go.mod"])
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(module + "@" + version + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	write(version+".zip", buf.String())
+	return proxy
+}
+
+func TestRemoteModule(t *testing.T) {
+	assert := assert.New(t)
+	proxy := writeModuleProxy(t, "example.com/acme/types", "v1.4.0", map[string]string{
+		"go.mod": "module example.com/acme/types\n\ngo 1.21\n",
+		"types.go": `package types
+
+// @go2proto
+type Invoice struct {
+	Number string
+	Total  int64
+}
+`,
+	})
+	t.Setenv("GOPROXY", "file://"+filepath.ToSlash(proxy))
+	t.Setenv("GOSUMDB", "off")
+	t.Setenv("GOMODCACHE", t.TempDir())
+	t.Setenv("GOFLAGS", "-modcacherw")
+
+	pkgs, err := Load(&Options{Patterns: []string{"example.com/acme/types@v1.4.0", "./testdata/second"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	var paths []string
+	for _, p := range pkgs {
+		paths = append(paths, p.PkgPath)
+	}
+	assert.Contains(paths, "example.com/acme/types")
+
+	model, err := Analyze(pkgs, &Options{})
+	assert.NoError(err)
+	var names []string
+	for _, msg := range model.Messages {
+		names = append(names, msg.Name)
+	}
+	assert.Contains(names, "Invoice")
+
+	_, err = Load(&Options{Patterns: []string{"example.com/acme/types@v9.9.9"}})
+	assert.ErrorContains(err, "unable to download example.com/acme/types@v9.9.9")
+}
+
+func TestSourceComments(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"../../example/in"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, &Options{})
+	if err != nil {
+		t.Fatalf("error analysing packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "in.proto")
+	opts := &Options{Output: path, GoPackage: "in", ProtoPackage: "in", SourceComments: true, Append: true}
+	for i := 0; i < 2; i++ {
+		assert.NoError(WriteFiles(Files(model, opts)))
+	}
+	out, err := ioutil.ReadFile(path)
+	assert.NoError(err)
+	assert.Contains(string(out), "// source: example/in/model.go:47\nmessage EventFieldItem {\n// source: example/in/model.go:48\n  string event_field_item_id = 1;\n")
+	assert.Equal(1, strings.Count(string(out), "// source: example/in/model.go:47\n"), "appending replaces the comments")
+
+	opts.SourceComments, opts.Append = false, false
+	var buf bytes.Buffer
+	assert.NoError(Generate(&buf, model, opts))
+	assert.NotContains(buf.String(), "// source:")
+}
+
+func TestAtomicWriteAndBackup(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.proto")
+	assert.NoError(ioutil.WriteFile(path, []byte("previous"), 0600))
+
+	f := &File{Path: path, GoPackage: "out", ProtoPackage: "out", Backup: true,
+		Messages: []*Message{{Name: "Empty"}}}
+	assert.NoError(WriteFiles([]*File{f}))
+
+	written, err := ioutil.ReadFile(path)
+	assert.NoError(err)
+	assert.Contains(string(written), "message Empty")
+	backup, err := ioutil.ReadFile(path + ".bak")
+	assert.NoError(err)
+	assert.Equal("previous", string(backup))
+	info, err := os.Stat(path)
+	assert.NoError(err)
+	assert.Equal(os.FileMode(0600), info.Mode().Perm(), "the existing file's mode is kept")
+
+	entries, err := ioutil.ReadDir(dir)
+	assert.NoError(err)
+	assert.Len(entries, 2, "no temporary file is left behind")
+
+	// New files are created like any other, with 0644 less the umask.
+	reference := filepath.Join(dir, "reference")
+	assert.NoError(ioutil.WriteFile(reference, nil, 0644))
+	f.Path = filepath.Join(dir, "new.proto")
+	assert.NoError(WriteFiles([]*File{f}))
+	want, err := os.Stat(reference)
+	assert.NoError(err)
+	info, err = os.Stat(f.Path)
+	if assert.NoError(err) {
+		assert.Equal(want.Mode().Perm(), info.Mode().Perm())
+	}
+}
+
+func TestNoOverwriteAndForce(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	model := &Model{Messages: []*Message{{Name: "Empty", GoName: "Empty"}}}
+	opts := &Options{Output: filepath.Join(dir, "out.proto"), GoPackage: "out", ProtoPackage: "out", NoOverwrite: true}
+	assert.NoError(WriteFiles(Files(model, opts)))
+
+	edited := "syntax = \"proto3\";\n\nmessage Edited {\n"
+	assert.NoError(ioutil.WriteFile(opts.Output, []byte(edited), 0644))
+	assert.EqualError(WriteFiles(Files(model, opts)), opts.Output+" already exists, not overwriting it")
+	out, err := ioutil.ReadFile(opts.Output)
+	assert.NoError(err)
+	assert.Equal(edited, string(out))
+
+	// Appending to the broken file fails, forcing replaces it.
+	opts.NoOverwrite, opts.Append = false, true
+	assert.Error(WriteFiles(Files(model, opts)))
+	opts.Force = true
+	assert.NoError(WriteFiles(Files(model, opts)))
+	out, err = ioutil.ReadFile(opts.Output)
+	assert.NoError(err)
+	assert.Contains(string(out), "message Empty {")
+	assert.NotContains(string(out), "Edited")
+}
+
+func TestMergeEdits(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	newModel := func(fields ...string) *Model {
+		msg := &Message{Name: "User", GoName: "User"}
+		for i, name := range fields {
+			msg.Fields = append(msg.Fields, &Field{Name: name, TypeName: "string", Order: i + 1})
+		}
+		return &Model{Messages: []*Message{msg}}
+	}
+	opts := &Options{Output: filepath.Join(dir, "users.proto"), GoPackage: "users", ProtoPackage: "users", Append: true,
+		CacheDir: filepath.Join(dir, "cache")}
+	assert.NoError(WriteFiles(Files(newModel("id", "name"), opts)))
+
+	// Edits to generated messages survive changes elsewhere in them.
+	out, err := ioutil.ReadFile(opts.Output)
+	assert.NoError(err)
+	edited := strings.Replace(string(out), "message User {\n", "message User {\n  // Owned by the identity team.\n", 1)
+	assert.NoError(ioutil.WriteFile(opts.Output, []byte(edited), 0644))
+	assert.NoError(WriteFiles(Files(newModel("id", "name", "email"), opts)))
+	out, err = ioutil.ReadFile(opts.Output)
+	assert.NoError(err)
+	assert.Contains(string(out), "message User {\n  // Owned by the identity team.\n  string id = 1;\n  string name = 2;\n  string email = 3;\n}")
+
+	// The edits are merged again on the next run.
+	assert.NoError(WriteFiles(Files(newModel("id", "email"), opts)))
+	out, err = ioutil.ReadFile(opts.Output)
+	assert.NoError(err)
+	assert.Contains(string(out), "message User {\n  // Owned by the identity team.\n  string id = 1;\n  string email = 2;\n}")
+
+	// Lines both edited and regenerated conflict, leaving the file as it is.
+	edited = strings.Replace(string(out), "  string email = 2;", "  string email = 2 [json_name = \"mail\"];", 1)
+	assert.NoError(ioutil.WriteFile(opts.Output, []byte(edited), 0644))
+	err = WriteFiles(Files(newModel("id", "mail"), opts))
+	var conflict *ConflictError
+	if assert.ErrorAs(err, &conflict) {
+		assert.Equal([]MergeConflict{{Line: 13, Edited: []string{"  string email = 2 [json_name = \"mail\"];"}, Generated: []string{"  string mail = 2;"}}},
+			conflict.Conflicts)
+		assert.Contains(err.Error(), "users.proto was edited by hand since it was generated, and 1 of the edits conflict with the generated changes")
+	}
+	out, err = ioutil.ReadFile(opts.Output)
+	assert.NoError(err)
+	assert.Equal(edited, string(out))
+
+	opts.Force = true
+	assert.NoError(WriteFiles(Files(newModel("id", "mail"), opts)))
+	out, err = ioutil.ReadFile(opts.Output)
+	assert.NoError(err)
+	assert.NotContains(string(out), "identity team")
+}
+
+func TestFileMode(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "api", "v1", "out.proto")
+	f := &File{Path: path, GoPackage: "out", ProtoPackage: "out", Mode: 0640, Messages: []*Message{{Name: "Empty"}}}
+	assert.NoError(WriteFiles([]*File{f}))
+
+	for p, mode := range map[string]os.FileMode{path: 0640, filepath.Dir(path): 0750, filepath.Join(dir, "api"): 0750} {
+		info, err := os.Stat(p)
+		if assert.NoError(err) {
+			assert.Equal(mode, info.Mode().Perm(), p)
+		}
+	}
+
+	// Up to date files are given the mode too.
+	assert.NoError(os.Chmod(path, 0600))
+	refreshed, err := RefreshFiles([]*File{f})
+	assert.NoError(err)
+	assert.Empty(refreshed)
+	info, err := os.Stat(path)
+	assert.NoError(err)
+	assert.Equal(os.FileMode(0640), info.Mode().Perm())
+
+	assert.Equal(os.FileMode(0755), dirMode(0644))
+	assert.Equal(os.FileMode(0700), dirMode(0600))
+}
+
+func TestTimings(t *testing.T) {
+	assert := assert.New(t)
+	opts := &Options{Patterns: []string{"../../example/in", "./testdata/second"}, BatchSize: 1, Timings: Timings{}}
+	_, _, err := LoadAndAnalyze(opts)
+	assert.NoError(err)
+	assert.Contains(opts.Timings, PhaseLoad)
+	assert.Contains(opts.Timings, PhaseAnalysis)
+	assert.NotContains(opts.Timings, PhaseEmit)
+
+	timings := Timings{PhaseEmit: 8 * time.Millisecond, PhaseLoad: 1200 * time.Millisecond}
+	assert.Equal("load 1.2s, emit 8ms", timings.String())
+
+	var untimed Timings
+	untimed.Span(PhaseLoad)()
+}
+
+func TestRefreshFiles(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	first := &File{Path: filepath.Join(dir, "first.proto"), GoPackage: "out", ProtoPackage: "out",
+		Messages: []*Message{{Name: "First"}}}
+	second := &File{Path: filepath.Join(dir, "second.proto"), GoPackage: "out", ProtoPackage: "out",
+		Messages: []*Message{{Name: "Second"}}}
+	refreshed, err := RefreshFiles([]*File{first, second})
+	assert.NoError(err)
+	assert.Len(refreshed, 2)
+
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, f := range refreshed {
+		assert.NoError(os.Chtimes(f.Path, old, old))
+	}
+	second.Messages = append(second.Messages, &Message{Name: "Third"})
+	refreshed, err = RefreshFiles([]*File{first, second})
+	assert.NoError(err)
+	assert.Equal([]*File{second}, refreshed, "only the file whose contents changed is written")
+
+	info, err := os.Stat(first.Path)
+	assert.NoError(err)
+	assert.True(info.ModTime().Equal(old), "the unchanged file keeps its modification time")
+	info, err = os.Stat(second.Path)
+	assert.NoError(err)
+	assert.True(info.ModTime().After(old))
+}
+
+func TestErrorList(t *testing.T) {
+	assert := assert.New(t)
+	errs := ErrorList{
+		{Package: "example.com/b", Type: "B", Message: "second", Severity: SeverityError, File: "b.go", Line: 9, Column: 2},
+		{Package: "example.com/b", Type: "B", Field: "F", Message: "first", Severity: SeverityWarning, File: "b.go", Line: 3, Column: 1},
+		{Package: "example.com/a", Message: "no position", Severity: SeverityError},
+	}
+	assert.Equal(2, errs.Errors())
+	assert.Equal("2 errors, 1 warning\n"+
+		"b.go:\n  3:1: B.F: warning: first\n  9:2: B: error: second\n"+
+		"example.com/a:\n  error: no position", errs.Error())
+	assert.NoError(ErrorList{errs[1]}.err(), "warnings alone are not an error")
+
+	for pos, want := range map[string][3]interface{}{
+		"a/b.go:12:3": {"a/b.go", 12, 3},
+		"a/b.go:12":   {"a/b.go", 12, 0},
+		"a/b.go":      {"a/b.go", 0, 0},
+		"-":           {"", 0, 0},
+		"":            {"", 0, 0},
+	} {
+		file, line, column := parsePosition(pos)
+		assert.Equal(want, [3]interface{}{file, line, column}, pos)
+	}
+}
+
+func TestCollapseWrappers(t *testing.T) {
+	assert := assert.New(t)
+	pkgs, err := Load(&Options{Patterns: []string{"../../example/in"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, &Options{CollapseWrappers: true})
+	assert.NoError(err)
+
+	byName := make(map[string]*Message)
+	for _, msg := range model.Messages {
+		byName[msg.Name] = msg
+	}
+	assert.NotContains(byName, "ArrayOfEventField")
+	assert.NotContains(byName, "ArrayOfEventFieldItem")
+
+	fields := byName["EventSubForm"].Fields[3]
+	assert.Equal("fields", fields.Name)
+	assert.True(fields.IsRepeated)
+	assert.Equal("github.com/beam-cloud/go2proto/example/in.EventField", fields.NamedType)
+
+	out := renderTestFile(t, model)
+	assert.Contains(out, "repeated EventField fields = 4;")
+	assert.Contains(out, "repeated EventFieldItem items = 7;")
+
+	// A wrapper referenced by a repeated field can't be inlined.
+	wrapper := &Message{Name: "Tags", GoName: "Tags", PkgPath: "p", Fields: []*Field{{Name: "tag", TypeName: "string", IsRepeated: true}}}
+	holder := &Message{Name: "Holder", GoName: "Holder", PkgPath: "p", Fields: []*Field{
+		{Name: "tags", TypeName: "Tags", NamedType: "p.Tags", IsRepeated: true},
+		{Name: "primary", TypeName: "Tags", NamedType: "p.Tags"},
+	}}
+	msgs, _ := collapseWrappers([]*Message{wrapper, holder}, &Options{})
+	assert.Len(msgs, 2)
+	assert.False(holder.Fields[1].IsRepeated)
+
+	// Unreferenced single-field messages are left alone.
+	msgs, _ = collapseWrappers([]*Message{wrapper}, &Options{})
+	assert.Len(msgs, 1)
+}
+
+func TestOrderByDependency(t *testing.T) {
+	assert := assert.New(t)
+	msg := func(name string, refs ...string) *Message {
+		m := &Message{Name: name, GoName: name, PkgPath: "p"}
+		for _, ref := range refs {
+			m.Fields = append(m.Fields, &Field{Name: ref, TypeName: ref, NamedType: "p." + ref})
+		}
+		return m
+	}
+	names := func(msgs []*Message) []string {
+		var result []string
+		for _, m := range msgs {
+			result = append(result, m.Name)
+		}
+		return result
+	}
+
+	msgs := []*Message{msg("Account", "User", "Ledger"), msg("Ledger", "Entry"), msg("Entry", "Account"), msg("User"), msg("Zone")}
+	assert.Equal([]string{"Entry", "Ledger", "User", "Account", "Zone"}, names(orderByDependency(msgs)),
+		"references come first, in name order, and the Account/Ledger/Entry cycle is broken at Account")
+
+	model := &Model{Messages: []*Message{msgs[0], msgs[2], msgs[1], msgs[3], msgs[4]}}
+	files := Files(model, &Options{Output: "p.proto", GoPackage: "p", ProtoPackage: "p", Alphabetical: true})
+	assert.Equal([]string{"Account", "Entry", "Ledger", "User", "Zone"}, names(files[0].Messages))
+}
+
+func TestProtoToGo(t *testing.T) {
+	assert := assert.New(t)
+	src, err := ioutil.ReadFile("./testdata/proto2go/shop.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ProtoToGo(src, "shop")
+	if err != nil {
+		t.Fatalf("error converting: %s", err)
+	}
+	code := string(out)
+	assert.Contains(code, "package shop\n")
+	assert.Contains(code, "import \"time\"")
+	assert.Contains(code, "StatusPaid    Status = \"STATUS_PAID\"")
+	assert.Contains(code, "// @go2proto\ntype Order struct {")
+	assert.Contains(code, "Lines     []*OrderLine     `go2proto:\"name=lines,number=2\"`")
+	assert.Contains(code, "CreatedAt time.Time        `go2proto:\"name=created_at,number=4\"`")
+	assert.Contains(code, "Customer *Customer `go2proto:\"name=customer,number=8\"`")
+	assert.Contains(code, "Metadata []byte `go2proto:\"name=metadata,number=3\"`")
+
+	_, err = ProtoToGo([]byte("message {"), "shop")
+	assert.Error(err)
+}
+
+func TestProtoToGoRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+	src, err := ioutil.ReadFile("./testdata/proto2go/shop.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ProtoToGo(src, "shop")
+	if err != nil {
+		t.Fatalf("error converting: %s", err)
+	}
+	dir := t.TempDir()
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/shop\n\ngo 1.21\n"), 0666))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "shop.go"), out, 0666))
+
+	opts := &Options{Dir: dir, Patterns: []string{"."}, GoPackage: "shop", ProtoPackage: "acme.shop.v1"}
+	pkgs, err := Load(opts)
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, opts)
+	if err != nil {
+		t.Fatalf("error analyzing: %s", err)
+	}
+	assert.Empty(model.Diagnostics)
+	var buf bytes.Buffer
+	assert.NoError(Generate(&buf, model, opts))
+	generated, err := parseProto(buf.String())
+	if err != nil {
+		t.Fatalf("error parsing generated proto: %s\n%s", err, buf.String())
+	}
+
+	// fields lists the name and number of the fields of every message, nested ones under
+	// their flattened name.
+	fields := func(f *protoFile) map[string][]string {
+		result := make(map[string][]string)
+		var walk func(prefix string, m *protoMessage)
+		walk = func(prefix string, m *protoMessage) {
+			for _, fd := range m.Fields {
+				result[prefix+m.Name] = append(result[prefix+m.Name], fmt.Sprintf("%s = %d", fd.Name, fd.Number))
+			}
+			sort.Strings(result[prefix+m.Name])
+			for _, nested := range m.Messages {
+				walk(prefix+m.Name, nested)
+			}
+		}
+		for _, m := range f.Messages {
+			walk("", m)
+		}
+		return result
+	}
+	original, err := parseProto(string(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(fields(original), fields(generated))
+	assert.Equal([]string{"age = 7", "user_id = 3"}, fields(generated)["Profile"])
+}
+
+func TestOpenAPIFormat(t *testing.T) {
+	assert := assert.New(t)
+	pkgs, err := Load(&Options{Patterns: []string{"../../example/in"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, &Options{})
+	assert.NoError(err)
+
+	dir := t.TempDir()
+	files := Files(model, &Options{Format: FormatOpenAPI, Output: filepath.Join(dir, "api.json"), ProtoPackage: "acme.events"})
+	assert.Len(files, 1)
+	assert.NoError(WriteFiles(files))
+
+	var doc struct {
+		OpenAPI    string
+		Info       struct{ Title string }
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]map[string]interface{}
+			}
+		}
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "api.json"))
+	assert.NoError(err)
+	assert.NoError(json.Unmarshal(data, &doc))
+	assert.Equal("3.0.3", doc.OpenAPI)
+	assert.Equal("acme.events", doc.Info.Title)
+	props := doc.Components.Schemas["EventSubForm"].Properties
+	assert.Equal(map[string]interface{}{"type": "integer", "format": "int32"}, props["rank"])
+	assert.Equal(map[string]interface{}{"$ref": "#/components/schemas/ArrayOfEventField"}, props["fields"])
+	assert.Equal(map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "integer", "format": "int64"}}, props["slice_int"])
+	assert.Equal([]interface{}{"text", "float"}, doc.Components.Schemas["EventFieldItem"].Properties["item_type"]["enum"])
+
+	files[0].Path = filepath.Join(dir, "api.yaml")
+	var buf bytes.Buffer
+	_, err = files[0].WriteTo(&buf)
+	assert.NoError(err)
+	assert.Contains(buf.String(), "openapi: 3.0.3\n")
+	assert.Contains(buf.String(), "\n    EventSubForm:\n")
+}
+
+func TestJSONSchemaFormat(t *testing.T) {
+	assert := assert.New(t)
+	pkgs, err := Load(&Options{Patterns: []string{"../../example/in"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, &Options{})
+	assert.NoError(err)
+
+	dir := t.TempDir()
+	files := Files(model, &Options{Format: FormatJSONSchema, Output: dir})
+	assert.Len(files, len(model.Messages))
+	assert.NoError(WriteFiles(files))
+
+	var doc map[string]interface{}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "EventSubForm.schema.json"))
+	assert.NoError(err)
+	assert.NoError(json.Unmarshal(data, &doc))
+	assert.Equal("https://json-schema.org/draft/2020-12/schema", doc["$schema"])
+	assert.Equal("EventSubForm.schema.json", doc["$id"])
+	props := doc["properties"].(map[string]interface{})
+	assert.Equal(map[string]interface{}{"$ref": "#/$defs/ArrayOfEventField"}, props["fields"])
+	assert.Contains(props, "primitivePointer", "fields use their protojson names")
+	assert.Equal("^-?[0-9]+$", props["primitivePointer"].(map[string]interface{})["pattern"])
+	defs := doc["$defs"].(map[string]interface{})
+	assert.Len(defs, 4, "every transitively referenced message is defined")
+
+	assert.Equal("createdAt", jsonName("created_at"))
+	assert.Equal("floatField1", jsonName("float_field1"))
+}
+
+func TestAvroFormat(t *testing.T) {
+	assert := assert.New(t)
+	pkgs, err := Load(&Options{Patterns: []string{"../../example/in"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, &Options{})
+	assert.NoError(err)
+
+	files := Files(model, &Options{Format: FormatAvro, Output: "events.avsc", ProtoPackage: "acme.events"})
+	var buf bytes.Buffer
+	_, err = files[0].WriteTo(&buf)
+	assert.NoError(err)
+
+	var records []struct {
+		Name      string
+		Namespace string
+		Fields    []struct {
+			Name    string
+			Type    interface{}
+			Default interface{}
+		}
+	}
+	assert.NoError(json.Unmarshal(buf.Bytes(), &records))
+	var names []string
+	for _, r := range records {
+		names = append(names, r.Name)
+		assert.Equal("acme.events", r.Namespace)
+	}
+	assert.Equal([]string{"EventFieldItem", "ArrayOfEventFieldItem", "EventField", "ArrayOfEventField", "EventSubForm"}, names,
+		"records are defined before they are used")
+
+	itemType := records[0].Fields[5]
+	assert.Equal(map[string]interface{}{"type": "enum", "name": "EventFieldItemType", "symbols": []interface{}{"TEXT", "FLOAT"}}, itemType.Type)
+	fields := records[4].Fields[3]
+	assert.Equal([]interface{}{"null", "ArrayOfEventField"}, fields.Type)
+	assert.Contains(buf.String(), `"default": null`)
+
+	assert.Equal([]string{"IN_PROGRESS", "_1ST"}, enumSymbols([]string{"in-progress", "1st"}))
+}
+
+func TestThriftFormat(t *testing.T) {
+	assert := assert.New(t)
+	pkgs, err := Load(&Options{Patterns: []string{"../../example/in"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, &Options{})
+	assert.NoError(err)
+
+	files := Files(model, &Options{Format: FormatThrift, Output: "events.thrift", ProtoPackage: "acme.events"})
+	var buf bytes.Buffer
+	_, err = files[0].WriteTo(&buf)
+	assert.NoError(err)
+	out := buf.String()
+
+	assert.Contains(out, "namespace * acme.events\n")
+	assert.Contains(out, "enum EventFieldItemType {\n  TEXT = 0,\n  FLOAT = 1,\n}\n")
+	assert.Contains(out, "  6: EventFieldItemType item_type,\n")
+	assert.Contains(out, "  4: optional ArrayOfEventField fields,\n")
+	assert.Contains(out, "  7: list<i64> slice_int,\n")
+	assert.Contains(out, "  5: binary user, // User has no Thrift mapping\n")
+	assert.Less(strings.Index(out, "struct EventField {"), strings.Index(out, "struct ArrayOfEventField {"),
+		"structs are declared before they are used")
+}
+
+func TestFlatBuffersFormat(t *testing.T) {
+	assert := assert.New(t)
+	pkgs, err := Load(&Options{Patterns: []string{"../../example/in"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, &Options{})
+	assert.NoError(err)
+
+	files := Files(model, &Options{Format: FormatFlatBuffers, Output: "events.fbs", ProtoPackage: "acme.events"})
+	var buf bytes.Buffer
+	_, err = files[0].WriteTo(&buf)
+	assert.NoError(err)
+	out := buf.String()
+
+	assert.Contains(out, "namespace acme.events;\n")
+	assert.Contains(out, "enum EventFieldItemType : ubyte {\n  TEXT = 0,\n  FLOAT = 1,\n}\n")
+	assert.Contains(out, "table EventSubForm {\n  id:string;\n")
+	assert.Contains(out, "  fields:ArrayOfEventField;\n")
+	assert.Contains(out, "  slice_int:[long];\n")
+	assert.Contains(out, "  item_type:EventFieldItemType;\n")
+}
+
+func TestGraphQLFormat(t *testing.T) {
+	assert := assert.New(t)
+	pkgs, err := Load(&Options{Patterns: []string{"../../example/in"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, &Options{})
+	assert.NoError(err)
+
+	render := func(scalars map[string]string) string {
+		files := Files(model, &Options{Format: FormatGraphQL, Output: "events.graphql", GraphQLScalars: scalars})
+		var buf bytes.Buffer
+		_, err := files[0].WriteTo(&buf)
+		assert.NoError(err)
+		return buf.String()
+	}
+
+	out := render(nil)
+	assert.Contains(out, "enum EventFieldItemType {\n  TEXT\n  FLOAT\n}\n")
+	assert.Contains(out, "type EventSubForm {\n  id: String!\n")
+	assert.Contains(out, "  fields: ArrayOfEventField\n")
+	assert.Contains(out, "  primitivePointer: String!\n")
+	assert.Contains(out, "  sliceInt: [String!]!\n")
+	assert.NotContains(out, "scalar ")
+
+	out = render(map[string]string{"int64": "Int64"})
+	assert.Contains(out, "scalar Int64\n")
+	assert.Contains(out, "  primitivePointer: Int64!\n")
+}
+
+func TestDTSFormat(t *testing.T) {
+	assert := assert.New(t)
+	pkgs, err := Load(&Options{Patterns: []string{"../../example/in"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, &Options{})
+	assert.NoError(err)
+
+	files := Files(model, &Options{Format: FormatDTS, Output: "events.d.ts"})
+	assert.Len(files, 1)
+	var buf bytes.Buffer
+	_, err = files[0].WriteTo(&buf)
+	assert.NoError(err)
+	out := buf.String()
+	assert.Contains(out, "// Code generated by go2proto ")
+	assert.Contains(out, "export type EventFieldItemType = \"text\" | \"float\";\n")
+	assert.Contains(out, "export interface EventSubForm {\n  id?: string;\n")
+	assert.Contains(out, "  fields?: ArrayOfEventField;\n")
+	assert.Contains(out, "  primitivePointer?: string;\n")
+	assert.Contains(out, "  sliceInt?: string[];\n")
+	assert.Contains(out, "  itemType?: EventFieldItemType;\n")
+}
+
+func TestReproducibleOutput(t *testing.T) {
+	assert := assert.New(t)
+	src, err := ioutil.ReadFile("../../example/in/model.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// generate copies the example package into a fresh module under a new directory and
+	// returns every generated file by its path relative to that directory.
+	generate := func(format string) map[string]string {
+		dir := t.TempDir()
+		assert.NoError(os.MkdirAll(filepath.Join(dir, "models"), 0755))
+		assert.NoError(ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/repro\n\ngo 1.21\n"), 0666))
+		assert.NoError(ioutil.WriteFile(filepath.Join(dir, "models", "model.go"), src, 0666))
+
+		opts := &Options{Dir: dir, Patterns: []string{"./..."}, Format: format, Reproducible: true,
+			Output: filepath.Join(dir, "out", "models.out"), GoPackage: "example.com/repro/models", ProtoPackage: "repro"}
+		pkgs, err := Load(opts)
+		if err != nil {
+			t.Fatalf("error loading packages: %s", err)
+		}
+		model, err := Analyze(pkgs, opts)
+		assert.NoError(err)
+		assert.NoError(WriteFiles(Files(model, opts)))
+
+		result := make(map[string]string)
+		filepath.Walk(filepath.Join(dir, "out"), func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				data, _ := ioutil.ReadFile(path)
+				rel, _ := filepath.Rel(dir, path)
+				result[rel] = string(data)
+			}
+			return err
+		})
+		return result
+	}
+
+	for _, format := range []string{FormatProto, FormatOpenAPI, FormatJSONSchema, FormatAvro, FormatThrift, FormatFlatBuffers, FormatGraphQL, FormatDTS} {
+		first, second := generate(format), generate(format)
+		assert.NotEmpty(first, format)
+		assert.Equal(first, second, format)
+		for path, content := range first {
+			assert.NotContains(content, os.TempDir(), "%s: %s embeds an absolute path", format, path)
+			assert.NotContains(content, Version(), "%s: %s embeds the go2proto version", format, path)
+		}
+	}
+}
+
+func TestMessageConstraints(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/validate"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, &Options{})
+	if err != nil {
+		t.Fatalf("error analysing packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	assert.Equal([]*Constraint{
+		{ID: "ends_after_start", Message: "ends_at must be after starts_at", Expression: "this.ends_at > this.starts_at"},
+		{ID: "guests_fit", Expression: `this.guests <= this.capacity ? "" : "more than " + string(this.capacity) + " guests"`},
+	}, model.Messages[0].Constraints)
+	assert.Empty(model.Messages[1].Constraints)
+
+	out := renderTestFile(t, model)
+	assert.Contains(out, "import \"buf/validate/validate.proto\";\n")
+	assert.Contains(out, `message Booking {
+  option (buf.validate.message).cel = {
+    id: "ends_after_start"
+    message: "ends_at must be after starts_at"
+    expression: "this.ends_at > this.starts_at"
+  };
+  option (buf.validate.message).cel = {
+    id: "guests_fit"
+    expression: "this.guests <= this.capacity ? \"\" : \"more than \" + string(this.capacity) + \" guests\""
+  };
+  google.protobuf.Timestamp starts_at = 1;`)
+	assert.Contains(out, "message Room {\n  string number = 1;\n}")
+	_, err = parseProto(out)
+	assert.NoError(err)
+}
+
+func TestCheckCompiled(t *testing.T) {
+	pkgs, err := Load(&Options{Patterns: []string{"./testdata/second"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	model, err := Analyze(pkgs, &Options{})
+	if err != nil {
+		t.Fatalf("error analysing packages: %s", err)
+	}
+
+	assert := assert.New(t)
+	files := Files(model, &Options{Output: filepath.Join(t.TempDir(), "second.proto"), GoPackage: "second", ProtoPackage: "second"})
+	drifts, err := CheckCompiled(files, "testdata/compiled")
+	assert.NoError(err)
+	pb := filepath.Join("testdata", "compiled", "second.pb.go")
+	var got []string
+	for _, d := range drifts {
+		got = append(got, d.String())
+	}
+	assert.Equal([]string{
+		pb + ":17: Account: field 2 (balance) is double in the source but compiled as fixed32",
+		pb + ":18: Account: field 3 is status in the source but state in the compiled type",
+		pb + ":19: Account: compiled field 4 (tags) no longer exists in the source",
+	}, got)
+
+	// Without .pb.go files beside the output there is nothing to compare.
+	drifts, err = CheckCompiled(files, "")
+	assert.NoError(err)
+	assert.Empty(drifts)
+
+	assert.Equal("X1Password", goCamelCase("_1Password"))
+	assert.Equal("EventSubForm", goCamelCase("EventSubForm"))
+	assert.Equal("FooBar", goCamelCase("foo_bar"))
+}
+
+// recursiveList holds itself, for checking FromValues stops unwrapping it.
+type recursiveList []recursiveList
+
+type recursiveTree struct{ Kids recursiveList }
+
+func TestFromValues(t *testing.T) {
+	assert := assert.New(t)
+	model, err := FromValues(&in.EventSubForm{}, in.EventFieldItem{})
+	assert.NoError(err)
+
+	pkgs, err := Load(&Options{Patterns: []string{"../../example/in"}})
+	if err != nil {
+		t.Fatalf("error loading packages: %s", err)
+	}
+	static, err := Analyze(pkgs, &Options{})
+	assert.NoError(err)
+
+	// fields drops what only static analysis knows: source positions and enum values.
+	fields := func(msg *Message) []Field {
+		var result []Field
+		for _, fd := range msg.Fields {
+			f := *fd
+			f.Pos, f.EnumValues = token.Position{}, nil
+			result = append(result, f)
+		}
+		return result
+	}
+	var names []string
+	for _, msg := range model.Messages {
+		names = append(names, msg.Name)
+		for _, other := range static.Messages {
+			if other.Name == msg.Name {
+				assert.Equal(fields(other), fields(msg), msg.Name)
+			}
+		}
+	}
+	// User isn't annotated, but reflect follows every referenced struct.
+	assert.Equal([]string{"ArrayOfEventField", "ArrayOfEventFieldItem", "EventField", "EventFieldItem", "EventSubForm", "User"}, names)
+
+	var buf bytes.Buffer
+	assert.NoError(Generate(&buf, model, &Options{Output: "events.proto", GoPackage: "events", ProtoPackage: "events"}))
+	assert.Contains(buf.String(), "  repeated EventFieldItem event_field_item = 1;\n")
+	assert.Contains(buf.String(), "  string item_type = 6;\n")
+
+	model, err = FromValues(recursiveTree{})
+	if assert.NoError(err) && assert.Len(model.Skipped, 1) {
+		assert.Equal("type generator.recursiveList holds itself and has no proto representation", model.Skipped[0].Reason)
+	}
+
+	_, err = FromValues(42)
+	assert.EqualError(err, "FromValues: int is not a named struct")
+	_, err = FromValues(struct{ ID string }{})
+	assert.Error(err)
+}
+
+// TestRenderedFields analyses testdata packages and checks the diagnostics reported, in
+// order, and the proto rendered, which Generate validates.
+func TestRenderedFields(t *testing.T) {
+	const testdata = "github.com/beam-cloud/go2proto/pkg/generator/testdata/"
+	for _, tc := range []struct {
+		name    string
+		pattern string
+		opts    Options
+		// diagnostics are the codes and messages of the diagnostics, located by file name.
+		diagnostics []string
+		contains    []string
+		excludes    []string
+	}{
+		{
+			name: "durations", pattern: "durations",
+			diagnostics: []string{
+				"ineffective-annotation durations.go:10:2: Job.Retries: go2proto tag duration=millis has no effect: the field is not a time.Duration",
+				"ineffective-annotation durations.go:11:2: Job.Delay: go2proto tag duration=seconds has no effect: expected nanos, millis or well-known",
+			},
+			contains: []string{
+				"  int64 timeout = 1;\n",
+				"// duration in milliseconds\n  int64 interval = 2;\n",
+				"  google.protobuf.Duration backoff = 3;\n",
+				"import \"google/protobuf/duration.proto\";\n",
+				"  int64 delay = 5;\n",
+				"  int64 deadline = 6;\n",
+				"// duration in milliseconds\n  repeated int64 steps = 7;\n",
+			},
+		},
+		{
+			// The tag takes precedence over Options.Durations.
+			name: "durations well-known", pattern: "durations", opts: Options{Durations: DurationWellKnown},
+			diagnostics: []string{
+				"ineffective-annotation durations.go:10:2: Job.Retries: go2proto tag duration=millis has no effect: the field is not a time.Duration",
+				"ineffective-annotation durations.go:11:2: Job.Delay: go2proto tag duration=seconds has no effect: expected nanos, millis or well-known",
+			},
+			contains: []string{
+				"  google.protobuf.Duration timeout = 1;\n",
+				"// duration in milliseconds\n  int64 interval = 2;\n",
+				"  google.protobuf.Duration delay = 5;\n",
+				"  google.protobuf.Duration deadline = 6;\n",
+				"// duration in milliseconds\n  repeated int64 steps = 7;\n",
+			},
+		},
+		{
+			name: "times", pattern: "times",
+			diagnostics: []string{
+				"ineffective-annotation times.go:11:2: Event.Name: go2proto tag time=unix-seconds has no effect: the field is not a time.Time",
+			},
+			contains: []string{
+				"  google.protobuf.Timestamp created_at = 1;\n",
+				"// unix time in seconds\n  int64 updated_at = 2;\n",
+				"// unix time in milliseconds\n  repeated int64 seen_at = 3;\n",
+				"  google.protobuf.Timestamp expires_at = 4;\n",
+				"  repeated google.protobuf.Timestamp reminders = 6;\n",
+			},
+		},
+		{
+			// The tag takes precedence over Options.Times.
+			name: "times unix-millis", pattern: "times", opts: Options{Times: TimeUnixMillis},
+			diagnostics: []string{
+				"ineffective-annotation times.go:11:2: Event.Name: go2proto tag time=unix-seconds has no effect: the field is not a time.Time",
+			},
+			contains: []string{
+				"// unix time in milliseconds\n  int64 created_at = 1;\n",
+				"// unix time in seconds\n  int64 updated_at = 2;\n",
+				"  google.protobuf.Timestamp expires_at = 4;\n",
+				"// unix time in milliseconds\n  repeated int64 reminders = 6;\n",
+			},
+		},
+		{
+			// Fields numbered by position skip the numbers pinned by tags.
+			name: "numbers", pattern: "numbers",
+			diagnostics: []string{
+				"ineffective-annotation numbers.go:8:2: Profile.Age: go2proto tag number=1 has no effect: field Email already has number 1",
+				"ineffective-annotation numbers.go:9:2: Profile.Country: go2proto tag number=19500 has no effect: expected a field number from 1 to 536870911, outside 19000-19999",
+			},
+			contains: []string{`message Profile {
+  string id = 2;
+  string name = 3;
+  string mail = 1;
+  int64 age = 4;
+  string country = 5;
+}`},
+		},
+		{
+			name: "numbers hashed", pattern: "numbers", opts: Options{Numbering: NumberingHash},
+			diagnostics: []string{
+				"ineffective-annotation numbers.go:8:2: Profile.Age: go2proto tag number=1 has no effect: field Email already has number 1",
+				"ineffective-annotation numbers.go:9:2: Profile.Country: go2proto tag number=19500 has no effect: expected a field number from 1 to 536870911, outside 19000-19999",
+			},
+			contains: []string{
+				"  string id = 2;\n",
+				"  string mail = 1;\n",
+				fmt.Sprintf("  string name = %d;\n", hashFieldNumber("name")),
+			},
+		},
+		{
+			name: "field masks unset", pattern: "fieldmasks",
+			diagnostics: []string{
+				"unmapped-type fieldmasks.go:11:2: UpdateUserRequest.Mask: type " + testdata + "fieldmasks.UpdateMask is not annotated with @go2proto and has no proto mapping",
+			},
+		},
+		{
+			name: "field masks", pattern: "fieldmasks", opts: Options{FieldMaskTypes: []string{testdata + "fieldmasks.UpdateMask"}},
+			contains: []string{
+				"  google.protobuf.FieldMask mask = 2;\n",
+				"import \"google/protobuf/field_mask.proto\";\n",
+			},
+		},
+		{
+			name: "any", pattern: "dynamic",
+			diagnostics: []string{
+				"ineffective-annotation dynamic.go:14:2: Event.Count: go2proto tag any=bytes has no effect: the field is not an interface{}, map[string]interface{} or json.RawMessage",
+			},
+			contains: []string{
+				"  google.protobuf.Value payload = 2;\n",
+				"  google.protobuf.Struct metadata = 3;\n",
+				"  google.protobuf.Value raw = 4;\n",
+				"  repeated google.protobuf.Value items = 5;\n",
+				"// JSON-encoded\n  bytes blob = 6;\n",
+				"  google.protobuf.Any extra = 7;\n",
+				"import \"google/protobuf/struct.proto\";\n",
+				"import \"google/protobuf/any.proto\";\n",
+			},
+		},
+		{
+			// The tags take precedence over Options.AnyStrategy.
+			name: "any bytes", pattern: "dynamic", opts: Options{AnyStrategy: AnyStrategyBytes},
+			diagnostics: []string{
+				"ineffective-annotation dynamic.go:14:2: Event.Count: go2proto tag any=bytes has no effect: the field is not an interface{}, map[string]interface{} or json.RawMessage",
+			},
+			contains: []string{
+				"// JSON-encoded\n  bytes metadata = 3;\n",
+				"// JSON-encoded\n  repeated bytes items = 5;\n",
+				"  google.protobuf.Any extra = 7;\n",
+			},
+			excludes: []string{"struct.proto"},
+		},
+		{
+			name: "oneofs", pattern: "oneofs",
+			diagnostics: []string{
+				"ineffective-annotation oneofs.go:23:2: Result.Tags: @go2proto oneof source: field Tags is repeated, which oneof fields can't be, leaving it out of the oneof",
+				"ineffective-annotation oneofs.go:17:6: Result.Missing: @go2proto oneof source: field Missing is not a field of the struct, leaving it out of the oneof",
+			},
+			contains: []string{`message Result {
+  string id = 1;
+  oneof result {
+    Success success = 2;
+    Error error = 4;
+  }
+  oneof source {
+    string url = 3;
+    string path = 5;
+  }
+  repeated string tags = 6;
+  int32 retries = 7;
+}`, `message Outcome {
+  option deprecated = true;
+  oneof result {
+    Success success = 1;
+    Error error = 2;
+  }
+}`},
+		},
+		{
+			// Wrappers referenced by oneof fields are kept, as oneofs can't hold repeated fields.
+			name: "oneofs collapsing wrappers", pattern: "oneofs", opts: Options{CollapseWrappers: true},
+			diagnostics: []string{
+				"ineffective-annotation oneofs.go:23:2: Result.Tags: @go2proto oneof source: field Tags is repeated, which oneof fields can't be, leaving it out of the oneof",
+				"ineffective-annotation oneofs.go:17:6: Result.Missing: @go2proto oneof source: field Missing is not a field of the struct, leaving it out of the oneof",
+				"kept-wrapper oneofs.go:40:2: Tagging.Labels: keeping wrapper ArrayOfLabel instead of collapsing it: the field is in oneof labels, which can't hold repeated fields",
+			},
+			contains: []string{
+				"message ArrayOfLabel {",
+				"  oneof labels {\n    ArrayOfLabel labels = 1;\n    string label = 2;\n  }\n",
+			},
+		},
+		{
+			name: "message options", pattern: "options", opts: Options{Imports: map[string][]string{"": {"acme/resource.proto"}}},
+			contains: []string{"import \"acme/resource.proto\";\n", `message User {
+  option (acme.resource).type="users";
+  option (acme.resource).pattern="users/{user}";
+  string name = 1;
+}`},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pkgs, err := Load(&Options{Patterns: []string{"./testdata/" + tc.pattern}})
+			if err != nil {
+				t.Fatalf("error loading packages: %s", err)
+			}
+			model, err := Analyze(pkgs, &tc.opts)
+			if err != nil {
+				t.Fatalf("error analysing packages: %s", err)
+			}
+
+			assert := assert.New(t)
+			var diagnostics []string
+			for _, d := range model.Diagnostics {
+				d.File = filepath.Base(d.File)
+				diagnostics = append(diagnostics, d.Code+" "+d.String())
+			}
+			assert.Equal(tc.diagnostics, diagnostics)
+
+			opts := tc.opts
+			opts.Output, opts.GoPackage, opts.ProtoPackage = "test.proto", "test", "test"
+			var buf bytes.Buffer
+			if !assert.NoError(Generate(&buf, model, &opts)) {
+				return
+			}
+			for _, want := range tc.contains {
+				assert.Contains(buf.String(), want)
+			}
+			for _, unwanted := range tc.excludes {
+				assert.NotContains(buf.String(), unwanted)
+			}
+		})
+	}
+}
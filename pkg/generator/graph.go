@@ -0,0 +1,65 @@
+package generator
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// WriteGraph writes the reference graph of the model in Graphviz DOT format: a node per
+// message, enum and service, and an edge per field (or RPC) referencing a message or enum,
+// labelled with the field's name. Render it with e.g. `dot -Tsvg`.
+func WriteGraph(w io.Writer, model *Model) error {
+	nodes := make(map[string]bool)
+	var b strings.Builder
+	b.WriteString("digraph go2proto {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [fontname=\"Helvetica\"];\n")
+	b.WriteString("  edge [fontname=\"Helvetica\", fontsize=10];\n")
+	for _, msg := range model.Messages {
+		id := msg.PkgPath + "." + msg.GoName
+		nodes[id] = true
+		fmt.Fprintf(&b, "  %s [label=%s, shape=box];\n", strconv.Quote(id), strconv.Quote(msg.Name))
+	}
+	for _, e := range model.Enums {
+		id := e.PkgPath + "." + e.GoName
+		nodes[id] = true
+		fmt.Fprintf(&b, "  %s [label=%s, shape=ellipse];\n", strconv.Quote(id), strconv.Quote(e.Name))
+	}
+	for _, svc := range model.Services {
+		fmt.Fprintf(&b, "  %s [label=%s, shape=component];\n", strconv.Quote(svc.PkgPath+"."+svc.GoName), strconv.Quote(svc.Name))
+	}
+
+	edge := func(from, to, label string) {
+		fmt.Fprintf(&b, "  %s -> %s [label=%s];\n", strconv.Quote(from), strconv.Quote(to), strconv.Quote(label))
+	}
+	for _, msg := range model.Messages {
+		for _, fd := range msg.Fields {
+			if !nodes[fd.NamedType] {
+				continue
+			}
+			label := fd.Name
+			switch {
+			case fd.IsRepeated:
+				label += "[]"
+			case fd.MapKey != "":
+				label += "[" + fd.MapKey + "]"
+			}
+			edge(msg.PkgPath+"."+msg.GoName, fd.NamedType, label)
+		}
+	}
+	for _, svc := range model.Services {
+		for _, m := range svc.Methods {
+			if nodes[m.RequestType] {
+				edge(svc.PkgPath+"."+svc.GoName, m.RequestType, m.Name+" request")
+			}
+			if nodes[m.ResponseType] {
+				edge(svc.PkgPath+"."+svc.GoName, m.ResponseType, m.Name+" response")
+			}
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,100 @@
+package generator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// graphQLScalars maps proto scalar and well-known types to GraphQL types by default.
+// GraphQL's Int is 32-bit, so 64-bit integers are strings unless mapped otherwise.
+var graphQLScalars = map[string]string{
+	"double":                    "Float",
+	"float":                     "Float",
+	"int32":                     "Int",
+	"uint32":                    "Int",
+	"int64":                     "String",
+	"uint64":                    "String",
+	"bool":                      "Boolean",
+	"string":                    "String",
+	"bytes":                     "String",
+	"google.protobuf.Timestamp": "String",
+	"google.protobuf.Duration":  "String",
+	"google.protobuf.FieldMask": "String",
+	"google.protobuf.Any":       "String",
+	"google.protobuf.Struct":    "String",
+	"google.protobuf.Value":     "String",
+}
+
+// graphQLBuiltins are the scalars every GraphQL schema has without declaring them.
+var graphQLBuiltins = map[string]bool{"Int": true, "Float": true, "String": true, "Boolean": true, "ID": true}
+
+// renderGraphQL renders the file as GraphQL SDL: an enum per enum and a type per message,
+// with field names in lowerCamelCase. Scalars and repeated fields are non-null, as proto3
+// always has a value for them; message fields are nullable. f.GraphQLScalars overrides
+// the scalar mapping, and custom scalars it introduces are declared.
+func renderGraphQL(f *File) ([]byte, error) {
+	names := messageNames(f.Messages)
+	enums := enumsByGoName(f.Enums)
+	scalar := func(typ string) string {
+		if mapped, ok := f.GraphQLScalars[typ]; ok {
+			return mapped
+		}
+		return graphQLScalars[typ]
+	}
+
+	var body strings.Builder
+	custom := make(map[string]bool)
+	for _, msg := range f.Messages {
+		fmt.Fprintf(&body, "type %s {\n", msg.Name)
+		for _, fd := range msg.Fields {
+			typ, nonNull, comment := scalar(fd.TypeName), true, ""
+			switch {
+			case fd.MapKey != "":
+				typ, nonNull, comment = "String", false, " # map<"+fd.MapKey+", "+fd.TypeName+"> has no GraphQL mapping"
+			case len(fd.EnumValues) > 0 && enums[fd.NamedType] != nil:
+				typ = enums[fd.NamedType].Name
+			case len(fd.EnumValues) > 0:
+				typ = scalar("string")
+			case names[fd.NamedType] != "":
+				typ, nonNull = names[fd.NamedType], false
+			case typ == "":
+				typ, comment = "String", " # "+fd.TypeName+" has no GraphQL mapping"
+			}
+			if names[fd.NamedType] == "" && enums[fd.NamedType] == nil && !graphQLBuiltins[typ] {
+				custom[typ] = true
+			}
+			switch {
+			case fd.IsRepeated:
+				typ = "[" + typ + "!]!"
+			case nonNull:
+				typ += "!"
+			}
+			fmt.Fprintf(&body, "  %s: %s%s\n", jsonName(fd.Name), typ, comment)
+		}
+		body.WriteString("}\n\n")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Code generated by %s. DO NOT EDIT.\n\n", f.generatedBy())
+	var scalars []string
+	for name := range custom {
+		scalars = append(scalars, name)
+	}
+	sort.Strings(scalars)
+	for _, name := range scalars {
+		fmt.Fprintf(&b, "scalar %s\n", name)
+	}
+	if len(scalars) > 0 {
+		b.WriteString("\n")
+	}
+	for _, e := range f.Enums {
+		fmt.Fprintf(&b, "enum %s {\n", e.Name)
+		for _, symbol := range enumSymbols(e.Values) {
+			fmt.Fprintf(&b, "  %s\n", symbol)
+		}
+		b.WriteString("}\n\n")
+	}
+	b.WriteString(body.String())
+	return []byte(strings.TrimSuffix(b.String(), "\n")), nil
+}
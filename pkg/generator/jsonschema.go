@@ -0,0 +1,136 @@
+package generator
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+)
+
+// jsonSchemaDialect is the JSON Schema draft the -format jsonschema documents declare.
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// jsonSchemaScalars maps proto scalar and well-known types to the JSON protojson produces.
+// 64-bit integers are encoded as strings by protojson, and accepted as numbers.
+var jsonSchemaScalars = map[string]map[string]interface{}{
+	"double":                    {"type": "number"},
+	"float":                     {"type": "number"},
+	"int32":                     {"type": "integer"},
+	"uint32":                    {"type": "integer", "minimum": 0},
+	"int64":                     {"type": []string{"integer", "string"}, "pattern": "^-?[0-9]+$"},
+	"uint64":                    {"type": []string{"integer", "string"}, "pattern": "^[0-9]+$"},
+	"bool":                      {"type": "boolean"},
+	"string":                    {"type": "string"},
+	"bytes":                     {"type": "string", "contentEncoding": "base64"},
+	"google.protobuf.Timestamp": {"type": "string", "format": "date-time"},
+	"google.protobuf.Duration":  {"type": "string", "pattern": "^-?[0-9]+(\\.[0-9]+)?s$"},
+	"google.protobuf.FieldMask": {"type": "string"},
+	"google.protobuf.Any":       {"type": "object", "required": []string{"@type"}},
+	"google.protobuf.Struct":    {"type": "object"},
+	"google.protobuf.Value":     {},
+}
+
+// jsonSchemaFiles returns one schema file per message, named <message>.schema.json inside
+// dir. Each file lists its message first, followed by every message it references, and
+// records the message's proto package (pkg unless the message sets its own).
+func jsonSchemaFiles(msgs []*Message, dir string, pkg string) []*File {
+	byGoName := make(map[string]*Message, len(msgs))
+	for _, msg := range msgs {
+		byGoName[msg.PkgPath+"."+msg.GoName] = msg
+	}
+
+	files := make([]*File, 0, len(msgs))
+	for _, root := range msgs {
+		seen := map[*Message]bool{root: true}
+		queue := []*Message{root}
+		for i := 0; i < len(queue); i++ {
+			for _, fd := range queue[i].Fields {
+				if ref, ok := byGoName[fd.NamedType]; ok && !seen[ref] && len(fd.EnumValues) == 0 {
+					seen[ref] = true
+					queue = append(queue, ref)
+				}
+			}
+		}
+		f := &File{
+			Path:         filepath.Join(dir, root.Name+".schema.json"),
+			ProtoPackage: pkg,
+			Messages:     queue,
+			Format:       FormatJSONSchema,
+		}
+		if root.Package != "" {
+			f.ProtoPackage = root.Package
+		}
+		files = append(files, f)
+	}
+	return files
+}
+
+// renderJSONSchema renders the file's first message as a JSON Schema document, with the
+// other messages under $defs.
+func renderJSONSchema(f *File) ([]byte, error) {
+	root := f.Messages[0]
+	refs := make(map[string]string, len(f.Messages))
+	refs[root.PkgPath+"."+root.GoName] = "#"
+	for _, msg := range f.Messages[1:] {
+		refs[msg.PkgPath+"."+msg.GoName] = "#/$defs/" + msg.Name
+	}
+
+	doc := jsonSchemaMessage(root, refs)
+	doc["$schema"] = jsonSchemaDialect
+	doc["$id"] = filepath.Base(f.Path)
+	doc["title"] = root.Name
+	if len(f.Messages) > 1 {
+		defs := make(map[string]interface{}, len(f.Messages)-1)
+		for _, msg := range f.Messages[1:] {
+			defs[msg.Name] = jsonSchemaMessage(msg, refs)
+		}
+		doc["$defs"] = defs
+	}
+
+	out, err := json.MarshalIndent(doc, "", "  ")
+	return append(out, '\n'), err
+}
+
+// jsonSchemaMessage returns the object schema of a message, keyed by protojson field names.
+func jsonSchemaMessage(msg *Message, refs map[string]string) map[string]interface{} {
+	properties := make(map[string]interface{}, len(msg.Fields))
+	for _, fd := range msg.Fields {
+		var schema map[string]interface{}
+		switch {
+		case len(fd.EnumValues) > 0:
+			schema = map[string]interface{}{"type": "string", "enum": fd.EnumValues}
+		case refs[fd.NamedType] != "":
+			schema = map[string]interface{}{"$ref": refs[fd.NamedType]}
+		case jsonSchemaScalars[fd.TypeName] != nil:
+			schema = jsonSchemaScalars[fd.TypeName]
+		default:
+			schema = map[string]interface{}{}
+		}
+		switch {
+		case fd.IsRepeated:
+			schema = map[string]interface{}{"type": "array", "items": schema}
+		case fd.MapKey != "":
+			schema = map[string]interface{}{"type": "object", "additionalProperties": schema}
+		}
+		properties[jsonName(fd.Name)] = schema
+	}
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+// jsonName returns the JSON name protoc derives for a field: underscores are dropped and
+// the letter following each is upper-cased, e.g. "created_at" becomes "createdAt".
+func jsonName(name string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range name {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper && r >= 'a' && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		upper = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,217 @@
+package generator
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// loadMode requests syntax and types for the matched packages only. Analysis only reads the
+// types of dependencies, which go/packages then takes from compiler export data instead of
+// parsing and type checking every transitive dependency from source as LoadAllSyntax does
+// (over 10x faster on this repository, see BenchmarkLoad).
+const loadMode = packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles | packages.NeedImports |
+	packages.NeedTypes | packages.NeedSyntax | packages.NeedModule
+
+// listMode only lists the packages and their files, which is cheap compared to loadMode.
+const listMode = packages.NeedName | packages.NeedFiles | packages.NeedModule
+
+// Load loads the packages matching opts.Patterns with the syntax and type information
+// Analyze needs. Patterns of the form module@version are downloaded through the module
+// proxy instead of being looked up in opts.Dir. With opts.CacheDir, packages whose analysis
+// is cached and up to date are returned without syntax and types (see loadLocal).
+func Load(opts *Options) ([]*packages.Package, error) {
+	defer opts.Timings.Span(PhaseLoad)()
+	cfg, env := loadConfig(opts)
+	local, remote := splitPatterns(opts.Patterns)
+	var pkgsLoaded []*packages.Package
+	if len(local) > 0 || len(remote) == 0 {
+		pkgs, err := loadLocal(cfg, local, opts)
+		if err != nil {
+			return nil, err
+		}
+		pkgsLoaded = append(pkgsLoaded, pkgs...)
+	}
+	if len(remote) > 0 {
+		dir, patterns, cleanup, err := remoteWorkspace(remote, opts)
+		if err != nil {
+			return nil, err
+		}
+		defer cleanup()
+		remoteCfg := *cfg
+		remoteCfg.Dir = dir
+		remoteCfg.Env = append(append([]string{}, os.Environ()...), env...)
+		remoteCfg.Env = append(remoteCfg.Env, "GOWORK=off")
+		pkgs, err := packages.Load(&remoteCfg, patterns...)
+		if err != nil {
+			return nil, err
+		}
+		pkgsLoaded = append(pkgsLoaded, pkgs...)
+	}
+	if opts.IncludeTests {
+		pkgsLoaded = testVariants(pkgsLoaded)
+	}
+	if err := loadErrors(pkgsLoaded); err != nil {
+		return nil, err
+	}
+	for _, p := range pkgsLoaded {
+		opts.verbosef("loaded package %s (%d files)", p.PkgPath, len(p.GoFiles))
+	}
+	return pkgsLoaded, nil
+}
+
+// loadConfig returns the packages.Config for opts, and the environment variables it adds
+// for the build configuration.
+func loadConfig(opts *Options) (*packages.Config, []string) {
+	cfg := &packages.Config{
+		Dir:   opts.Dir,
+		Mode:  loadMode,
+		Fset:  token.NewFileSet(),
+		Tests: opts.IncludeTests,
+	}
+	if len(opts.BuildTags) > 0 {
+		cfg.BuildFlags = []string{"-tags=" + strings.Join(opts.BuildTags, ",")}
+	}
+	var env []string
+	if opts.GOOS != "" {
+		env = append(env, "GOOS="+opts.GOOS)
+	}
+	if opts.GOARCH != "" {
+		env = append(env, "GOARCH="+opts.GOARCH)
+	}
+	if opts.DisableCgo {
+		env = append(env, "CGO_ENABLED=0")
+	}
+	if len(env) > 0 {
+		cfg.Env = append(os.Environ(), env...)
+	}
+	return cfg, env
+}
+
+// loadErrors turns the errors of the loaded packages into an ErrorList, explaining
+// failures of cgo packages; it returns nil if every package loaded.
+func loadErrors(pkgs []*packages.Package) error {
+	var errs ErrorList
+	for _, p := range pkgs {
+		if len(p.Errors) == 0 {
+			continue
+		}
+		if files := cgoFiles(p); len(files) > 0 {
+			errs = append(errs, &Diagnostic{
+				Package: p.PkgPath,
+				Code:    CodeCgo,
+				Message: fmt.Sprintf("package uses cgo (%s) and could not be built, install a C toolchain or disable cgo to load it without these files",
+					strings.Join(files, ", ")),
+				Severity: SeverityError,
+			})
+		}
+		for _, e := range p.Errors {
+			d := &Diagnostic{Package: p.PkgPath, Code: CodeLoad, Message: e.Msg, Severity: SeverityError}
+			d.File, d.Line, d.Column = parsePosition(e.Pos)
+			errs = append(errs, d)
+		}
+	}
+	return errs.err()
+}
+
+// loadLocal loads the local patterns. Type checking is the dominant cost of a run, so with a
+// cache directory a quick load of the packages' file lists comes first, and only the
+// packages without an up-to-date cached analysis are loaded with syntax and types. The
+// others, whose files and same-module dependencies are unchanged (see cache.key), are
+// returned as listed, for Analyze to take their model from the cache.
+func loadLocal(cfg *packages.Config, patterns []string, opts *Options) ([]*packages.Package, error) {
+	if opts.CacheDir == "" || opts.IncludeTests {
+		return packages.Load(cfg, patterns...)
+	}
+	listCfg := *cfg
+	listCfg.Mode = listMode
+	listed, err := packages.Load(&listCfg, patterns...)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &cache{dir: opts.CacheDir}
+	var stale []string
+	for _, p := range listed {
+		if len(p.Errors) > 0 {
+			// Let the full load report the errors.
+			return packages.Load(cfg, patterns...)
+		}
+		if !c.has(p, opts) {
+			stale = append(stale, p.PkgPath)
+		}
+	}
+	switch len(stale) {
+	case 0:
+		opts.verbosef("analysis of all %d packages is cached, skipping type checking", len(listed))
+		return listed, nil
+	case len(listed):
+		return packages.Load(cfg, patterns...)
+	}
+
+	opts.verbosef("analysis of %d of %d packages is cached, type checking the others", len(listed)-len(stale), len(listed))
+	loaded, err := packages.Load(cfg, stale...)
+	if err != nil {
+		return nil, err
+	}
+	byPath := make(map[string]*packages.Package, len(loaded))
+	for _, p := range loaded {
+		byPath[p.PkgPath] = p
+	}
+	for i, p := range listed {
+		if full, ok := byPath[p.PkgPath]; ok {
+			listed[i] = full
+		}
+	}
+	return listed, nil
+}
+
+// testVariants reduces a load with Tests set to one package per path: the variant compiled
+// with the package's _test.go files replaces the plain package, external _test packages are
+// kept, and the generated test binaries are dropped.
+func testVariants(pkgs []*packages.Package) []*packages.Package {
+	hasVariant := make(map[string]bool)
+	for _, p := range pkgs {
+		if strings.Contains(p.ID, " [") {
+			hasVariant[p.PkgPath] = true
+		}
+	}
+
+	var result []*packages.Package
+	for _, p := range pkgs {
+		isVariant := strings.Contains(p.ID, " [")
+		switch {
+		case strings.HasSuffix(p.ID, ".test"):
+			continue
+		case hasVariant[p.PkgPath] && !isVariant:
+			continue
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+// cgoFiles returns the base names of the package's files that import "C".
+func cgoFiles(p *packages.Package) []string {
+	var result []string
+	fset := token.NewFileSet()
+	for _, name := range p.GoFiles {
+		f, err := parser.ParseFile(fset, name, nil, parser.ImportsOnly)
+		if err != nil {
+			continue
+		}
+		for _, imp := range f.Imports {
+			if path, _ := strconv.Unquote(imp.Path.Value); path == "C" {
+				result = append(result, filepath.Base(name))
+				break
+			}
+		}
+	}
+	return result
+}
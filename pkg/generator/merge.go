@@ -0,0 +1,133 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Markers delimiting hand-written sections that Options.Merge preserves across regenerations.
+const (
+	ManualBegin = "// go2proto:manual-begin"
+	ManualEnd   = "// go2proto:manual-end"
+)
+
+// manualSection is a hand-written block of lines, including its markers.
+type manualSection struct {
+	// Anchor identifies the top-level statement the section follows, e.g. "message User"
+	// or "package"; empty means it sits right after the syntax statement.
+	Anchor string
+	Lines  []string
+}
+
+// extractManualSections returns the manual sections of an existing file in order.
+func extractManualSections(existing string) ([]manualSection, error) {
+	var sections []manualSection
+	var current *manualSection
+	anchor := ""
+	depth := 0
+
+	for i, line := range splitLines(existing) {
+		trimmed := strings.TrimSpace(line)
+		if current != nil {
+			current.Lines = append(current.Lines, line)
+			if trimmed == ManualEnd {
+				sections = append(sections, *current)
+				current = nil
+			}
+			continue
+		}
+		if trimmed == ManualBegin {
+			current = &manualSection{Anchor: anchor, Lines: []string{line}}
+			continue
+		}
+		if trimmed == ManualEnd {
+			return nil, fmt.Errorf("line %d: %s without a matching %s", i+1, ManualEnd, ManualBegin)
+		}
+		if depth == 0 {
+			if key := anchorKey(trimmed); key != "" {
+				anchor = key
+			}
+		}
+		depth += strings.Count(trimmed, "{") - strings.Count(trimmed, "}")
+	}
+	if current != nil {
+		return nil, fmt.Errorf("%s without a matching %s", ManualBegin, ManualEnd)
+	}
+	return sections, nil
+}
+
+// anchorKey returns the anchor name of a top-level statement, or "" for other lines.
+func anchorKey(line string) string {
+	fields := strings.Fields(strings.TrimSuffix(line, "{"))
+	if len(fields) == 0 {
+		return ""
+	}
+	switch fields[0] {
+	case "syntax", "package", "import":
+		return fields[0]
+	case "option":
+		if len(fields) > 1 {
+			return "option " + strings.TrimSuffix(fields[1], "=")
+		}
+	case "message", "enum", "service":
+		if len(fields) > 1 {
+			return fields[0] + " " + fields[1]
+		}
+	}
+	return ""
+}
+
+// mergeManualSections inserts the manual sections into freshly generated output, each after
+// the statement it followed before. Sections whose anchor disappeared are appended at the end.
+func mergeManualSections(generated string, sections []manualSection) string {
+	if len(sections) == 0 {
+		return generated
+	}
+	lines := splitLines(generated)
+
+	// after[i] lists the sections to insert after generated line i; -1 is the end of the file.
+	after := make(map[int][]manualSection)
+	for _, section := range sections {
+		idx := anchorEnd(lines, section.Anchor)
+		after[idx] = append(after[idx], section)
+	}
+
+	var out []string
+	for i, line := range lines {
+		out = append(out, line)
+		for _, section := range after[i] {
+			out = append(out, section.Lines...)
+		}
+	}
+	for _, section := range after[-1] {
+		out = append(out, "")
+		out = append(out, section.Lines...)
+	}
+	return strings.Join(out, "\n") + "\n"
+}
+
+// anchorEnd returns the index of the last line of the top-level statement named by anchor
+// (the closing brace for messages), or -1 if it doesn't exist in lines.
+func anchorEnd(lines []string, anchor string) int {
+	if anchor == "" {
+		anchor = "syntax"
+	}
+	found := -1
+	depth := 0
+	inAnchor := false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if depth == 0 && anchorKey(trimmed) == anchor {
+			found = i
+			inAnchor = true
+		}
+		depth += strings.Count(trimmed, "{") - strings.Count(trimmed, "}")
+		if inAnchor {
+			found = i
+			if depth == 0 {
+				inAnchor = false
+			}
+		}
+	}
+	return found
+}
@@ -0,0 +1,122 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/iancoleman/strcase"
+)
+
+// Naming derives proto names from Go names: of messages and enums from their Go type, of
+// fields from the Go field, and enum values from the constants' values. Names set in
+// annotations are kept as they are.
+type Naming interface {
+	MessageName(goName string) string
+	FieldName(goName string) string
+	EnumName(goName string) string
+	EnumValue(value string) string
+}
+
+// namingStyles are the built-in naming styles, also available as functions in the templates
+// of NewNaming.
+var namingStyles = map[string]func(string) string{
+	NamingOriginal:       func(name string) string { return name },
+	NamingSnake:          toProtoFieldName,
+	NamingLowerCamel:     strcase.ToLowerCamel,
+	NamingCamel:          strcase.ToCamel,
+	NamingScreamingSnake: strcase.ToScreamingSnake,
+}
+
+// templateNaming is the Naming built by NewNaming.
+type templateNaming struct {
+	key                             string
+	message, field, enum, enumValue func(string) string
+}
+
+func (n *templateNaming) MessageName(goName string) string { return n.message(goName) }
+func (n *templateNaming) FieldName(goName string) string   { return n.field(goName) }
+func (n *templateNaming) EnumName(goName string) string    { return n.enum(goName) }
+func (n *templateNaming) EnumValue(value string) string    { return n.enumValue(value) }
+
+// NewNaming returns the Naming applying a template to each kind of name. A template is one
+// of the naming styles, such as NamingSnake, or a text/template executed with the Go name
+// as dot and the styles as functions, e.g. "Api{{camel .}}". An empty template keeps the
+// default: message, enum and enum value names as they are, and snake_case fields.
+func NewNaming(message, field, enum, enumValue string) (Naming, error) {
+	n := &templateNaming{key: strings.Join([]string{message, field, enum, enumValue}, "\x00")}
+	for _, t := range []struct {
+		kind, text, fallback string
+		fn                   *func(string) string
+	}{
+		{"message", message, "", &n.message},
+		{"field", field, NamingSnake, &n.field},
+		{"enum", enum, "", &n.enum},
+		{"enum value", enumValue, "", &n.enumValue},
+	} {
+		text := t.text
+		if text == "" {
+			text = t.fallback
+		}
+		fn, err := namingFunc(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s naming: %w", t.kind, err)
+		}
+		*t.fn = fn
+	}
+	return n, nil
+}
+
+// namingFunc turns a style name or template into a function; "" keeps names unchanged.
+func namingFunc(text string) (func(string) string, error) {
+	if text == "" {
+		return func(name string) string { return name }, nil
+	}
+	if style, ok := namingStyles[text]; ok {
+		return style, nil
+	}
+	funcs := make(template.FuncMap, len(namingStyles))
+	for name, style := range namingStyles {
+		funcs[name] = style
+	}
+	tmpl, err := template.New("naming").Funcs(funcs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	// Run it once, so templates that can't execute fail here rather than for every name.
+	if err := tmpl.Execute(&strings.Builder{}, "Name"); err != nil {
+		return nil, err
+	}
+	return func(name string) string {
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, name); err != nil {
+			return name
+		}
+		return sb.String()
+	}, nil
+}
+
+// naming returns the Naming of the analysis: Naming if set, or the style of FieldNaming.
+func (o *Options) naming() Naming {
+	if o.Naming != nil {
+		return o.Naming
+	}
+	field := o.FieldNaming
+	if _, ok := namingStyles[field]; !ok {
+		field = ""
+	}
+	n, _ := NewNaming("", field, "", "")
+	return n
+}
+
+// namingKey identifies the naming of the analysis in cache keys. Naming implementations
+// other than NewNaming's can't be identified, so their analyses are not cached.
+func (o *Options) namingKey() (string, bool) {
+	if o.Naming == nil {
+		return "field=" + o.FieldNaming, true
+	}
+	if n, ok := o.Naming.(*templateNaming); ok {
+		return n.key, true
+	}
+	return "", false
+}
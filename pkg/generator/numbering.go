@@ -0,0 +1,147 @@
+package generator
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// Field numbering strategies.
+const (
+	// NumberingOrder numbers fields by their position in the Go struct.
+	NumberingOrder = "order"
+	// NumberingHash derives field numbers from a hash of the field name, so reordering
+	// the struct never changes them.
+	NumberingHash = "hash"
+)
+
+// Field number limits of the protobuf spec.
+const (
+	maxFieldNumber        = 1<<29 - 1
+	firstReservedFieldNum = 19000
+	lastReservedFieldNum  = 19999
+)
+
+// probedMarker starts the comment recording that a field's number was moved off its hash
+// because of a collision. Regenerating keeps such numbers, so the resolution is stable.
+const probedMarker = "go2proto:number-probed"
+
+// avoidPinnedNumbers moves the fields of msg numbered by their position onto the next
+// free number when a field pinned by its number tag already holds it.
+func avoidPinnedNumbers(msg *Message) {
+	taken := make(map[int]bool)
+	for _, fd := range msg.Fields {
+		taken[fd.Order] = taken[fd.Order] || fd.Pinned
+	}
+	for _, fd := range msg.Fields {
+		if fd.Pinned {
+			continue
+		}
+		for taken[fd.Order] {
+			fd.Order = nextFieldNumber(fd.Order)
+		}
+		taken[fd.Order] = true
+	}
+}
+
+// hashFieldNumber maps a field name onto the valid field number range.
+func hashFieldNumber(name string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	n := int(h.Sum32()%maxFieldNumber) + 1
+	if n >= firstReservedFieldNum && n <= lastReservedFieldNum {
+		n = lastReservedFieldNum + 1
+	}
+	return n
+}
+
+// nextFieldNumber returns the number probed after n, wrapping around and skipping the
+// range reserved by the protobuf implementation.
+func nextFieldNumber(n int) int {
+	n++
+	if n > maxFieldNumber {
+		n = 1
+	}
+	if n >= firstReservedFieldNum && n <= lastReservedFieldNum {
+		n = lastReservedFieldNum + 1
+	}
+	return n
+}
+
+// applyHashNumbering numbers the fields of every message in f by name hash, keeping the
+// numbers of the existing file's fields that already sit on their hash or on a recorded probe.
+func applyHashNumbering(f *File, opts *Options) {
+	previous := previousFieldNumbers(f.Path, opts)
+	for _, msg := range f.Messages {
+		assignHashNumbers(msg, previous[msg.Name])
+	}
+}
+
+// assignHashNumbers numbers msg's fields by name hash. Pinned fields and fields in keep retain
+// their number; the others take their hash, probing upwards in name order when it is already taken.
+func assignHashNumbers(msg *Message, keep map[string]int) {
+	taken := make(map[int]bool)
+	for _, fd := range msg.Fields {
+		taken[fd.Order] = taken[fd.Order] || fd.Pinned
+	}
+	var pending []*Field
+	for _, fd := range msg.Fields {
+		if fd.Pinned {
+			continue
+		}
+		fd.NumberNote = ""
+		if n, ok := keep[fd.Name]; ok && !taken[n] {
+			fd.Order = n
+			if n != hashFieldNumber(fd.Name) {
+				fd.NumberNote = fmt.Sprintf("%s from %d", probedMarker, hashFieldNumber(fd.Name))
+			}
+			taken[n] = true
+			continue
+		}
+		pending = append(pending, fd)
+	}
+
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Name < pending[j].Name })
+	for _, fd := range pending {
+		hash := hashFieldNumber(fd.Name)
+		n := hash
+		for taken[n] {
+			n = nextFieldNumber(n)
+		}
+		fd.Order = n
+		if n != hash {
+			fd.NumberNote = fmt.Sprintf("%s from %d", probedMarker, hash)
+		}
+		taken[n] = true
+	}
+}
+
+// previousFieldNumbers returns, per message, the fields of the file at path whose number
+// should be kept: those on their hash and those carrying a probedMarker comment.
+func previousFieldNumbers(path string, opts *Options) map[string]map[string]int {
+	result := make(map[string]map[string]int)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return result
+	}
+	parsed, err := parseProto(string(data))
+	if err != nil {
+		opts.verbosef("unable to parse %s for field numbers, renumbering: %s", path, err)
+		return result
+	}
+
+	lines := splitLines(string(data))
+	for _, msg := range parsed.Messages {
+		keep := make(map[string]int)
+		for _, fd := range msg.Fields {
+			probed := fd.Line >= 2 && strings.Contains(lines[fd.Line-2], probedMarker)
+			if probed || fd.Number == hashFieldNumber(fd.Name) {
+				keep[fd.Name] = fd.Number
+			}
+		}
+		result[msg.Name] = keep
+	}
+	return result
+}
@@ -0,0 +1,97 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// openAPIVersion is the OpenAPI release the -format openapi documents follow.
+const openAPIVersion = "3.0.3"
+
+// openAPIScalars maps proto scalar and well-known types to OpenAPI type and format.
+var openAPIScalars = map[string][2]string{
+	"double":                    {"number", "double"},
+	"float":                     {"number", "float"},
+	"int32":                     {"integer", "int32"},
+	"int64":                     {"integer", "int64"},
+	"uint32":                    {"integer", "int64"},
+	"uint64":                    {"integer", "int64"},
+	"bool":                      {"boolean", ""},
+	"string":                    {"string", ""},
+	"bytes":                     {"string", "byte"},
+	"google.protobuf.Timestamp": {"string", "date-time"},
+	"google.protobuf.Duration":  {"string", ""},
+	"google.protobuf.FieldMask": {"string", ""},
+}
+
+// renderOpenAPI renders the file's messages as the component schemas of an OpenAPI 3
+// document, in YAML if the file path ends in .yaml or .yml and in JSON otherwise.
+func renderOpenAPI(f *File) ([]byte, error) {
+	schemas := make(map[string]interface{}, len(f.Messages))
+	names := messageNames(f.Messages)
+	for _, msg := range f.Messages {
+		properties := make(map[string]interface{}, len(msg.Fields))
+		for _, fd := range msg.Fields {
+			properties[fd.Name] = openAPIField(fd, names)
+		}
+		schemas[msg.Name] = map[string]interface{}{
+			"type":       "object",
+			"properties": properties,
+		}
+	}
+	doc := map[string]interface{}{
+		"openapi":    openAPIVersion,
+		"info":       map[string]interface{}{"title": f.ProtoPackage, "version": "1.0.0"},
+		"paths":      map[string]interface{}{},
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+
+	switch strings.ToLower(filepath.Ext(f.Path)) {
+	case ".yaml", ".yml":
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(doc); err != nil {
+			return nil, err
+		}
+		if err := enc.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		out, err := json.MarshalIndent(doc, "", "  ")
+		return append(out, '\n'), err
+	}
+}
+
+// openAPIField returns the schema of a single field.
+func openAPIField(fd *Field, names map[string]string) map[string]interface{} {
+	schema := make(map[string]interface{})
+	switch {
+	case len(fd.EnumValues) > 0:
+		schema["type"] = "string"
+		schema["enum"] = fd.EnumValues
+	case names[fd.NamedType] != "":
+		schema["$ref"] = "#/components/schemas/" + names[fd.NamedType]
+	default:
+		scalar, ok := openAPIScalars[fd.TypeName]
+		if !ok {
+			scalar = [2]string{"object", ""}
+		}
+		schema["type"] = scalar[0]
+		if scalar[1] != "" {
+			schema["format"] = scalar[1]
+		}
+	}
+	switch {
+	case fd.IsRepeated:
+		return map[string]interface{}{"type": "array", "items": schema}
+	case fd.MapKey != "":
+		return map[string]interface{}{"type": "object", "additionalProperties": schema}
+	}
+	return schema
+}
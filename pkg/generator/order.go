@@ -0,0 +1,44 @@
+package generator
+
+import "sort"
+
+// orderByDependency sorts msgs so that every message follows the messages it references,
+// visiting references and otherwise unrelated messages in name order. Cycles are broken
+// at the message reached first.
+func orderByDependency(msgs []*Message) []*Message {
+	byGoName := make(map[string]*Message, len(msgs))
+	for _, msg := range msgs {
+		byGoName[msg.PkgPath+"."+msg.GoName] = msg
+	}
+	byName := func(list []*Message) {
+		sort.SliceStable(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	}
+
+	result := make([]*Message, 0, len(msgs))
+	visited := make(map[*Message]bool, len(msgs))
+	var visit func(msg *Message)
+	visit = func(msg *Message) {
+		if visited[msg] {
+			return
+		}
+		visited[msg] = true
+		var deps []*Message
+		for _, fd := range msg.Fields {
+			if dep, ok := byGoName[fd.NamedType]; ok && len(fd.EnumValues) == 0 {
+				deps = append(deps, dep)
+			}
+		}
+		byName(deps)
+		for _, dep := range deps {
+			visit(dep)
+		}
+		result = append(result, msg)
+	}
+
+	roots := append([]*Message(nil), msgs...)
+	byName(roots)
+	for _, msg := range roots {
+		visit(msg)
+	}
+	return result
+}
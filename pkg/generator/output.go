@@ -0,0 +1,755 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// File is a single generated .proto file.
+type File struct {
+	Path         string
+	GoPackage    string
+	ProtoPackage string
+	Imports      []string
+	// PublicImports are re-exported with import public, for umbrella files.
+	PublicImports []string
+	Messages      []*Message
+	// Services are emitted after the messages; only proto files and the formats of
+	// registered emitters have them.
+	Services []*Service
+	// Merge preserves the manual sections of the existing file (see ManualBegin).
+	Merge bool
+	// Append updates only this file's messages inside the existing file.
+	Append bool
+	// Backup keeps the previous version of the file as Path+".bak" when overwriting it.
+	Backup bool
+	// NoOverwrite fails rather than replacing an existing file.
+	NoOverwrite bool
+	// Snapshot is where the contents the file is generated with are kept, so the hand edits
+	// made to it since can be told apart in merge and append modes. Empty keeps none.
+	Snapshot string
+
+	// generated is the file as generated without the hand edits render merged in, if any.
+	generated []byte
+	// Mode is the permissions the file is written with, and its directories created with (see
+	// Options.FileMode); zero keeps those of the existing file.
+	Mode os.FileMode
+	// Format is the file's output format; empty means FormatProto.
+	Format string
+	// Enums are the model's enums. Proto files inline them as strings, listing the values,
+	// the declaration and the deprecation of each enum in comments above its fields.
+	Enums []*Enum
+	// GraphQLScalars overrides the scalar mapping of FormatGraphQL files.
+	GraphQLScalars map[string]string
+	// OptimizeFor is the optimize_for option of proto files; empty leaves it out.
+	OptimizeFor string
+	// Reproducible leaves the go2proto version out of the header.
+	Reproducible bool
+	// SourceComments points every message and field at its Go declaration.
+	SourceComments bool
+	// LineEnding is LineEndingCRLF to end lines with \r\n; anything else ends them with \n.
+	LineEnding string
+}
+
+// generatedBy names the tool in the file's "Code generated by" header.
+func (f *File) generatedBy() string {
+	if f.Reproducible {
+		return "go2proto"
+	}
+	return "go2proto " + Version()
+}
+
+// planOutputs splits the messages and services into one file per proto package and resolves
+// references to messages, qualifying and importing those that live in another file.
+// Messages in the default package go to path; every other package is written beside it
+// as <package>.proto. outputs maps Go import paths to the file their messages and services
+// go to instead.
+func planOutputs(msgs []*Message, services []*Service, path string, goPackageName string, protoPackageName string, outputs map[string]string) []*File {
+	packageOf := func(msg *Message) string {
+		if msg.Package != "" {
+			return msg.Package
+		}
+		return protoPackageName
+	}
+
+	type fileKey struct{ pkg, path string }
+	files := make(map[fileKey]*File)
+	fileFor := func(pkg, pkgPath string) *File {
+		key := fileKey{pkg: pkg, path: outputs[pkgPath]}
+		if key.path != "" {
+			// "api/users.proto" and "api\users.proto" are the same file on Windows.
+			key.path = filepath.Clean(key.path)
+		}
+		if f, ok := files[key]; ok {
+			return f
+		}
+		f := &File{
+			Path:         key.path,
+			GoPackage:    goPackageName,
+			ProtoPackage: pkg,
+		}
+		switch {
+		case f.Path != "":
+		case pkg == protoPackageName:
+			f.Path = path
+		default:
+			f.Path = filepath.Join(filepath.Dir(path), pkg+".proto")
+		}
+		files[key] = f
+		return f
+	}
+	// importPath is how the other files import f: relative to the directory of path, which
+	// the files are compiled from.
+	importPath := func(f *File) string {
+		if rel, err := filepath.Rel(filepath.Dir(path), f.Path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return filepath.ToSlash(rel)
+		}
+		return filepath.Base(f.Path)
+	}
+	// The default file is always written, even if every message moved elsewhere, unless
+	// the messages are mapped to files of their own.
+	if len(outputs) == 0 {
+		fileFor(protoPackageName, "")
+	}
+
+	byGoName := make(map[string]*Message, len(msgs))
+	for _, msg := range msgs {
+		byGoName[msg.PkgPath+"."+msg.GoName] = msg
+	}
+
+	// reference returns how f, in proto package pkg, refers to the message of Go type
+	// goType, importing its file if needed.
+	reference := func(f *File, pkg string, goType string) (string, bool) {
+		ref, ok := byGoName[goType]
+		if !ok {
+			return "", false
+		}
+		refPkg := packageOf(ref)
+		if refFile := fileFor(refPkg, ref.PkgPath); refFile != f {
+			f.addImport(importPath(refFile))
+		}
+		if refPkg == pkg {
+			return ref.Name, true
+		}
+		return refPkg + "." + ref.Name, true
+	}
+
+	for _, msg := range msgs {
+		pkg := packageOf(msg)
+		f := fileFor(pkg, msg.PkgPath)
+		f.Messages = append(f.Messages, msg)
+		if len(msg.Constraints) > 0 {
+			f.addImport(protovalidateImport)
+		}
+
+		for _, fd := range msg.Fields {
+			if len(fd.EnumValues) > 0 {
+				continue
+			}
+			switch fd.TypeName {
+			case "google.protobuf.Duration":
+				f.addImport(durationImport)
+			case "google.protobuf.FieldMask":
+				f.addImport(fieldMaskImport)
+			case "google.protobuf.Any":
+				f.addImport(anyImport)
+			case "google.protobuf.Struct", "google.protobuf.Value":
+				f.addImport(structImport)
+			}
+			if name, ok := reference(f, pkg, fd.NamedType); ok {
+				fd.TypeName = name
+			}
+		}
+	}
+
+	for _, svc := range services {
+		pkg := protoPackageName
+		if svc.Package != "" {
+			pkg = svc.Package
+		}
+		f := fileFor(pkg, svc.PkgPath)
+		f.Services = append(f.Services, svc)
+		for _, m := range svc.Methods {
+			if name, ok := reference(f, pkg, m.RequestType); ok {
+				m.Request = name
+			}
+			if name, ok := reference(f, pkg, m.ResponseType); ok {
+				m.Response = name
+			}
+		}
+	}
+
+	result := make([]*File, 0, len(files))
+	for _, f := range files {
+		sort.Strings(f.Imports)
+		result = append(result, f)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
+
+// addPublicImports makes the file at path re-export imports with import public, adding it to
+// files if no message went there.
+func addPublicImports(files []*File, imports []string, path, goPackageName, protoPackageName string) []*File {
+	var umbrella *File
+	for _, f := range files {
+		if f.Path == path {
+			umbrella = f
+		}
+	}
+	if umbrella == nil {
+		umbrella = &File{Path: path, GoPackage: goPackageName, ProtoPackage: protoPackageName}
+		files = append(files, umbrella)
+		sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	}
+
+	public := make(map[string]bool, len(imports))
+	for _, imp := range imports {
+		if !public[imp] {
+			public[imp] = true
+			umbrella.PublicImports = append(umbrella.PublicImports, imp)
+		}
+	}
+	sort.Strings(umbrella.PublicImports)
+	var kept []string
+	for _, imp := range umbrella.Imports {
+		if !public[imp] {
+			kept = append(kept, imp)
+		}
+	}
+	umbrella.Imports = kept
+	return files
+}
+
+// addExtraImports adds the imports of extra to the files, keyed by file path or "" for every
+// file, leaving out those the files already carry.
+func addExtraImports(files []*File, extra map[string][]string) {
+	byPath := make(map[string][]string, len(extra))
+	for path, imports := range extra {
+		if path != "" {
+			path = filepath.Clean(path)
+		}
+		byPath[path] = append(byPath[path], imports...)
+	}
+	for _, f := range files {
+		carried := make(map[string]bool)
+		for _, imp := range append(f.fileImports(), f.PublicImports...) {
+			carried[imp] = true
+		}
+		for _, imp := range append(byPath[""], byPath[filepath.Clean(f.Path)]...) {
+			if !carried[imp] {
+				carried[imp] = true
+				f.Imports = append(f.Imports, imp)
+			}
+		}
+		sort.Strings(f.Imports)
+	}
+}
+
+// addImport records an import once.
+func (f *File) addImport(path string) {
+	for _, existing := range f.Imports {
+		if existing == path {
+			return
+		}
+	}
+	f.Imports = append(f.Imports, path)
+}
+
+// protovalidateImport defines the (buf.validate.message) option of message constraints.
+const protovalidateImport = "buf/validate/validate.proto"
+
+// durationImport defines google.protobuf.Duration.
+const durationImport = "google/protobuf/duration.proto"
+
+// fieldMaskImport defines google.protobuf.FieldMask.
+const fieldMaskImport = "google/protobuf/field_mask.proto"
+
+// anyImport defines google.protobuf.Any.
+const anyImport = "google/protobuf/any.proto"
+
+// structImport defines google.protobuf.Struct and google.protobuf.Value.
+const structImport = "google/protobuf/struct.proto"
+
+// protoTemplate renders a whole file ("file"), a single message block ("message") or a
+// service block ("service").
+const protoTemplate = `{{define "file"}}// Code generated by {{.GeneratedBy}}. DO NOT EDIT.
+syntax = "proto3";
+
+option go_package = "{{.GoPackageName}}";
+{{- with .OptimizeFor}}
+option optimize_for = {{.}};
+{{- end}}
+import "google/protobuf/timestamp.proto";
+{{- range .Imports}}
+import "{{.}}";
+{{- end}}
+{{- range .PublicImports}}
+import public "{{.}}";
+{{- end}}
+
+package {{.ProtoPackageName}};
+
+{{range .Messages}}
+{{template "message" .}}
+{{end}}
+{{- range .Services}}
+{{template "service" .}}
+{{end}}
+{{end}}
+
+{{- define "service"}}service {{.Name}} {
+{{- range .Methods}}
+  rpc {{.Name}}({{.Request}}) returns ({{if .ServerStreaming}}stream {{end}}{{.Response}})
+{{- if .Idempotency}} {
+    option idempotency_level = {{.Idempotency}};
+  }
+{{- else}};{{end}}
+{{- end}}
+}{{end}}
+
+{{- define "message"}}{{with source .Pos}}{{.}}
+{{end}}message {{.Name}} {
+{{- if .Deprecated}}
+  option deprecated = true;
+{{- end}}
+{{- range .Options}}
+  option {{.}};
+{{- end}}
+{{- range .Constraints}}
+  option (buf.validate.message).cel = {
+    id: {{quote .ID}}
+{{- if .Message}}
+    message: {{quote .Message}}
+{{- end}}
+    expression: {{quote .Expression}}
+  };
+{{- end}}
+{{- range $i, $field := .Fields}}
+{{- if opensOneof $.Fields $i}}
+  oneof {{.Oneof}} {
+{{- end}}
+{{- with source .Pos}}
+{{.}}
+{{- end}}
+{{- if .EnumValues}}
+// possible values: {{ range $i, $val := .EnumValues }}{{if $i}}, {{end}}{{ $val }}{{ end }}
+{{- with $enum := enumOf .}}
+{{- with sourceLocation .Pos}}
+// source of enum {{$enum.Name}}: {{.}}
+{{- end}}
+{{- if .Deprecated}}
+// enum {{.Name}} is deprecated
+{{- end}}
+{{- end}}
+{{- end}}
+{{- if .Comment}}
+// {{.Comment}}
+{{- end}}
+{{- if .NumberNote}}
+// {{.NumberNote}}
+{{- end}}
+{{- if .IsRepeated}}
+  repeated {{.TypeName}} {{.Name}} = {{.Order}}{{template "fieldOptions" .}};
+{{- else if .MapKey}}
+  map<{{.MapKey}}, {{.TypeName}}> {{.Name}} = {{.Order}}{{template "fieldOptions" .}};
+{{- else}}
+  {{if .Oneof}}  {{end}}{{.TypeName}} {{.Name}} = {{.Order}}{{template "fieldOptions" .}};
+{{- end}}
+{{- if closesOneof $.Fields $i}}
+  }
+{{- end}}
+{{- end}}
+}{{end}}
+
+{{- define "fieldOptions"}}{{with enumOf .}}{{if .Deprecated}} [deprecated = true]{{end}}{{end}}{{end}}`
+
+// executeTemplate renders the named part of protoTemplate for f.
+func executeTemplate(name string, data interface{}, f *File) ([]byte, error) {
+	enums := enumsByGoName(f.Enums)
+	funcs := template.FuncMap{
+		"quote":          strconv.Quote,
+		"source":         f.sourceComment,
+		"sourceLocation": f.sourceLocation,
+		"opensOneof":     opensOneof,
+		"closesOneof":    closesOneof,
+		// enumOf returns the enum of a field inlined as a string, or nil.
+		"enumOf": func(fd *Field) *Enum {
+			if len(fd.EnumValues) == 0 {
+				return nil
+			}
+			return enums[fd.NamedType]
+		},
+	}
+	tmpl, err := template.New("proto-tmpl").Funcs(funcs).Parse(protoTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		return nil, fmt.Errorf("template.Execute error: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// opensOneof reports whether fields[i] is the first field of a oneof.
+func opensOneof(fields []*Field, i int) bool {
+	return fields[i].Oneof != "" && (i == 0 || fields[i-1].Oneof != fields[i].Oneof)
+}
+
+// closesOneof reports whether fields[i] is the last field of a oneof.
+func closesOneof(fields []*Field, i int) bool {
+	return fields[i].Oneof != "" && (i == len(fields)-1 || fields[i+1].Oneof != fields[i].Oneof)
+}
+
+// renderOutput produces the .proto file contents, omitting actual enum blocks, but adding comments above fields.
+func renderOutput(f *File) ([]byte, error) {
+	data := map[string]interface{}{
+		"GeneratedBy":      f.generatedBy(),
+		"GoPackageName":    f.GoPackage,
+		"ProtoPackageName": f.ProtoPackage,
+		"Imports":          f.Imports,
+		"PublicImports":    f.PublicImports,
+		"OptimizeFor":      f.OptimizeFor,
+		"Messages":         f.Messages,
+		"Services":         f.Services,
+	}
+	return executeTemplate("file", data, f)
+}
+
+// renderMessage produces the block of a single message of f, without a trailing newline.
+func renderMessage(msg *Message, f *File) ([]byte, error) {
+	return executeTemplate("message", msg, f)
+}
+
+// sourceComment returns the "// source: path:line" comment of a declaration at pos, or ""
+// unless SourceComments is set. The path is relative to the root of the declaring module,
+// so the output doesn't depend on where the sources are checked out.
+func (f *File) sourceComment(pos token.Position) string {
+	if loc := f.sourceLocation(pos); loc != "" {
+		return "// source: " + loc
+	}
+	return ""
+}
+
+// sourceLocation returns the path:line of a declaration at pos, as in sourceComment.
+func (f *File) sourceLocation(pos token.Position) string {
+	if !f.SourceComments || pos.Filename == "" {
+		return ""
+	}
+	path := filepath.Base(pos.Filename)
+	if root, ok := moduleRoot(filepath.Dir(pos.Filename)); ok {
+		if rel, err := filepath.Rel(root, pos.Filename); err == nil {
+			path = rel
+		}
+	}
+	return fmt.Sprintf("%s:%d", filepath.ToSlash(path), pos.Line)
+}
+
+// moduleRoot returns the closest directory at or above dir holding a go.mod file.
+func moduleRoot(dir string) (string, bool) {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// fileImports lists every import a rendered file carries.
+func (f *File) fileImports() []string {
+	return append([]string{"google/protobuf/timestamp.proto"}, f.Imports...)
+}
+
+// contents renders the file in its format, checking that proto output is valid, with the
+// file's line endings.
+func (f *File) contents() ([]byte, error) {
+	out, err := f.renderFormat()
+	if err != nil || f.LineEnding != LineEndingCRLF {
+		return out, err
+	}
+	return bytes.ReplaceAll(out, []byte("\n"), []byte("\r\n")), nil
+}
+
+// renderFormat renders the file in its format, with \n line endings.
+func (f *File) renderFormat() ([]byte, error) {
+	if render, ok := formatRenderers[f.Format]; ok {
+		return render(f)
+	}
+	if e, ok := registeredEmitter(f.Format); ok {
+		return f.emit(e)
+	}
+	out, err := f.render()
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyOutput(string(out)); err != nil {
+		return nil, fmt.Errorf("generated %s is not valid proto: %w", f.Path, err)
+	}
+	return out, nil
+}
+
+// render renders the file, carrying over the manual sections of the existing file in merge
+// mode, or updating only the generated messages inside it in append mode. If the existing
+// file was edited since it was last generated, the edits are merged in (see mergeEdits).
+func (f *File) render() ([]byte, error) {
+	out, err := renderOutput(f)
+	if err != nil || !(f.Merge || f.Append) {
+		return out, err
+	}
+
+	existing, err := ioutil.ReadFile(f.Path)
+	if os.IsNotExist(err) {
+		return out, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read file %s: %w", f.Path, err)
+	}
+	// Files checked out on Windows may have \r\n line endings, whatever the file's own.
+	existing = bytes.ReplaceAll(existing, []byte("\r\n"), []byte("\n"))
+	if base, ok := f.readSnapshot(); ok && base != string(existing) {
+		return f.mergeEdits(out, base, string(existing))
+	}
+	return f.update(out, string(existing))
+}
+
+// update applies out, the rendered file, to the existing file: carrying over its manual
+// sections in merge mode, or replacing only its generated messages in append mode.
+func (f *File) update(out []byte, existing string) ([]byte, error) {
+	if f.Append {
+		appended, err := appendToFile(existing, f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Path, err)
+		}
+		return []byte(appended), nil
+	}
+	sections, err := extractManualSections(existing)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f.Path, err)
+	}
+	return []byte(mergeManualSections(string(out), sections)), nil
+}
+
+// WriteTo renders the file to w.
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	out, err := f.contents()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(out)
+	return int64(n), err
+}
+
+// WriteFiles renders every file and writes it to its path.
+func WriteFiles(files []*File) error {
+	_, err := RefreshFiles(files)
+	return err
+}
+
+// RefreshFiles renders every file and writes those whose contents changed, returning them.
+// Files already up to date on disk are not touched, so their modification times stay
+// stable for build systems that rebuild on them.
+func RefreshFiles(files []*File) ([]*File, error) {
+	if err := checkPaths(files); err != nil {
+		return nil, err
+	}
+	if err := checkOverwrites(files); err != nil {
+		return nil, err
+	}
+	var refreshed []*File
+	for _, f := range files {
+		out, err := f.contents()
+		if err != nil {
+			return refreshed, err
+		}
+		if existing, err := ioutil.ReadFile(f.Path); err == nil && bytes.Equal(existing, out) {
+			if err := f.chmod(); err != nil {
+				return refreshed, fmt.Errorf("unable to change the mode of %s: %w", f.Path, err)
+			}
+			if err := f.saveSnapshot(out); err != nil {
+				return refreshed, fmt.Errorf("unable to store the snapshot of %s: %w", f.Path, err)
+			}
+			continue
+		}
+
+		if err := f.mkdir(); err != nil {
+			return refreshed, fmt.Errorf("failed to create directory structure: %w", err)
+		}
+		if err := f.write(out); err != nil {
+			return refreshed, fmt.Errorf("unable to write file %s: %w", f.Path, err)
+		}
+		if err := f.saveSnapshot(out); err != nil {
+			return refreshed, fmt.Errorf("unable to store the snapshot of %s: %w", f.Path, err)
+		}
+		refreshed = append(refreshed, f)
+	}
+	return refreshed, nil
+}
+
+// checkPaths fails if files of different proto packages share a path, which happens when
+// Options.Outputs maps Go packages of different proto packages to the same file.
+func checkPaths(files []*File) error {
+	packages := make(map[string]string, len(files))
+	for _, f := range files {
+		path := filepath.Clean(f.Path)
+		if other, ok := packages[path]; ok && other != f.ProtoPackage {
+			pkgs := []string{other, f.ProtoPackage}
+			sort.Strings(pkgs)
+			return fmt.Errorf("%s would hold the messages of proto packages %s and %s, map them to different files", f.Path, pkgs[0], pkgs[1])
+		}
+		packages[path] = f.ProtoPackage
+	}
+	return nil
+}
+
+// checkOverwrites fails if a file with NoOverwrite set already exists, before any file is
+// written.
+func checkOverwrites(files []*File) error {
+	for _, f := range files {
+		if !f.NoOverwrite {
+			continue
+		}
+		if _, err := os.Lstat(f.Path); err == nil {
+			return fmt.Errorf("%s already exists, not overwriting it", f.Path)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// write replaces the file atomically: out goes to a temporary file in the same directory
+// which is then renamed over Path, so a failure never leaves a truncated file behind.
+// Existing files keep their mode and new ones get 0644 less the umask, unless Mode is set.
+func (f *File) write(out []byte) error {
+	var mode os.FileMode
+	if info, err := os.Stat(f.Path); err == nil {
+		mode = info.Mode().Perm()
+		if f.Backup {
+			previous, err := ioutil.ReadFile(f.Path)
+			if err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(f.Path+".bak", previous, mode); err != nil {
+				return err
+			}
+		}
+	}
+	if f.Mode != 0 {
+		mode = f.Mode
+	}
+
+	tmp, err := createTemp(filepath.Dir(f.Path), "."+filepath.Base(f.Path)+".tmp", 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(out); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if mode != 0 {
+		if err := os.Chmod(tmp.Name(), mode); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp.Name(), f.Path)
+}
+
+// createTemp creates a new file in dir named prefix followed by a random number, like
+// ioutil.TempFile, but with perm less the umask rather than 0600.
+func createTemp(dir, prefix string, perm os.FileMode) (*os.File, error) {
+	for try := 0; ; try++ {
+		name := filepath.Join(dir, prefix+strconv.FormatUint(uint64(rand.Uint32()), 10))
+		file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if os.IsExist(err) && try < 10000 {
+			continue
+		}
+		return file, err
+	}
+}
+
+// chmod gives the up to date file its Mode, if set.
+func (f *File) chmod() error {
+	if f.Mode == 0 {
+		return nil
+	}
+	info, err := os.Stat(f.Path)
+	if err != nil || info.Mode().Perm() == f.Mode {
+		return err
+	}
+	return os.Chmod(f.Path, f.Mode)
+}
+
+// mkdir creates the missing directories of the file's path. With Mode set they are given
+// its directory mode (see dirMode) regardless of the umask.
+func (f *File) mkdir() error {
+	dir := filepath.Dir(f.Path)
+	if f.Mode == 0 {
+		return os.MkdirAll(dir, 0755)
+	}
+	return mkdirAll(dir, dirMode(f.Mode))
+}
+
+// mkdirAll creates dir and its missing parents with mode, which unlike os.MkdirAll is not
+// reduced by the umask. Existing directories are left as they are.
+func mkdirAll(dir string, mode os.FileMode) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := mkdirAll(parent, mode); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dir, mode); err != nil {
+		return err
+	}
+	return os.Chmod(dir, mode)
+}
+
+// dirMode returns the mode of directories holding files of mode: mode, searchable by
+// whoever may read it, so 0644 gives 0755 and 0640 gives 0750.
+func dirMode(mode os.FileMode) os.FileMode {
+	return mode | (mode&0444)>>2
+}
+
+// CheckFiles renders every file in memory and returns a unified diff against the files on disk.
+// An empty diff means all files are up to date.
+func CheckFiles(files []*File) (string, error) {
+	if err := checkPaths(files); err != nil {
+		return "", err
+	}
+	var diffs strings.Builder
+	for _, f := range files {
+		out, err := f.contents()
+		if err != nil {
+			return "", err
+		}
+
+		existing, err := ioutil.ReadFile(f.Path)
+		if err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("unable to read file %s: %w", f.Path, err)
+		}
+		diffs.WriteString(unifiedDiff(f.Path, f.Path+" (generated)", string(existing), string(out)))
+	}
+	return diffs.String(), nil
+}
@@ -0,0 +1,158 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+
+	"github.com/iancoleman/strcase"
+)
+
+// protoGoTypes maps proto scalar and well-known types to Go types.
+var protoGoTypes = map[string]string{
+	"double":   "float64",
+	"float":    "float32",
+	"int32":    "int32",
+	"sint32":   "int32",
+	"sfixed32": "int32",
+	"int64":    "int64",
+	"sint64":   "int64",
+	"sfixed64": "int64",
+	"uint32":   "uint32",
+	"fixed32":  "uint32",
+	"uint64":   "uint64",
+	"fixed64":  "uint64",
+	"bool":     "bool",
+	"string":   "string",
+	"bytes":    "[]byte",
+
+	"google.protobuf.Timestamp": "time.Time",
+	"google.protobuf.Duration":  "time.Duration",
+	"google.protobuf.Struct":    "map[string]interface{}",
+	"google.protobuf.Value":     "interface{}",
+}
+
+// ProtoToGo parses a .proto file and returns Go source declaring an annotated struct for
+// every message and a string type with constants for every enum, in package goPackage.
+// Each field carries a go2proto:"name=...,number=..." tag keeping its proto name and number.
+// Nested definitions are flattened to ParentChild.
+func ProtoToGo(src []byte, goPackage string) ([]byte, error) {
+	parsed, err := parseProto(string(src))
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []*protoMessage
+	var enums []*protoEnum
+	// names maps dotted proto names ("Order.Line") to flattened Go names, and scopes
+	// each flattened message to its dotted name, for resolving nested references.
+	names := make(map[string]string)
+	scopes := make(map[string]string)
+	var flatten func(prefix, scope string, m *protoMessage)
+	flatten = func(prefix, scope string, m *protoMessage) {
+		names[scope+m.Name] = prefix + m.Name
+		scopes[prefix+m.Name] = scope + m.Name
+		msgs = append(msgs, &protoMessage{Name: prefix + m.Name, Fields: m.Fields})
+		for _, e := range m.Enums {
+			names[scope+m.Name+"."+e.Name] = prefix + m.Name + e.Name
+			enums = append(enums, &protoEnum{Name: prefix + m.Name + e.Name, Values: e.Values})
+		}
+		for _, nested := range m.Messages {
+			flatten(prefix+m.Name, scope+m.Name+".", nested)
+		}
+	}
+	for _, e := range parsed.Enums {
+		names[e.Name] = e.Name
+		enums = append(enums, e)
+	}
+	for _, m := range parsed.Messages {
+		flatten("", "", m)
+	}
+
+	// goType resolves a proto type referenced from the message with the given dotted name,
+	// searching its enclosing scopes from the innermost out like protoc does.
+	usesTime := false
+	goType := func(typ, scope string) string {
+		if t, ok := protoGoTypes[typ]; ok {
+			usesTime = usesTime || strings.HasPrefix(t, "time.")
+			return t
+		}
+		if strings.HasPrefix(typ, ".") {
+			scope = ""
+		}
+		typ = strings.TrimPrefix(typ, ".")
+		typ = strings.TrimPrefix(typ, parsed.Package+".")
+		for {
+			candidate := typ
+			if scope != "" {
+				candidate = scope + "." + typ
+			}
+			if name, ok := names[candidate]; ok {
+				return name
+			}
+			if scope == "" {
+				break
+			}
+			if i := strings.LastIndex(scope, "."); i >= 0 {
+				scope = scope[:i]
+			} else {
+				scope = ""
+			}
+		}
+		// A type from another file: assume it is converted under its own name.
+		return typ[strings.LastIndex(typ, ".")+1:]
+	}
+	isEnum := make(map[string]bool)
+	for _, e := range enums {
+		isEnum[e.Name] = true
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Converted from proto by go2proto proto2go %s.\n\n", Version())
+	fmt.Fprintf(&b, "package %s\n\n", goPackage)
+	body := &bytes.Buffer{}
+
+	for _, e := range enums {
+		fmt.Fprintf(body, "// %s\ntype %s string\n\nconst (\n", annotationMarker, e.Name)
+		prefix := strcase.ToScreamingSnake(e.Name) + "_"
+		for _, v := range e.Values {
+			constName := strcase.ToCamel(strings.ToLower(strings.TrimPrefix(v.Name, prefix)))
+			fmt.Fprintf(body, "\t%s%s %s = %q\n", e.Name, constName, e.Name, v.Name)
+		}
+		body.WriteString(")\n\n")
+	}
+
+	for _, m := range msgs {
+		fmt.Fprintf(body, "// %s\ntype %s struct {\n", annotationMarker, m.Name)
+		fields := append([]*protoField(nil), m.Fields...)
+		sort.SliceStable(fields, func(i, j int) bool { return fields[i].Number < fields[j].Number })
+		for _, fd := range fields {
+			var typ string
+			switch {
+			case fd.KeyType != "":
+				typ = "map[" + goType(fd.KeyType, scopes[m.Name]) + "]" + goType(fd.ValueType, scopes[m.Name])
+			default:
+				typ = goType(fd.Type, scopes[m.Name])
+				if _, scalar := protoGoTypes[fd.Type]; !scalar && !isEnum[typ] {
+					typ = "*" + typ
+				}
+				if fd.Label == "repeated" {
+					typ = "[]" + typ
+				}
+			}
+			if fd.Oneof != "" {
+				fmt.Fprintf(body, "\t// oneof %s\n", fd.Oneof)
+			}
+			fmt.Fprintf(body, "\t%s %s `go2proto:\"name=%s,number=%d\"`\n", strcase.ToCamel(fd.Name), typ, fd.Name, fd.Number)
+		}
+		body.WriteString("}\n\n")
+	}
+
+	if usesTime {
+		b.WriteString("import \"time\"\n\n")
+	}
+	b.Write(body.Bytes())
+	return format.Source(b.Bytes())
+}
@@ -0,0 +1,908 @@
+package generator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// protoFile is the parsed form of a .proto file. Only the parts go2proto needs to read back
+// are modelled; everything else (extensions, aggregate option values) is skipped over.
+type protoFile struct {
+	Syntax   string
+	Package  string
+	Imports  []*protoImport
+	Options  []*protoOption
+	Messages []*protoMessage
+	Enums    []*protoEnum
+	Services []*protoService
+	// HeaderEnd is the offset just past the last syntax, package, import or option statement.
+	HeaderEnd int
+}
+
+// protoSpan locates a top-level definition in the source: byte offsets with End exclusive.
+// Start includes the comment lines directly above the definition.
+type protoSpan struct {
+	Start, End int
+}
+
+type protoImport struct {
+	Path   string
+	Public bool
+	Weak   bool
+}
+
+type protoOption struct {
+	Name  string
+	Value string
+}
+
+type protoMessage struct {
+	Name     string
+	Span     protoSpan
+	Fields   []*protoField
+	Messages []*protoMessage
+	Enums    []*protoEnum
+	Reserved protoReserved
+	Options  []*protoOption
+}
+
+type protoField struct {
+	Name   string
+	Type   string
+	Label  string // "repeated", "optional", "required" or ""
+	Number int
+	// KeyType and ValueType are set for map fields, whose Type is "map<key, value>".
+	KeyType   string
+	ValueType string
+	Oneof     string
+	Options   []*protoOption
+	Line      int
+}
+
+type protoEnum struct {
+	Name     string
+	Span     protoSpan
+	Values   []*protoEnumValue
+	Reserved protoReserved
+	Options  []*protoOption
+}
+
+type protoEnumValue struct {
+	Name   string
+	Number int
+}
+
+type protoService struct {
+	Name    string
+	Span    protoSpan
+	Methods []*protoMethod
+	Options []*protoOption
+}
+
+type protoMethod struct {
+	Name            string
+	Input           string
+	Output          string
+	ClientStreaming bool
+	ServerStreaming bool
+	Options         []*protoOption
+}
+
+// protoReserved lists reserved field numbers (as inclusive ranges) and names.
+type protoReserved struct {
+	Ranges [][2]int
+	Names  []string
+}
+
+// hasNumber reports whether n is reserved.
+func (r protoReserved) hasNumber(n int) bool {
+	for _, rng := range r.Ranges {
+		if n >= rng[0] && n <= rng[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// hasName reports whether name is reserved.
+func (r protoReserved) hasName(name string) bool {
+	for _, n := range r.Names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// message returns the top-level message called name, or nil.
+func (f *protoFile) message(name string) *protoMessage {
+	for _, m := range f.Messages {
+		if m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// protoSyntaxError is a parse error at a position of the source; Line 0 means the end of the file.
+type protoSyntaxError struct {
+	Line, Col int
+	Msg       string
+}
+
+func (e *protoSyntaxError) Error() string {
+	if e.Line == 0 {
+		return "unexpected end of file: " + e.Msg
+	}
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Col, e.Msg)
+}
+
+// syntaxErrorf returns a protoSyntaxError at line:col.
+func syntaxErrorf(line, col int, format string, args ...interface{}) error {
+	return &protoSyntaxError{Line: line, Col: col, Msg: fmt.Sprintf(format, args...)}
+}
+
+// protoToken is a lexical token of a .proto file.
+type protoToken struct {
+	Kind  byte // 'i' identifier, 'n' number, 's' string, otherwise the symbol itself
+	Text  string
+	Pos   int // byte offset
+	Line  int
+	Col   int
+	Value string // unquoted value for strings
+	// CommentStart is the offset of the comment block directly above the token, or -1.
+	CommentStart int
+}
+
+// tokenizeProto splits src into tokens, dropping comments but remembering where the comment
+// block directly above each token begins. Trailing comments and comments separated from the
+// next token by a blank line are not attached.
+func tokenizeProto(src string) ([]protoToken, error) {
+	var tokens []protoToken
+	line, col := 1, 1
+	commentStart := -1
+	lineHasToken := false   // a token was seen on the current line
+	lineHasContent := false // a token or comment was seen on the current line
+
+	advance := func(i, n int) {
+		for k := i; k < i+n && k < len(src); k++ {
+			if src[k] == '\n' {
+				line++
+				col = 1
+			} else {
+				col++
+			}
+		}
+	}
+	emit := func(kind byte, i, j int, value string) {
+		tokens = append(tokens, protoToken{Kind: kind, Text: src[i:j], Value: value, Pos: i, Line: line, Col: col, CommentStart: commentStart})
+		commentStart = -1
+		lineHasToken = true
+		lineHasContent = true
+		advance(i, j-i)
+	}
+	comment := func(i, n int) {
+		if !lineHasToken && commentStart < 0 {
+			commentStart = lineStart(src, i)
+		}
+		lineHasContent = true
+		advance(i, n)
+	}
+
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			if !lineHasContent {
+				// A blank line detaches the comments above it from the next token.
+				commentStart = -1
+			}
+			lineHasToken, lineHasContent = false, false
+			advance(i, 1)
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			advance(i, 1)
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			end := strings.IndexByte(src[i:], '\n')
+			if end < 0 {
+				end = len(src) - i
+			}
+			comment(i, end)
+			i += end
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, syntaxErrorf(line, col, "unterminated block comment")
+			}
+			comment(i, end+4)
+			i += end + 4
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(src) && src[j] != c && src[j] != '\n' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) || src[j] != c {
+				return nil, syntaxErrorf(line, col, "unterminated string")
+			}
+			text := src[i : j+1]
+			value, err := strconv.Unquote(`"` + strings.Replace(text[1:len(text)-1], `"`, `\"`, -1) + `"`)
+			if err != nil {
+				value = text[1 : len(text)-1]
+			}
+			emit('s', i, j+1, value)
+			i = j + 1
+		case isProtoIdentStart(c) || isDigit(c) || c == '.' && i+1 < len(src) && isProtoIdentStart(src[i+1]) ||
+			c == '-' && i+1 < len(src) && isDigit(src[i+1]):
+			// Identifiers may be dotted (and fully-qualified ones start with a dot).
+			j := i + 1
+			for j < len(src) && (isProtoIdentStart(src[j]) || isDigit(src[j]) || src[j] == '.') {
+				j++
+			}
+			kind := byte('i')
+			if isDigit(c) || c == '-' {
+				kind = 'n'
+			}
+			emit(kind, i, j, "")
+			i = j
+		case strings.IndexByte("{}()[]<>;=,:-+/", c) >= 0:
+			emit(c, i, i+1, "")
+			i++
+		default:
+			return nil, syntaxErrorf(line, col, "unexpected character %q", c)
+		}
+	}
+	return tokens, nil
+}
+
+// lineStart returns the offset of the beginning of the line containing offset i.
+func lineStart(src string, i int) int {
+	return strings.LastIndexByte(src[:i], '\n') + 1
+}
+
+func isProtoIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// protoParser is a recursive-descent parser over the tokens of a .proto file.
+type protoParser struct {
+	src    string
+	tokens []protoToken
+	pos    int
+}
+
+// parseProto parses the source of a .proto file.
+func parseProto(src string) (*protoFile, error) {
+	tokens, err := tokenizeProto(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &protoParser{src: src, tokens: tokens}
+	return p.parseFile()
+}
+
+// peek returns the current token, or a zero token at the end of input.
+func (p *protoParser) peek() protoToken {
+	if p.pos >= len(p.tokens) {
+		return protoToken{Pos: len(p.src), CommentStart: -1}
+	}
+	return p.tokens[p.pos]
+}
+
+// peekAt returns the token n positions ahead of the current one.
+func (p *protoParser) peekAt(n int) protoToken {
+	if p.pos+n >= len(p.tokens) {
+		return protoToken{Pos: len(p.src), CommentStart: -1}
+	}
+	return p.tokens[p.pos+n]
+}
+
+func (p *protoParser) next() protoToken {
+	t := p.peek()
+	if p.pos < len(p.tokens) {
+		p.pos++
+	}
+	return t
+}
+
+// errorf formats an error at the current token's position.
+func (p *protoParser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	if t.Line == 0 {
+		return syntaxErrorf(0, 0, format, args...)
+	}
+	return syntaxErrorf(t.Line, t.Col, format, args...)
+}
+
+// expect consumes a token with the given text.
+func (p *protoParser) expect(text string) (protoToken, error) {
+	t := p.peek()
+	if t.Text != text {
+		return t, p.errorf("expected %q, found %q", text, t.Text)
+	}
+	return p.next(), nil
+}
+
+// ident consumes an identifier (possibly dotted).
+func (p *protoParser) ident() (string, error) {
+	t := p.peek()
+	if t.Kind != 'i' {
+		return "", p.errorf("expected identifier, found %q", t.Text)
+	}
+	p.next()
+	return t.Text, nil
+}
+
+// number consumes an integer literal.
+func (p *protoParser) number() (int, error) {
+	t := p.peek()
+	if t.Kind != 'n' {
+		if t.Text == "max" {
+			p.next()
+			return 536870911, nil
+		}
+		return 0, p.errorf("expected number, found %q", t.Text)
+	}
+	p.next()
+	n, err := strconv.ParseInt(t.Text, 0, 64)
+	if err != nil {
+		return 0, syntaxErrorf(t.Line, t.Col, "invalid number %q", t.Text)
+	}
+	return int(n), nil
+}
+
+// spanFrom returns the span from the definition starting at token start to the current position.
+func (p *protoParser) spanFrom(start protoToken) protoSpan {
+	begin := start.Pos
+	if start.CommentStart >= 0 {
+		begin = start.CommentStart
+	}
+	end := len(p.src)
+	if p.pos > 0 {
+		last := p.tokens[p.pos-1]
+		end = last.Pos + len(last.Text)
+	}
+	return protoSpan{Start: begin, End: end}
+}
+
+func (p *protoParser) parseFile() (*protoFile, error) {
+	f := &protoFile{}
+	for p.pos < len(p.tokens) {
+		t := p.peek()
+		header := false
+		switch t.Text {
+		case "syntax", "edition":
+			header = true
+			p.next()
+			if _, err := p.expect("="); err != nil {
+				return nil, err
+			}
+			s := p.next()
+			if s.Kind != 's' {
+				return nil, syntaxErrorf(s.Line, s.Col, "expected string after %s", t.Text)
+			}
+			f.Syntax = s.Value
+			if _, err := p.expect(";"); err != nil {
+				return nil, err
+			}
+		case "package":
+			header = true
+			p.next()
+			name, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			f.Package = name
+			if _, err := p.expect(";"); err != nil {
+				return nil, err
+			}
+		case "import":
+			header = true
+			p.next()
+			imp := &protoImport{}
+			switch p.peek().Text {
+			case "public":
+				imp.Public = true
+				p.next()
+			case "weak":
+				imp.Weak = true
+				p.next()
+			}
+			s := p.next()
+			if s.Kind != 's' {
+				return nil, syntaxErrorf(s.Line, s.Col, "expected import path")
+			}
+			imp.Path = s.Value
+			f.Imports = append(f.Imports, imp)
+			if _, err := p.expect(";"); err != nil {
+				return nil, err
+			}
+		case "option":
+			header = true
+			opt, err := p.parseOptionStatement()
+			if err != nil {
+				return nil, err
+			}
+			f.Options = append(f.Options, opt)
+		case "message":
+			m, err := p.parseMessage()
+			if err != nil {
+				return nil, err
+			}
+			f.Messages = append(f.Messages, m)
+		case "enum":
+			e, err := p.parseEnum()
+			if err != nil {
+				return nil, err
+			}
+			f.Enums = append(f.Enums, e)
+		case "service":
+			s, err := p.parseService()
+			if err != nil {
+				return nil, err
+			}
+			f.Services = append(f.Services, s)
+		case "extend":
+			if err := p.skipDefinition(); err != nil {
+				return nil, err
+			}
+		case ";":
+			p.next()
+		default:
+			return nil, p.errorf("unexpected %q", t.Text)
+		}
+		if header {
+			f.HeaderEnd = p.spanFrom(t).End
+		}
+	}
+	return f, nil
+}
+
+// parseOptionStatement parses `option name = value;`.
+func (p *protoParser) parseOptionStatement() (*protoOption, error) {
+	if _, err := p.expect("option"); err != nil {
+		return nil, err
+	}
+	opt, err := p.parseOptionBody(";")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(";"); err != nil {
+		return nil, err
+	}
+	return opt, nil
+}
+
+// parseOptionBody parses `name = value`, stopping before terminator.
+func (p *protoParser) parseOptionBody(terminator string) (*protoOption, error) {
+	var name strings.Builder
+	for p.peek().Text != "=" {
+		t := p.next()
+		if t.Line == 0 || t.Text == terminator {
+			return nil, p.errorf("expected \"=\" in option")
+		}
+		name.WriteString(t.Text)
+	}
+	p.next()
+
+	start := p.peek()
+	if start.Text == "{" {
+		// Aggregate (text format) value: keep the raw source.
+		depth := 0
+		for {
+			t := p.next()
+			if t.Line == 0 {
+				return nil, p.errorf("unterminated option value")
+			}
+			if t.Text == "{" {
+				depth++
+			} else if t.Text == "}" {
+				depth--
+				if depth == 0 {
+					return &protoOption{Name: name.String(), Value: p.src[start.Pos : t.Pos+1]}, nil
+				}
+			}
+		}
+	}
+
+	var value strings.Builder
+	for p.peek().Text != terminator && p.peek().Text != "," && p.peek().Text != "]" {
+		t := p.next()
+		if t.Line == 0 {
+			return nil, p.errorf("unterminated option")
+		}
+		if t.Kind == 's' && value.Len() > 0 {
+			// Adjacent string literals are concatenated.
+			value.WriteString(t.Text)
+			continue
+		}
+		value.WriteString(t.Text)
+	}
+	return &protoOption{Name: name.String(), Value: value.String()}, nil
+}
+
+// parseFieldOptions parses an optional `[a = b, c = d]` list.
+func (p *protoParser) parseFieldOptions() ([]*protoOption, error) {
+	if p.peek().Text != "[" {
+		return nil, nil
+	}
+	p.next()
+	var opts []*protoOption
+	for {
+		opt, err := p.parseOptionBody("]")
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+		t := p.next()
+		if t.Text == "]" {
+			return opts, nil
+		}
+		if t.Text != "," {
+			return nil, syntaxErrorf(t.Line, t.Col, "expected \",\" or \"]\" in field options")
+		}
+	}
+}
+
+func (p *protoParser) parseMessage() (*protoMessage, error) {
+	start, _ := p.expect("message")
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	m := &protoMessage{Name: name}
+	if _, err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	if err := p.parseMessageBody(m, ""); err != nil {
+		return nil, err
+	}
+	m.Span = p.spanFrom(start)
+	return m, nil
+}
+
+// parseMessageBody parses message elements up to and including the closing brace.
+// oneof is the name of the enclosing oneof, if any.
+func (p *protoParser) parseMessageBody(m *protoMessage, oneof string) error {
+	for {
+		t := p.peek()
+		switch t.Text {
+		case "}":
+			p.next()
+			return nil
+		case "":
+			return p.errorf("unterminated message %s", m.Name)
+		case ";":
+			p.next()
+		case "option":
+			opt, err := p.parseOptionStatement()
+			if err != nil {
+				return err
+			}
+			m.Options = append(m.Options, opt)
+		case "message":
+			if oneof != "" {
+				return p.errorf("message not allowed in oneof")
+			}
+			nested, err := p.parseMessage()
+			if err != nil {
+				return err
+			}
+			m.Messages = append(m.Messages, nested)
+		case "enum":
+			nested, err := p.parseEnum()
+			if err != nil {
+				return err
+			}
+			m.Enums = append(m.Enums, nested)
+		case "oneof":
+			p.next()
+			name, err := p.ident()
+			if err != nil {
+				return err
+			}
+			if _, err := p.expect("{"); err != nil {
+				return err
+			}
+			if err := p.parseMessageBody(m, name); err != nil {
+				return err
+			}
+		case "reserved":
+			if err := p.parseReserved(&m.Reserved); err != nil {
+				return err
+			}
+		case "extensions", "extend":
+			if err := p.skipDefinition(); err != nil {
+				return err
+			}
+		default:
+			fd, err := p.parseField()
+			if err != nil {
+				return err
+			}
+			fd.Oneof = oneof
+			m.Fields = append(m.Fields, fd)
+		}
+	}
+}
+
+// parseField parses a normal or map field.
+func (p *protoParser) parseField() (*protoField, error) {
+	start := p.peek()
+	fd := &protoField{Line: start.Line}
+	switch start.Text {
+	case "repeated", "optional", "required":
+		fd.Label = start.Text
+		p.next()
+	}
+
+	if p.peek().Text == "map" && p.peekAt(1).Text == "<" {
+		p.next()
+		p.next()
+		key, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(","); err != nil {
+			return nil, err
+		}
+		value, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(">"); err != nil {
+			return nil, err
+		}
+		fd.KeyType, fd.ValueType = key, value
+		fd.Type = "map<" + key + ", " + value + ">"
+	} else {
+		typ, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		fd.Type = typ
+	}
+
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	fd.Name = name
+	if _, err := p.expect("="); err != nil {
+		return nil, err
+	}
+	if fd.Number, err = p.number(); err != nil {
+		return nil, err
+	}
+	if fd.Options, err = p.parseFieldOptions(); err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(";"); err != nil {
+		return nil, err
+	}
+	return fd, nil
+}
+
+// parseReserved parses `reserved 1, 2 to 5;` or `reserved "a", "b";`.
+func (p *protoParser) parseReserved(r *protoReserved) error {
+	p.next()
+	for {
+		t := p.peek()
+		switch {
+		case t.Kind == 's':
+			p.next()
+			r.Names = append(r.Names, t.Value)
+		case t.Kind == 'i' && t.Text != "to":
+			// Editions-style reserved identifiers.
+			p.next()
+			r.Names = append(r.Names, t.Text)
+		default:
+			from, err := p.number()
+			if err != nil {
+				return err
+			}
+			to := from
+			if p.peek().Text == "to" {
+				p.next()
+				if to, err = p.number(); err != nil {
+					return err
+				}
+			}
+			r.Ranges = append(r.Ranges, [2]int{from, to})
+		}
+		t = p.next()
+		if t.Text == ";" {
+			return nil
+		}
+		if t.Text != "," {
+			return syntaxErrorf(t.Line, t.Col, "expected \",\" or \";\" in reserved")
+		}
+	}
+}
+
+func (p *protoParser) parseEnum() (*protoEnum, error) {
+	start, _ := p.expect("enum")
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	e := &protoEnum{Name: name}
+	if _, err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		switch t.Text {
+		case "}":
+			p.next()
+			e.Span = p.spanFrom(start)
+			return e, nil
+		case "":
+			return nil, p.errorf("unterminated enum %s", name)
+		case ";":
+			p.next()
+		case "option":
+			opt, err := p.parseOptionStatement()
+			if err != nil {
+				return nil, err
+			}
+			e.Options = append(e.Options, opt)
+		case "reserved":
+			if err := p.parseReserved(&e.Reserved); err != nil {
+				return nil, err
+			}
+		default:
+			valueName, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect("="); err != nil {
+				return nil, err
+			}
+			n, err := p.number()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.parseFieldOptions(); err != nil {
+				return nil, err
+			}
+			if _, err := p.expect(";"); err != nil {
+				return nil, err
+			}
+			e.Values = append(e.Values, &protoEnumValue{Name: valueName, Number: n})
+		}
+	}
+}
+
+func (p *protoParser) parseService() (*protoService, error) {
+	start, _ := p.expect("service")
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	s := &protoService{Name: name}
+	if _, err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		switch t.Text {
+		case "}":
+			p.next()
+			s.Span = p.spanFrom(start)
+			return s, nil
+		case "":
+			return nil, p.errorf("unterminated service %s", name)
+		case ";":
+			p.next()
+		case "option":
+			opt, err := p.parseOptionStatement()
+			if err != nil {
+				return nil, err
+			}
+			s.Options = append(s.Options, opt)
+		case "rpc":
+			m, err := p.parseMethod()
+			if err != nil {
+				return nil, err
+			}
+			s.Methods = append(s.Methods, m)
+		default:
+			return nil, p.errorf("unexpected %q in service %s", t.Text, name)
+		}
+	}
+}
+
+// parseMethod parses `rpc Name (stream In) returns (stream Out) {...}` or `...;`.
+func (p *protoParser) parseMethod() (*protoMethod, error) {
+	p.next()
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	m := &protoMethod{Name: name}
+
+	parseType := func() (string, bool, error) {
+		if _, err := p.expect("("); err != nil {
+			return "", false, err
+		}
+		stream := false
+		if p.peek().Text == "stream" && p.peekAt(1).Text != ")" {
+			stream = true
+			p.next()
+		}
+		typ, err := p.ident()
+		if err != nil {
+			return "", false, err
+		}
+		if _, err := p.expect(")"); err != nil {
+			return "", false, err
+		}
+		return typ, stream, nil
+	}
+
+	if m.Input, m.ClientStreaming, err = parseType(); err != nil {
+		return nil, err
+	}
+	if _, err := p.expect("returns"); err != nil {
+		return nil, err
+	}
+	if m.Output, m.ServerStreaming, err = parseType(); err != nil {
+		return nil, err
+	}
+
+	if p.peek().Text == "{" {
+		p.next()
+		for p.peek().Text != "}" {
+			switch p.peek().Text {
+			case "":
+				return nil, p.errorf("unterminated rpc %s", name)
+			case ";":
+				p.next()
+			default:
+				opt, err := p.parseOptionStatement()
+				if err != nil {
+					return nil, err
+				}
+				m.Options = append(m.Options, opt)
+			}
+		}
+		p.next()
+		return m, nil
+	}
+	if _, err := p.expect(";"); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// skipDefinition skips a statement up to its terminating ";" or balanced "{...}" block.
+func (p *protoParser) skipDefinition() error {
+	depth := 0
+	for {
+		t := p.next()
+		switch t.Text {
+		case "":
+			return p.errorf("unexpected end of file")
+		case "{":
+			depth++
+		case "}":
+			depth--
+			if depth == 0 {
+				return nil
+			}
+		case ";":
+			if depth == 0 {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package generator
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// FromValues builds the model of the types of vals at runtime with reflect, for programs
+// that register types dynamically and have no source to analyse. Every value must be a
+// named struct or a pointer to one; the named structs its fields reference become messages
+// too. Enums can't be discovered without their constant declarations, so named string and
+// integer types map to their underlying scalar.
+func FromValues(vals ...interface{}) (*Model, error) {
+	model := &Model{}
+	seen := make(map[reflect.Type]bool)
+	for _, v := range vals {
+		t := reflect.TypeOf(v)
+		for t != nil && t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t == nil || t.Kind() != reflect.Struct || t.Name() == "" {
+			return nil, fmt.Errorf("FromValues: %T is not a named struct", v)
+		}
+		reflectMessage(t, model, seen)
+	}
+
+	pkgNames := make(map[string]string)
+	for _, msg := range model.Messages {
+		pkgNames[msg.PkgPath] = path.Base(msg.PkgPath)
+	}
+	errs := ErrorList(resolveDuplicates(model.Messages, pkgNames, &Options{}))
+	for _, d := range model.Diagnostics {
+		if d.Severity == SeverityError {
+			errs = append(errs, d)
+		}
+	}
+	if errs.Errors() > 0 {
+		return nil, errs
+	}
+	sort.Slice(model.Messages, func(i, j int) bool { return model.Messages[i].Name < model.Messages[j].Name })
+	return model, nil
+}
+
+// reflectMessage adds the message of struct type t, and of the structs it references,
+// unless already seen.
+func reflectMessage(t reflect.Type, model *Model, seen map[reflect.Type]bool) {
+	if seen[t] {
+		return
+	}
+	seen[t] = true
+	msg := &Message{
+		Name:    t.Name(),
+		GoName:  t.Name(),
+		PkgPath: t.PkgPath(),
+		Fields:  make([]*Field, 0, t.NumField()),
+	}
+	if _, d := asciiIdentifier("message", msg.Name, NonASCIIError); d != nil {
+		d.Package, d.Type = msg.PkgPath, msg.GoName
+		model.Diagnostics = append(model.Diagnostics, d)
+	}
+	if name, ok := escapeIdentifier(msg.Name); ok {
+		model.Diagnostics = append(model.Diagnostics, &Diagnostic{Package: msg.PkgPath, Type: msg.GoName, Code: CodeInvalidIdentifier, Severity: SeverityWarning,
+			Message: fmt.Sprintf("message name %q is not a valid proto identifier, emitting it as %q", msg.Name, name)})
+		msg.Name = name
+	}
+	model.Messages = append(model.Messages, msg)
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		skip := func(reason string, warning bool) {
+			model.Skipped = append(model.Skipped, &SkippedItem{Package: msg.PkgPath, Type: msg.GoName, Field: sf.Name, Reason: reason, Warning: warning})
+		}
+		if sf.PkgPath != "" {
+			skip("not exported", false)
+			continue
+		}
+
+		// Recursive types such as type L []L hold themselves, so unwrapping stops at the first
+		// type seen twice.
+		elem, unwrapped := sf.Type, make(map[reflect.Type]bool)
+		for (elem.Kind() == reflect.Ptr || elem.Kind() == reflect.Slice || elem.Kind() == reflect.Array) && !unwrapped[elem] {
+			unwrapped[elem] = true
+			elem = elem.Elem()
+		}
+		switch elem.Kind() {
+		case reflect.Chan, reflect.Func, reflect.UnsafePointer, reflect.Map, reflect.Interface:
+			skip(fmt.Sprintf("%s type %s has no proto representation", elem.Kind(), elem), true)
+			continue
+		case reflect.Ptr, reflect.Slice, reflect.Array:
+			skip(fmt.Sprintf("type %s holds itself and has no proto representation", elem), true)
+			continue
+		}
+
+		fd := &Field{
+			Name:       toProtoFieldName(sf.Name),
+			GoName:     sf.Name,
+			Order:      i + 1,
+			IsRepeated: sf.Type.Kind() == reflect.Slice,
+		}
+		if _, d := asciiIdentifier("field", fd.Name, NonASCIIError); d != nil {
+			d.Package, d.Type, d.Field = msg.PkgPath, msg.GoName, sf.Name
+			model.Diagnostics = append(model.Diagnostics, d)
+		}
+		if name, ok := escapeIdentifier(fd.Name); ok {
+			model.Diagnostics = append(model.Diagnostics, &Diagnostic{Package: msg.PkgPath, Type: msg.GoName, Field: sf.Name, Code: CodeInvalidIdentifier, Severity: SeverityWarning,
+				Message: fmt.Sprintf("field name %q is not a valid proto identifier, emitting it as %q", fd.Name, name)})
+			fd.Name = name
+		}
+		switch {
+		case elem == timeType:
+			fd.TypeName = "google.protobuf.Timestamp"
+		case elem.PkgPath()+"."+elem.Name() == fieldMaskType:
+			fd.TypeName = "google.protobuf.FieldMask"
+		case elem.Kind() == reflect.Struct && elem.Name() != "":
+			fd.TypeName = elem.Name()
+			fd.NamedType = elem.PkgPath() + "." + elem.Name()
+			reflectMessage(elem, model, seen)
+		case elem.Kind() == reflect.Struct:
+			skip("anonymous struct types have no proto representation", true)
+			continue
+		default:
+			fd.TypeName = normalizeType(elem.Kind().String())
+			if elem.PkgPath() != "" {
+				fd.NamedType = elem.PkgPath() + "." + elem.Name()
+			}
+		}
+		msg.Fields = append(msg.Fields, fd)
+	}
+}
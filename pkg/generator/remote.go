@@ -0,0 +1,63 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// isRemotePattern reports whether a package pattern names a published module version,
+// e.g. "github.com/acme/types@v1.4.0".
+func isRemotePattern(pattern string) bool {
+	return strings.Contains(pattern, "@")
+}
+
+// splitPatterns separates local package patterns from module@version ones.
+func splitPatterns(patterns []string) (local, remote []string) {
+	for _, p := range patterns {
+		if isRemotePattern(p) {
+			remote = append(remote, p)
+		} else {
+			local = append(local, p)
+		}
+	}
+	return local, remote
+}
+
+// remoteWorkspace creates a throwaway module requiring the given module@version patterns,
+// downloading them through the module proxy, so they can be loaded like local packages.
+// It returns the workspace directory, the patterns to load from it and a cleanup func.
+func remoteWorkspace(specs []string, opts *Options) (string, []string, func(), error) {
+	dir, err := ioutil.TempDir("", "go2proto-remote-")
+	if err != nil {
+		return "", nil, nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	gomod := "module go2proto.local/remote\n\ngo 1.21\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte(gomod), 0666); err != nil {
+		cleanup()
+		return "", nil, nil, err
+	}
+
+	var patterns []string
+	for _, spec := range specs {
+		opts.verbosef("downloading %s", spec)
+		var out bytes.Buffer
+		cmd := exec.Command("go", "get", spec)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GOFLAGS="+strings.TrimSpace(os.Getenv("GOFLAGS")+" -mod=mod"), "GOWORK=off")
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+		if err := cmd.Run(); err != nil {
+			cleanup()
+			return "", nil, nil, fmt.Errorf("unable to download %s: %w: %s", spec, err, strings.TrimSpace(out.String()))
+		}
+		patterns = append(patterns, spec[:strings.Index(spec, "@")])
+	}
+	return dir, patterns, cleanup, nil
+}
@@ -0,0 +1,232 @@
+package generator
+
+import (
+	"fmt"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SkippedItem records a field or type that was left out of the generated output.
+type SkippedItem struct {
+	Package string
+	Type    string
+	Field   string `json:",omitempty"`
+	Reason  string
+	File    string `json:",omitempty"`
+	Line    int    `json:",omitempty"`
+	Column  int    `json:",omitempty"`
+	// Warning marks items that are likely mistakes rather than deliberate omissions.
+	Warning bool `json:",omitempty"`
+}
+
+// newSkippedItem builds a SkippedItem located at pos.
+func newSkippedItem(fset *token.FileSet, pos token.Pos, pkgPath, typeName, fieldName, reason string) *SkippedItem {
+	item := &SkippedItem{
+		Package: pkgPath,
+		Type:    typeName,
+		Field:   fieldName,
+		Reason:  reason,
+	}
+	item.File, item.Line, item.Column = position(fset, pos)
+	return item
+}
+
+// String formats the item for logs, e.g. "model.go:12:2: EventSubForm.internal: not exported".
+func (s *SkippedItem) String() string {
+	return formatLocated(s.Package, s.Type, s.Field, s.File, s.Line, s.Column, s.Reason)
+}
+
+// Diagnostic severities.
+const (
+	// SeverityWarning marks problems that didn't stop generation, e.g. a renamed field.
+	SeverityWarning = "warning"
+	// SeverityError marks problems that prevent generating the output.
+	SeverityError = "error"
+)
+
+// Diagnostic codes identify the kind of a Diagnostic, for tools filtering or annotating them.
+const (
+	// CodeLoad is a package that failed to load or type check.
+	CodeLoad = "load"
+	// CodeCgo is a cgo package that could not be built.
+	CodeCgo = "cgo"
+	// CodeCache is a cached analysis that changed while it was read.
+	CodeCache = "cache"
+	// CodeDuplicateName is a message name declared by several Go types.
+	CodeDuplicateName = "duplicate-name"
+	// CodeInvalidIdentifier is a name that had to be changed to be a valid proto identifier.
+	CodeInvalidIdentifier = "invalid-identifier"
+	// CodeNonASCII is a name holding characters outside ASCII.
+	CodeNonASCII = "non-ascii"
+	// CodeInvalidMapKey is a map field keyed by a type proto maps can't be keyed by.
+	CodeInvalidMapKey = "invalid-map-key"
+	// CodeInvalidMapValue is a map field holding values proto maps can't hold.
+	CodeInvalidMapValue = "invalid-map-value"
+	// CodeKeptWrapper is a wrapper message -collapse-wrappers had to keep.
+	CodeKeptWrapper = "kept-wrapper"
+	// CodeUnmappedType is a reference to a type that is neither annotated nor mapped.
+	CodeUnmappedType = "unmapped-type"
+	// CodeIneffectiveAnnotation is an annotation, or an argument of one, that generates nothing.
+	CodeIneffectiveAnnotation = "ineffective-annotation"
+	// CodeStreamResult is a method marked stream that doesn't return a slice.
+	CodeStreamResult = "stream-result"
+	// CodeEmptyService is a service without any method that maps to an rpc.
+	CodeEmptyService = "empty-service"
+)
+
+// Diagnostic is a problem found while loading or analysing the packages.
+type Diagnostic struct {
+	Package  string
+	Type     string `json:",omitempty"`
+	Field    string `json:",omitempty"`
+	Code     string
+	Message  string
+	Severity string
+	File     string `json:",omitempty"`
+	Line     int    `json:",omitempty"`
+	Column   int    `json:",omitempty"`
+}
+
+// newDiagnostic builds a Diagnostic located at pos.
+func newDiagnostic(fset *token.FileSet, pos token.Pos, pkgPath, typeName, fieldName, code, message string) *Diagnostic {
+	return (&Diagnostic{Code: code, Message: message, Severity: SeverityWarning}).at(fset, pos, pkgPath, typeName, fieldName)
+}
+
+// at locates d at pos, in the type or field of pkgPath, and returns it.
+func (d *Diagnostic) at(fset *token.FileSet, pos token.Pos, pkgPath, typeName, fieldName string) *Diagnostic {
+	d.Package, d.Type, d.Field = pkgPath, typeName, fieldName
+	d.File, d.Line, d.Column = position(fset, pos)
+	return d
+}
+
+// String formats the diagnostic like SkippedItem.String.
+func (d *Diagnostic) String() string {
+	return formatLocated(d.Package, d.Type, d.Field, d.File, d.Line, d.Column, d.Message)
+}
+
+// position resolves pos to a file, line and column, or zero values if it is unknown.
+func position(fset *token.FileSet, pos token.Pos) (string, int, int) {
+	if fset == nil || !pos.IsValid() {
+		return "", 0, 0
+	}
+	p := fset.Position(pos)
+	return p.Filename, p.Line, p.Column
+}
+
+// formatLocated formats a message about a type or field, prefixed with its source position if known.
+func formatLocated(pkgPath, typeName, fieldName, file string, line, column int, message string) string {
+	name := typeName
+	if fieldName != "" {
+		name += "." + fieldName
+	}
+	if file == "" {
+		if name == "" {
+			return fmt.Sprintf("%s: %s", pkgPath, message)
+		}
+		return fmt.Sprintf("%s.%s: %s", pkgPath, name, message)
+	}
+	if name == "" {
+		return fmt.Sprintf("%s:%d:%d: %s", file, line, column, message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s: %s", file, line, column, name, message)
+}
+
+// ErrorList is returned by Load and Analyze when they find errors. It holds every error
+// found, not just the first, along with the warnings found up to that point.
+type ErrorList []*Diagnostic
+
+// Errors returns the number of errors in the list.
+func (l ErrorList) Errors() int {
+	n := 0
+	for _, d := range l {
+		if d.Severity == SeverityError {
+			n++
+		}
+	}
+	return n
+}
+
+// err returns l as an error if it holds any error, nil otherwise.
+func (l ErrorList) err() error {
+	if l.Errors() == 0 {
+		return nil
+	}
+	return l
+}
+
+// Error lists the problems grouped by file (or by package when the file is unknown),
+// ordered by position within each file.
+func (l ErrorList) Error() string {
+	groups := make(map[string][]*Diagnostic)
+	for _, d := range l {
+		key := d.File
+		if key == "" {
+			key = d.Package
+		}
+		groups[key] = append(groups[key], d)
+	}
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	errs := l.Errors()
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s, %s", plural(errs, "error"), plural(len(l)-errs, "warning"))
+	for _, key := range keys {
+		ds := groups[key]
+		sort.SliceStable(ds, func(i, j int) bool {
+			if ds[i].Line != ds[j].Line {
+				return ds[i].Line < ds[j].Line
+			}
+			return ds[i].Column < ds[j].Column
+		})
+		fmt.Fprintf(&b, "\n%s:", key)
+		for _, d := range ds {
+			b.WriteString("\n  ")
+			if d.Line > 0 {
+				fmt.Fprintf(&b, "%d:%d: ", d.Line, d.Column)
+			}
+			name := d.Type
+			if d.Field != "" {
+				name += "." + d.Field
+			}
+			if name != "" {
+				b.WriteString(name + ": ")
+			}
+			fmt.Fprintf(&b, "%s: %s", d.Severity, d.Message)
+		}
+	}
+	return b.String()
+}
+
+// plural formats a count of things, e.g. "1 error" or "3 errors".
+func plural(n int, thing string) string {
+	if n == 1 {
+		return "1 " + thing
+	}
+	return strconv.Itoa(n) + " " + thing + "s"
+}
+
+// parsePosition splits a "file:line:column" position, as reported by go list, into its
+// parts. The line and column are optional.
+func parsePosition(pos string) (string, int, int) {
+	file, line, column := pos, 0, 0
+	if i := strings.LastIndex(file, ":"); i > 0 {
+		if n, err := strconv.Atoi(file[i+1:]); err == nil {
+			file, line = file[:i], n
+		}
+	}
+	if i := strings.LastIndex(file, ":"); i > 0 && line > 0 {
+		if n, err := strconv.Atoi(file[i+1:]); err == nil {
+			file, line, column = file[:i], n, line
+		}
+	}
+	if file == "-" {
+		file = ""
+	}
+	return file, line, column
+}
@@ -0,0 +1,238 @@
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// addService adds the service of a struct annotated with "@go2proto service". Its exported
+// methods of the form func(Request) Response, with Request and Response structs or
+// pointers to structs, become RPCs; a leading context.Context parameter and a trailing error
+// result are left out. Methods marked "@go2proto stream" returning a slice stream its
+// elements instead, and "@go2proto idempotency=no-side-effects" (or idempotent) sets their
+// idempotency_level. Other methods are skipped with a warning. Request and response
+// structs of the same package become messages even without an annotation; those of other
+// packages must be annotated.
+func addService(p *packages.Package, def *types.TypeName, ann annotation, model *packageModel, opts *Options) {
+	svc := &Service{
+		Name:    def.Name(),
+		GoName:  def.Name(),
+		PkgPath: p.PkgPath,
+		Package: ann.Package,
+		Pos:     p.Fset.Position(def.Pos()),
+	}
+	if ann.Name != "" {
+		svc.Name = ann.Name
+	}
+	if name, d := asciiIdentifier("service", svc.Name, opts.NonASCII); d != nil {
+		model.Diagnostics = append(model.Diagnostics, d.at(p.Fset, def.Pos(), p.PkgPath, def.Name(), ""))
+		svc.Name = name
+	}
+	if name, ok := escapeIdentifier(svc.Name); ok {
+		model.Diagnostics = append(model.Diagnostics, newDiagnostic(p.Fset, def.Pos(), p.PkgPath, def.Name(), "", CodeInvalidIdentifier,
+			fmt.Sprintf("service name %q is not a valid proto identifier, emitting it as %q", svc.Name, name)))
+		svc.Name = name
+	}
+
+	named, ok := def.Type().(*types.Named)
+	if !ok {
+		return
+	}
+	methodAnns := methodAnnotations(p.Syntax, def.Name())
+	for i := 0; i < named.NumMethods(); i++ {
+		fn := named.Method(i)
+		if !fn.Exported() {
+			continue
+		}
+		skip := func(reason string) {
+			item := newSkippedItem(p.Fset, fn.Pos(), p.PkgPath, def.Name(), fn.Name(), reason)
+			item.Warning = true
+			model.Skipped = append(model.Skipped, item)
+		}
+
+		sig := fn.Type().(*types.Signature)
+		params, results := rpcSignature(sig)
+		if len(params) != 1 || len(results) != 1 {
+			skip(fmt.Sprintf("method signature %s is not func([context.Context,] Request) (Response[, error])", sig))
+			continue
+		}
+		req, ok := messageStruct(params[0])
+		if !ok {
+			skip(fmt.Sprintf("parameter type %s is not a struct", params[0]))
+			continue
+		}
+		result, stream := results[0], false
+		if slice, ok := types.Unalias(result).(*types.Slice); ok && methodAnns[fn.Name()].Stream {
+			result, stream = slice.Elem(), true
+		} else if methodAnns[fn.Name()].Stream {
+			model.Diagnostics = append(model.Diagnostics, newDiagnostic(p.Fset, fn.Pos(), p.PkgPath, def.Name(), fn.Name(), CodeStreamResult,
+				fmt.Sprintf("stream needs a slice result, but %s returns %s", fn.Name(), result)))
+		}
+		resp, ok := messageStruct(result)
+		switch {
+		case !ok && isSlice(result):
+			skip(fmt.Sprintf("result type %s is not a struct, mark the method with %s stream to stream its elements", result, annotationMarker))
+			continue
+		case !ok:
+			skip(fmt.Sprintf("result type %s is not a struct", result))
+			continue
+		}
+		for _, obj := range []*types.TypeName{req, resp} {
+			ensureMessage(p, obj, model, opts)
+		}
+		rpc, d := asciiIdentifier("rpc", fn.Name(), opts.NonASCII)
+		if d != nil {
+			model.Diagnostics = append(model.Diagnostics, d.at(p.Fset, fn.Pos(), p.PkgPath, def.Name(), fn.Name()))
+		}
+		opts.debugf("%s.%s: mapped method to rpc %s(%s) returns (%s)", def.Name(), fn.Name(), rpc, req.Name(), resp.Name())
+		svc.Methods = append(svc.Methods, &Method{
+			Name:            rpc,
+			Request:         req.Name(),
+			Response:        resp.Name(),
+			RequestType:     req.Pkg().Path() + "." + req.Name(),
+			ResponseType:    resp.Pkg().Path() + "." + resp.Name(),
+			ServerStreaming: stream,
+			Idempotency:     methodAnns[fn.Name()].Idempotency,
+			Pos:             p.Fset.Position(fn.Pos()),
+		})
+	}
+	if len(svc.Methods) == 0 {
+		model.Diagnostics = append(model.Diagnostics, newDiagnostic(p.Fset, def.Pos(), p.PkgPath, def.Name(), "", CodeEmptyService,
+			fmt.Sprintf("service %s has no exported methods of the form func(Request) Response", svc.Name)))
+	}
+	model.Services = append(model.Services, svc)
+}
+
+// methodAnnotations returns the annotations of the methods of typeName, keyed by method name.
+func methodAnnotations(files []*ast.File, typeName string) map[string]annotation {
+	result := make(map[string]annotation)
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || receiverTypeName(fn) != typeName {
+				continue
+			}
+			if ann, ok := parseAnnotation(fn.Doc); ok {
+				result[fn.Name.Name] = ann
+			}
+		}
+	}
+	return result
+}
+
+// receiverTypeName returns the name of the type a method is declared on, or "" for funcs.
+func receiverTypeName(fn *ast.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return ""
+	}
+	expr := fn.Recv.List[0].Type
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	switch x := expr.(type) {
+	case *ast.IndexExpr:
+		expr = x.X
+	case *ast.IndexListExpr:
+		expr = x.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+func isSlice(t types.Type) bool {
+	_, ok := types.Unalias(t).(*types.Slice)
+	return ok
+}
+
+// rpcSignature returns the parameter and result types of sig that carry data, leaving out
+// a leading context.Context parameter and a trailing error result.
+func rpcSignature(sig *types.Signature) (params, results []types.Type) {
+	for i := 0; i < sig.Params().Len(); i++ {
+		params = append(params, sig.Params().At(i).Type())
+	}
+	for i := 0; i < sig.Results().Len(); i++ {
+		results = append(results, sig.Results().At(i).Type())
+	}
+	if len(params) > 0 && isContext(params[0]) {
+		params = params[1:]
+	}
+	if len(results) > 0 && types.Identical(results[len(results)-1], errorType) {
+		results = results[:len(results)-1]
+	}
+	return params, results
+}
+
+var errorType = types.Universe.Lookup("error").Type()
+
+// isContext reports whether t is context.Context.
+func isContext(t types.Type) bool {
+	named, ok := types.Unalias(t).(*types.Named)
+	return ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == "context" && named.Obj().Name() == "Context"
+}
+
+// messageStruct returns the named struct type t is or points to.
+func messageStruct(t types.Type) (*types.TypeName, bool) {
+	if ptr, ok := types.Unalias(t).(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	named, ok := types.Unalias(t).(*types.Named)
+	if !ok || named.Obj().Pkg() == nil {
+		return nil, false
+	}
+	if _, ok := named.Underlying().(*types.Struct); !ok {
+		return nil, false
+	}
+	return named.Obj(), true
+}
+
+// ensureMessage adds the message of obj, a request or response struct, unless the package
+// already has it or it belongs to another package.
+func ensureMessage(p *packages.Package, obj *types.TypeName, model *packageModel, opts *Options) {
+	if obj.Pkg().Path() != p.PkgPath {
+		return
+	}
+	for _, msg := range model.Messages {
+		if msg.GoName == obj.Name() {
+			return
+		}
+	}
+	opts.verbosef("matched message %s.%s of a service method", p.PkgPath, obj.Name())
+	addMessage(p, obj, obj.Type().Underlying().(*types.Struct), annotation{}, model, opts)
+}
+
+// missingServiceReferences reports the methods whose request or response struct is not a
+// message, like missingReferences does for fields.
+func missingServiceReferences(services []*Service, msgs []*Message) []*Diagnostic {
+	known := make(map[string]bool, len(msgs))
+	for _, msg := range msgs {
+		known[msg.PkgPath+"."+msg.GoName] = true
+	}
+
+	var result []*Diagnostic
+	for _, svc := range services {
+		for _, m := range svc.Methods {
+			for _, typ := range []string{m.RequestType, m.ResponseType} {
+				if known[typ] {
+					continue
+				}
+				result = append(result, &Diagnostic{
+					Package:  svc.PkgPath,
+					Type:     svc.GoName,
+					Field:    m.Name,
+					Code:     CodeUnmappedType,
+					Message:  fmt.Sprintf("type %s is not annotated with %s", typ, annotationMarker),
+					Severity: SeverityWarning,
+					File:     m.Pos.Filename,
+					Line:     m.Pos.Line,
+					Column:   m.Pos.Column,
+				})
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,165 @@
+package generator
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// snapshotPath returns where the last generated contents of the file at path are kept in
+// the cache directory dir.
+func snapshotPath(dir, path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	sum := sha256.Sum256([]byte(path))
+	return filepath.Join(dir, "snapshots", hex.EncodeToString(sum[:])+filepath.Ext(path))
+}
+
+// readSnapshot returns the contents the file was last generated with, if known.
+func (f *File) readSnapshot() (string, bool) {
+	if f.Snapshot == "" {
+		return "", false
+	}
+	data, err := ioutil.ReadFile(f.Snapshot)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// saveSnapshot records out as the contents the file was last generated with, leaving out
+// the hand edits merged into it: they stay edits for the next run to merge.
+func (f *File) saveSnapshot(out []byte) error {
+	if f.Snapshot == "" {
+		return nil
+	}
+	if f.generated != nil {
+		out = f.generated
+	}
+	if err := os.MkdirAll(filepath.Dir(f.Snapshot), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(f.Snapshot, bytes.ReplaceAll(out, []byte("\r\n"), []byte("\n")), 0666)
+}
+
+// MergeConflict is a part of a file that was edited by hand and changed by the generator.
+type MergeConflict struct {
+	// Line is the first line of the part in the file on disk.
+	Line int
+	// Edited is the part as it was edited, Generated as it is generated now.
+	Edited    []string
+	Generated []string
+}
+
+// ConflictError is returned when the hand edits made to a file since it was last generated
+// conflict with the changes of the generator. The file is left as it is.
+type ConflictError struct {
+	Path      string
+	Conflicts []MergeConflict
+}
+
+func (e *ConflictError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s was edited by hand since it was generated, and %d of the edits conflict with the generated changes;"+
+		" move them into manual sections, or regenerate the file with -force to discard them:", e.Path, len(e.Conflicts))
+	for _, c := range e.Conflicts {
+		fmt.Fprintf(&sb, "\n  line %d:", c.Line)
+		for _, line := range c.Edited {
+			sb.WriteString("\n    edited:    " + line)
+		}
+		for _, line := range c.Generated {
+			sb.WriteString("\n    generated: " + line)
+		}
+	}
+	return sb.String()
+}
+
+// mergeEdits carries the hand edits made to the file since it was generated as base,
+// existing being its contents now, into the file generated now. Parts both edited by hand
+// and changed by the generator fail with a ConflictError.
+func (f *File) mergeEdits(out []byte, base, existing string) ([]byte, error) {
+	generated, err := f.update(out, base)
+	if err != nil {
+		return nil, err
+	}
+	merged, conflicts := merge3(splitLines(base), splitLines(existing), splitLines(string(generated)))
+	if len(conflicts) > 0 {
+		return nil, &ConflictError{Path: f.Path, Conflicts: conflicts}
+	}
+	f.generated = generated
+	return []byte(strings.Join(merged, "\n") + "\n"), nil
+}
+
+// merge3 merges the changes made from base to ours and to theirs, line by line. Runs of
+// lines changed differently on both sides are conflicts, located in ours.
+func merge3(base, ours, theirs []string) ([]string, []MergeConflict) {
+	inOurs, inTheirs := keptLines(base, ours), keptLines(base, theirs)
+	var merged []string
+	var conflicts []MergeConflict
+	i, o, t := 0, 0, 0
+	for {
+		// The next base line both sides kept ends the run of changes before it.
+		k := i
+		for k < len(base) && (inOurs[k] < 0 || inTheirs[k] < 0) {
+			k++
+		}
+		oEnd, tEnd := len(ours), len(theirs)
+		if k < len(base) {
+			oEnd, tEnd = inOurs[k], inTheirs[k]
+		}
+		b, ob, tb := base[i:k], ours[o:oEnd], theirs[t:tEnd]
+		switch {
+		case equalLines(ob, b):
+			merged = append(merged, tb...)
+		case equalLines(tb, b), equalLines(ob, tb):
+			merged = append(merged, ob...)
+		default:
+			conflicts = append(conflicts, MergeConflict{Line: o + 1, Edited: ob, Generated: tb})
+		}
+		if k == len(base) {
+			return merged, conflicts
+		}
+		merged = append(merged, base[k])
+		i, o, t = k+1, oEnd+1, tEnd+1
+	}
+}
+
+// keptLines returns, for every line of a, its index in b if the edit script from a to b
+// keeps it, or -1.
+func keptLines(a, b []string) []int {
+	result := make([]int, len(a))
+	i, j := 0, 0
+	for _, op := range diffLines(a, b) {
+		switch op.Kind {
+		case ' ':
+			result[i] = j
+			i++
+			j++
+		case '-':
+			result[i] = -1
+			i++
+		default:
+			j++
+		}
+	}
+	return result
+}
+
+// equalLines reports whether a and b hold the same lines.
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,140 @@
+package generator
+
+import (
+	"go/token"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// DefaultBatchSize is the number of packages LoadAndAnalyze type checks at a time when
+// Options.BatchSize is not set.
+const DefaultBatchSize = 100
+
+// LoadError is returned by LoadAndAnalyze when the packages could not be loaded, as
+// opposed to failing analysis.
+type LoadError struct {
+	Err error
+}
+
+func (e *LoadError) Error() string { return e.Err.Error() }
+
+func (e *LoadError) Unwrap() error { return e.Err }
+
+// LoadAndAnalyze is Load followed by Analyze for large workspaces: instead of holding the
+// syntax and types of every package until the whole workspace is analysed, it lists the
+// packages first and then loads and analyses them opts.BatchSize at a time, so only one
+// batch is in memory at once. Packages with an up-to-date cached analysis, whose files and
+// same-module dependencies are unchanged, are not loaded.
+// The returned packages only carry their name, path and files.
+//
+// Remote patterns and IncludeTests load everything at once, as with Load.
+func LoadAndAnalyze(opts *Options) (*Model, []*packages.Package, error) {
+	local, remote := splitPatterns(opts.Patterns)
+	if len(remote) > 0 || opts.IncludeTests {
+		pkgs, err := Load(opts)
+		if err != nil {
+			return nil, nil, &LoadError{err}
+		}
+		model, err := Analyze(pkgs, opts)
+		return model, listedPackages(pkgs), err
+	}
+
+	endLoad := opts.Timings.Span(PhaseLoad)
+	cfg, _ := loadConfig(opts)
+	listCfg := *cfg
+	listCfg.Mode = listMode
+	listed, err := packages.Load(&listCfg, local...)
+	endLoad()
+	if err != nil {
+		return nil, nil, &LoadError{err}
+	}
+	if err := loadErrors(listed); err != nil {
+		return nil, nil, &LoadError{err}
+	}
+
+	pms := make([]*packageModel, len(listed))
+	index := make(map[string]int, len(listed))
+	pkgNames := make(map[string]string, len(listed))
+	var cached, stale []*packages.Package
+	c := &cache{dir: opts.CacheDir}
+	for i, p := range listed {
+		index[p.PkgPath] = i
+		pkgNames[p.PkgPath] = p.Name
+		if opts.CacheDir != "" && c.has(p, opts) {
+			cached = append(cached, p)
+		} else {
+			stale = append(stale, p)
+		}
+	}
+
+	done := 0
+	// analyze analyses a batch, reporting progress against the whole workspace.
+	analyze := func(batch []*packages.Package) {
+		defer opts.Timings.Span(PhaseAnalysis)()
+		batchOpts := *opts
+		if opts.Progress != nil {
+			offset := done
+			batchOpts.Progress = func(n, _ int, pkgPath string) { opts.Progress(offset+n, len(listed), pkgPath) }
+		}
+		for _, pm := range analyzePackages(batch, &batchOpts) {
+			pms[index[pm.PkgPath]] = pm
+		}
+		done += len(batch)
+	}
+	if len(cached) > 0 {
+		opts.verbosef("analysis of %d of %d packages is cached", len(cached), len(listed))
+		analyze(cached)
+	}
+
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	for start := 0; start < len(stale); start += batchSize {
+		end := start + batchSize
+		if end > len(stale) {
+			end = len(stale)
+		}
+		paths := make([]string, 0, end-start)
+		for _, p := range stale[start:end] {
+			paths = append(paths, p.PkgPath)
+		}
+		// A FileSet per batch, so the batch's position tables are released with it.
+		batchCfg := *cfg
+		batchCfg.Fset = token.NewFileSet()
+		endLoad := opts.Timings.Span(PhaseLoad)
+		batch, err := packages.Load(&batchCfg, paths...)
+		endLoad()
+		if err != nil {
+			return nil, nil, &LoadError{err}
+		}
+		if err := loadErrors(batch); err != nil {
+			return nil, nil, &LoadError{err}
+		}
+		for _, p := range batch {
+			opts.verbosef("loaded package %s (%d files)", p.PkgPath, len(p.GoFiles))
+		}
+		analyze(batch)
+	}
+
+	endAnalysis := opts.Timings.Span(PhaseAnalysis)
+	model, err := buildModel(pms, pkgNames, opts)
+	endAnalysis()
+	return model, listed, err
+}
+
+// listedPackages returns copies of pkgs holding only what listMode loads, so the syntax
+// and types of the originals can be released.
+func listedPackages(pkgs []*packages.Package) []*packages.Package {
+	result := make([]*packages.Package, len(pkgs))
+	for i, p := range pkgs {
+		result[i] = &packages.Package{
+			ID:      p.ID,
+			Name:    p.Name,
+			PkgPath: p.PkgPath,
+			GoFiles: p.GoFiles,
+			Module:  p.Module,
+		}
+	}
+	return result
+}
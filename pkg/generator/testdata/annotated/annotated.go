@@ -0,0 +1,22 @@
+package annotated
+
+// @go2proto(name=UserV2, package=acme.users.v1)
+type User struct {
+	ID   string
+	Tags []string
+	Role Role
+}
+
+// @go2proto name=TeamV2 deprecated
+type Team struct {
+	Owner   *User
+	Members []User
+}
+
+// @go2proto deprecated
+type Role string
+
+const (
+	RoleAdmin  Role = "admin"
+	RoleMember Role = "member"
+)
@@ -0,0 +1,41 @@
+package constenum
+
+import "github.com/beam-cloud/go2proto/pkg/generator/testdata/constenum/states"
+
+// @go2proto enum=JobState
+const (
+	Pending states.State = "pending"
+	Running states.State = "running"
+	Done    states.State = "done"
+	// go2proto:skip
+	Unknown states.State = ""
+)
+
+// @go2proto enum=Priority deprecated
+const (
+	_ = iota
+	Low
+	Normal
+	High
+	priorityCount // go2proto:skip
+)
+
+// @go2proto deprecated
+type Kind string
+
+const (
+	KindBatch  Kind = "batch"
+	KindStream Kind = "stream"
+	_          Kind = "reserved"
+	kindLegacy Kind = "legacy" // go2proto:skip
+)
+
+// @go2proto
+const Ignored = "no enum= argument"
+
+// @go2proto
+type Job struct {
+	State    states.State
+	Priority int
+	Kind     Kind
+}
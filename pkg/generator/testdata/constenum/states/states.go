@@ -0,0 +1,4 @@
+package states
+
+// State is declared without constants, which constenum adds.
+type State string
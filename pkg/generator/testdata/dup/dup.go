@@ -0,0 +1,12 @@
+package dup
+
+// Account shares its name with second.Account.
+// @go2proto
+type Account struct {
+	Owner string
+}
+
+// @go2proto
+type Ledger struct {
+	Accounts []*Account
+}
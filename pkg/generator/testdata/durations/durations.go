@@ -0,0 +1,14 @@
+package durations
+
+import "time"
+
+// @go2proto
+type Job struct {
+	Timeout  time.Duration
+	Interval time.Duration `go2proto:"duration=millis"`
+	Backoff  time.Duration `go2proto:"duration=well-known"`
+	Retries  int32         `go2proto:"duration=millis"`
+	Delay    time.Duration `go2proto:"duration=seconds"`
+	Deadline *time.Duration
+	Steps    []time.Duration `go2proto:"duration=millis"`
+}
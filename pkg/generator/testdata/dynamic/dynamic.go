@@ -0,0 +1,15 @@
+package dynamic
+
+import "encoding/json"
+
+// @go2proto
+type Event struct {
+	Name     string
+	Payload  interface{}
+	Metadata map[string]any
+	Raw      json.RawMessage
+	Items    []any
+	Blob     json.RawMessage `go2proto:"any=bytes"`
+	Extra    *any            `go2proto:"any=any"`
+	Count    int64           `go2proto:"any=bytes"`
+}
@@ -0,0 +1,12 @@
+package fieldmasks
+
+// UpdateMask lists the paths of the fields an update sets.
+type UpdateMask struct {
+	Paths []string
+}
+
+// @go2proto
+type UpdateUserRequest struct {
+	Name string
+	Mask *UpdateMask
+}
@@ -0,0 +1,36 @@
+package grouped
+
+// @go2proto
+type (
+	Point struct {
+		X, Y int32
+	}
+	Line struct {
+		From, To Point
+	}
+)
+
+type (
+	// Ignored is not annotated.
+	Ignored struct {
+		Name string
+	}
+
+	// Round is annotated on its own.
+	// @go2proto name=Circle
+	Round struct {
+		Center Point
+		Radius float64
+	}
+)
+
+// @go2proto package=acme.shapes
+type (
+	Square struct {
+		Side float64
+	}
+	// @go2proto name=Rect
+	Rectangle struct {
+		Width, Height float64
+	}
+)
@@ -0,0 +1,44 @@
+package ineffective
+
+import "net/http"
+
+// @go2proto
+type Handler func(w http.ResponseWriter, r *http.Request)
+
+// @go2proto
+type Labels map[string]string
+
+// @go2proto
+type Level int
+
+// @go2proto
+func NewRequest() *Request { return &Request{} }
+
+// @go2proto
+var DefaultRequest = Request{}
+
+// @go2proto
+const Timeout = 30
+
+// @go2proto
+type Request struct {
+	Path string
+}
+
+// @go2proto(nmae=Renamed, idempotency=bogus)
+type Response struct {
+	Code int
+}
+
+type (
+	// @go2proto(name=Page
+	Page struct {
+		Size int
+	}
+
+	// @go2proto
+	// go2proto:cel size_positive
+	Limit struct {
+		Size int
+	}
+)
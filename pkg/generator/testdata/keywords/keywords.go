@@ -0,0 +1,8 @@
+package keywords
+
+// @go2proto(name=1Password)
+type Vault struct {
+	Message string
+	Option  string
+	Name    string
+}
@@ -0,0 +1,14 @@
+package mapkeys
+
+type Point struct {
+	X, Y int
+}
+
+// @go2proto
+type Chart struct {
+	Labels  map[int32]string
+	Weights map[float64]string
+	Cells   []map[Point]int
+	Extra   map[any]string
+	Handles map[uintptr]string
+}
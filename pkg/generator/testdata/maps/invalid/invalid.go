@@ -0,0 +1,9 @@
+package invalid
+
+// @go2proto
+type Chart struct {
+	Tags   map[string][]string
+	Nested map[string]map[string]int
+	Series []map[string]int
+	Extra  map[int64]any
+}
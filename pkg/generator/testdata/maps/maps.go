@@ -0,0 +1,27 @@
+package maps
+
+import "time"
+
+// @go2proto
+type Point struct {
+	X, Y int32
+}
+
+// @go2proto
+type Status string
+
+const (
+	StatusActive Status = "active"
+	StatusPaused Status = "paused"
+)
+
+// @go2proto
+type Chart struct {
+	Labels   map[string]string
+	Counts   map[uint8]int16
+	Points   map[int64]*Point
+	Statuses map[string]Status
+	Seen     map[string]time.Time
+	Blobs    map[string][]byte
+	Meta     map[string]any
+}
@@ -0,0 +1,13 @@
+package native
+
+// #include <stdlib.h>
+import "C"
+
+// @go2proto
+type Handle struct {
+	Label string
+}
+
+func free(p *C.char) {
+	C.free(nil)
+}
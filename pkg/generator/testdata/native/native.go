@@ -0,0 +1,6 @@
+package native
+
+// @go2proto
+type Config struct {
+	Name string
+}
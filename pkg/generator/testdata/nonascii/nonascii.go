@@ -0,0 +1,8 @@
+package nonascii
+
+// @go2proto
+type Größe struct {
+	Wert  int
+	Länge float64
+	Ω     string
+}
@@ -0,0 +1,10 @@
+package numbers
+
+// @go2proto
+type Profile struct {
+	ID      string `go2proto:"number=2"`
+	Name    string
+	Email   string `go2proto:"name=mail,number=1"`
+	Age     int64  `go2proto:"number=1"`
+	Country string `go2proto:"number=19500"`
+}
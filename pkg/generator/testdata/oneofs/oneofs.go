@@ -0,0 +1,42 @@
+package oneofs
+
+// @go2proto
+type Success struct {
+	Value string
+}
+
+// @go2proto
+type Error struct {
+	Code   int32
+	Reason string
+}
+
+// Result holds the outcome of a job: exactly one of Success and Error is set.
+//
+// @go2proto oneof=result:Success,Error oneof=source:URL,Path,Tags,Missing
+type Result struct {
+	ID      string
+	Success *Success
+	URL     *string
+	Error   *Error
+	Path    *string
+	Tags    []string
+	Retries int32
+}
+
+// @go2proto(name=Outcome, oneof=result:Success,Error, deprecated)
+type Outcome struct {
+	Success *Success
+	Error   *Error
+}
+
+// @go2proto
+type ArrayOfLabel struct {
+	Label []string
+}
+
+// @go2proto oneof=labels:Labels,Label
+type Tagging struct {
+	Labels *ArrayOfLabel
+	Label  *string
+}
@@ -0,0 +1,8 @@
+package options
+
+// User is a resource of the users API.
+//
+// @go2proto option=(acme.resource).type="users" option=(acme.resource).pattern="users/{user}"
+type User struct {
+	Name string
+}
@@ -0,0 +1,14 @@
+package recursive
+
+// L is a list of lists, holding itself.
+type L []L
+
+// P points at itself.
+type P *P
+
+// @go2proto
+type Tree struct {
+	Name string
+	Kids L
+	Next P
+}
@@ -0,0 +1,16 @@
+package runtime
+
+import "unsafe"
+
+// Handler is a callback, not data.
+type Handler func(string) error
+
+// @go2proto
+type Worker struct {
+	Name     string
+	Jobs     chan string
+	OnDone   func()
+	Handlers []Handler
+	Raw      unsafe.Pointer
+	Retries  int32
+}
@@ -0,0 +1,17 @@
+package second
+
+// @go2proto
+type Account struct {
+	ID      string
+	Balance float64
+	Status  AccountStatus
+	note    string
+}
+
+// @go2proto
+type AccountStatus string
+
+const (
+	AccountStatusOpen   AccountStatus = "open"
+	AccountStatusClosed AccountStatus = "closed"
+)
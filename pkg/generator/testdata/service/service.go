@@ -0,0 +1,70 @@
+package service
+
+import "context"
+
+// @go2proto
+type User struct {
+	ID   string
+	Name string
+}
+
+type GetUserRequest struct {
+	ID string
+}
+
+type GetUserResponse struct {
+	User *User
+}
+
+// @go2proto(name=ListRequest)
+type ListUsersRequest struct {
+	Limit int32
+}
+
+type ListUsersResponse struct {
+	Users []*User
+}
+
+type ListEventsRequest struct {
+	Since int64
+}
+
+// @go2proto
+type Event struct {
+	Name string
+}
+
+// @go2proto service
+type UserService struct {
+	users map[string]*User
+}
+
+// @go2proto idempotency=no-side-effects
+func (s *UserService) GetUser(ctx context.Context, req *GetUserRequest) (*GetUserResponse, error) {
+	return &GetUserResponse{User: s.users[req.ID]}, ctx.Err()
+}
+
+// @go2proto(idempotency=idempotent)
+func (s *UserService) ListUsers(req ListUsersRequest) ListUsersResponse {
+	return ListUsersResponse{}
+}
+
+// ListEvents streams the events since req.Since.
+// @go2proto stream
+func (s *UserService) ListEvents(ctx context.Context, req *ListEventsRequest) ([]*Event, error) {
+	return nil, nil
+}
+
+func (s *UserService) Users(req ListUsersRequest) []*User {
+	return nil
+}
+
+func (s *UserService) Close() error { return nil }
+
+func (s *UserService) Rename(id string) *User {
+	return s.users[id]
+}
+
+func (s *UserService) lookup(id string) *User {
+	return s.users[id]
+}
@@ -0,0 +1,6 @@
+package tagged
+
+// @go2proto
+type Base struct {
+	ID string
+}
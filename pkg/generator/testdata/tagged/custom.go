@@ -0,0 +1,9 @@
+//go:build custom
+// +build custom
+
+package tagged
+
+// @go2proto
+type Custom struct {
+	ID string
+}
@@ -0,0 +1,6 @@
+package tagged
+
+// @go2proto
+type Plan9Only struct {
+	ID string
+}
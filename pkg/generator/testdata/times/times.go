@@ -0,0 +1,13 @@
+package times
+
+import "time"
+
+// @go2proto
+type Event struct {
+	CreatedAt time.Time
+	UpdatedAt *time.Time  `go2proto:"time=unix-seconds"`
+	SeenAt    []time.Time `go2proto:"time=unix-millis"`
+	ExpiresAt time.Time   `go2proto:"time=timestamp"`
+	Name      string      `go2proto:"time=unix-seconds"`
+	Reminders []time.Time
+}
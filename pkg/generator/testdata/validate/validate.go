@@ -0,0 +1,19 @@
+package validate
+
+import "time"
+
+// Booking is a reservation of a room.
+// @go2proto
+// go2proto:cel ends_after_start "ends_at must be after starts_at" this.ends_at > this.starts_at
+// go2proto:cel guests_fit this.guests <= this.capacity ? "" : "more than " + string(this.capacity) + " guests"
+type Booking struct {
+	StartsAt time.Time
+	EndsAt   time.Time
+	Guests   int32
+	Capacity int32
+}
+
+// @go2proto
+type Room struct {
+	Number string
+}
@@ -0,0 +1,6 @@
+package withtests
+
+// @go2proto
+type Order struct {
+	ID string
+}
@@ -0,0 +1,7 @@
+package withtests
+
+// @go2proto
+type OrderFixture struct {
+	Order *Order
+	Label string
+}
@@ -0,0 +1,85 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// thriftTypes maps proto scalar and well-known types to Thrift base types.
+var thriftTypes = map[string]string{
+	"double":                    "double",
+	"float":                     "double",
+	"int32":                     "i32",
+	"int64":                     "i64",
+	"uint32":                    "i64",
+	"uint64":                    "i64",
+	"bool":                      "bool",
+	"string":                    "string",
+	"bytes":                     "binary",
+	"google.protobuf.Timestamp": "i64",
+	"google.protobuf.Duration":  "i64",
+	"google.protobuf.FieldMask": "string",
+	"google.protobuf.Any":       "string",
+	"google.protobuf.Struct":    "string",
+	"google.protobuf.Value":     "string",
+}
+
+// renderThrift renders the file as Thrift IDL: an enum per enum and a struct per message,
+// in dependency order since Thrift resolves types in declaration order. Field ids are the
+// proto field numbers, and message fields are optional.
+func renderThrift(f *File) ([]byte, error) {
+	names := messageNames(f.Messages)
+	enums := enumsByGoName(f.Enums)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Code generated by %s. DO NOT EDIT.\n\n", f.generatedBy())
+	if f.ProtoPackage != "" {
+		fmt.Fprintf(&b, "namespace * %s\n\n", f.ProtoPackage)
+	}
+	for _, e := range f.Enums {
+		fmt.Fprintf(&b, "enum %s {\n", e.Name)
+		for i, symbol := range enumSymbols(e.Values) {
+			fmt.Fprintf(&b, "  %s = %d,\n", symbol, i)
+		}
+		b.WriteString("}\n\n")
+	}
+
+	for _, msg := range orderByDependency(f.Messages) {
+		fmt.Fprintf(&b, "struct %s {\n", msg.Name)
+		for _, fd := range msg.Fields {
+			typ, comment := thriftTypes[fd.TypeName], ""
+			switch {
+			case len(fd.EnumValues) > 0 && enums[fd.NamedType] != nil:
+				typ = enums[fd.NamedType].Name
+			case len(fd.EnumValues) > 0:
+				typ = "string"
+			case names[fd.NamedType] != "":
+				typ = names[fd.NamedType]
+			case fd.TypeName == "google.protobuf.Timestamp":
+				comment = " // unix milliseconds"
+			case fd.TypeName == "google.protobuf.Duration":
+				comment = " // milliseconds"
+			case fd.TypeName == "google.protobuf.FieldMask":
+				comment = " // comma-separated field paths"
+			case fd.TypeName == "google.protobuf.Any", fd.TypeName == "google.protobuf.Struct", fd.TypeName == "google.protobuf.Value":
+				comment = " // JSON"
+			case fd.Comment != "":
+				comment = " // " + fd.Comment
+			case typ == "":
+				typ, comment = "binary", " // "+fd.TypeName+" has no Thrift mapping"
+			}
+			label := ""
+			switch {
+			case fd.IsRepeated:
+				typ = "list<" + typ + ">"
+			case fd.MapKey != "":
+				typ = "map<" + thriftTypes[fd.MapKey] + "," + typ + ">"
+			case names[fd.NamedType] != "":
+				label = "optional "
+			}
+			fmt.Fprintf(&b, "  %d: %s%s %s,%s\n", fd.Order, label, typ, fd.Name, comment)
+		}
+		b.WriteString("}\n\n")
+	}
+	return []byte(strings.TrimSuffix(b.String(), "\n")), nil
+}
@@ -0,0 +1,58 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// snippetContext is the number of lines shown on each side of the offending line.
+const snippetContext = 2
+
+// verifyOutput parses rendered proto source and checks that field names and numbers are
+// unique within each message, so a broken schema is never written. The error shows the
+// offending lines.
+func verifyOutput(src string) error {
+	parsed, err := parseProto(src)
+	if err != nil {
+		line := len(splitLines(src))
+		var syntaxErr *protoSyntaxError
+		if errors.As(err, &syntaxErr) && syntaxErr.Line > 0 {
+			line = syntaxErr.Line
+		}
+		return fmt.Errorf("%w\n%s", err, snippet(src, line))
+	}
+
+	for _, msg := range parsed.Messages {
+		names := make(map[string]bool)
+		numbers := make(map[int]string)
+		for _, fd := range msg.Fields {
+			if names[fd.Name] {
+				return fmt.Errorf("%d: message %s: duplicate field name %s\n%s", fd.Line, msg.Name, fd.Name, snippet(src, fd.Line))
+			}
+			if other, ok := numbers[fd.Number]; ok {
+				return fmt.Errorf("%d: message %s: field %s reuses number %d of %s\n%s", fd.Line, msg.Name, fd.Name, fd.Number, other, snippet(src, fd.Line))
+			}
+			names[fd.Name] = true
+			numbers[fd.Number] = fd.Name
+		}
+	}
+	return nil
+}
+
+// snippet returns the lines around line (1-based) of src, numbered, with the line itself marked.
+func snippet(src string, line int) string {
+	lines := splitLines(src)
+	var sb strings.Builder
+	for i := line - snippetContext; i <= line+snippetContext; i++ {
+		if i < 1 || i > len(lines) {
+			continue
+		}
+		marker := " "
+		if i == line {
+			marker = ">"
+		}
+		fmt.Fprintf(&sb, "%s %4d | %s\n", marker, i, lines[i-1])
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
@@ -0,0 +1,40 @@
+package generator
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Version describes the running binary: module version, VCS revision and the Go version
+// it was built with, e.g. "v1.2.0 (revision 3f1ed9e, go1.22.1)".
+func Version() string {
+	version := "(devel)"
+	revision := ""
+	modified := false
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Version != "" {
+			version = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				revision = s.Value
+			case "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+	}
+
+	if revision == "" {
+		return fmt.Sprintf("%s (%s)", version, runtime.Version())
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if modified {
+		revision += "-dirty"
+	}
+	return fmt.Sprintf("%s (revision %s, %s)", version, revision, runtime.Version())
+}
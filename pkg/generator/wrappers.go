@@ -0,0 +1,72 @@
+package generator
+
+import "fmt"
+
+// collapseWrappers removes wrapper messages holding nothing but a single repeated field,
+// like ArrayOfEventField{EventField []*EventField}, and makes the fields referencing them
+// repeated fields of the wrapped type instead. Only wrappers that are referenced, and never
+// by a repeated field (proto has no repeated repeated fields) or a oneof field (oneofs hold
+// no repeated fields), are collapsed. It returns warnings for the wrappers kept for oneofs.
+func collapseWrappers(msgs []*Message, opts *Options) ([]*Message, []*Diagnostic) {
+	var diagnostics []*Diagnostic
+	candidates := make(map[string]*Field)
+	for _, msg := range msgs {
+		if len(msg.Fields) == 1 && msg.Fields[0].IsRepeated {
+			candidates[msg.PkgPath+"."+msg.GoName] = msg.Fields[0]
+		}
+	}
+	wrappers := make(map[string]*Field)
+	kept := make(map[string]bool)
+	for _, msg := range msgs {
+		for _, fd := range msg.Fields {
+			inner, ok := candidates[fd.NamedType]
+			switch {
+			case !ok || kept[fd.NamedType]:
+			case fd.IsRepeated, fd.MapKey != "":
+				opts.debugf("%s.%s: keeping wrapper %s, it is referenced by a repeated or map field", msg.GoName, fd.GoName, fd.NamedType)
+				kept[fd.NamedType] = true
+				delete(wrappers, fd.NamedType)
+			case fd.Oneof != "":
+				kept[fd.NamedType] = true
+				delete(wrappers, fd.NamedType)
+				diagnostics = append(diagnostics, &Diagnostic{
+					Package:  msg.PkgPath,
+					Type:     msg.GoName,
+					Field:    fd.GoName,
+					Code:     CodeKeptWrapper,
+					Message:  fmt.Sprintf("keeping wrapper %s instead of collapsing it: the field is in oneof %s, which can't hold repeated fields", fd.TypeName, fd.Oneof),
+					Severity: SeverityWarning,
+					File:     fd.Pos.Filename,
+					Line:     fd.Pos.Line,
+					Column:   fd.Pos.Column,
+				})
+			default:
+				wrappers[fd.NamedType] = inner
+			}
+		}
+	}
+	if len(wrappers) == 0 {
+		return msgs, diagnostics
+	}
+
+	result := msgs[:0]
+	for _, msg := range msgs {
+		if _, ok := wrappers[msg.PkgPath+"."+msg.GoName]; ok {
+			opts.verbosef("collapsing wrapper message %s into repeated fields", msg.Name)
+			continue
+		}
+		for _, fd := range msg.Fields {
+			inner, ok := wrappers[fd.NamedType]
+			if !ok {
+				continue
+			}
+			opts.debugf("%s.%s: collapsing wrapper %s into repeated %s", msg.GoName, fd.GoName, fd.NamedType, inner.TypeName)
+			fd.TypeName = inner.TypeName
+			fd.NamedType = inner.NamedType
+			fd.EnumValues = inner.EnumValues
+			fd.IsRepeated = true
+		}
+		result = append(result, msg)
+	}
+	return result, diagnostics
+}
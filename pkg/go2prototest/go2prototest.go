@@ -0,0 +1,71 @@
+// Package go2prototest checks the output of go2proto against golden files, so repositories
+// generating their schema from Go types can test that it doesn't drift unexpectedly:
+//
+//	func TestSchema(t *testing.T) {
+//		opts := &generator.Options{Patterns: []string{"./models"}, ProtoPackage: "acme.models"}
+//		go2prototest.Assert(t, nil, opts, "testdata/models.proto")
+//	}
+//
+// Run the tests with GO2PROTO_UPDATE_GOLDEN=1 to write the golden files after an intended
+// change.
+package go2prototest
+
+import (
+	"os"
+	"testing"
+
+	"golang.org/x/tools/go/packages"
+
+	"github.com/beam-cloud/go2proto/pkg/generator"
+)
+
+// UpdateEnv is the environment variable that, when set to a non-empty value, makes Assert
+// write the golden files instead of comparing against them.
+const UpdateEnv = "GO2PROTO_UPDATE_GOLDEN"
+
+// Assert analyses pkgs with opts and fails t with a unified diff if the generated output
+// differs from the golden file. A nil pkgs loads opts.Patterns. The output is reproducible
+// (see Options.Reproducible), so upgrading go2proto alone doesn't change it. Files of other
+// proto packages are compared with <package>.proto files beside golden, as generate writes
+// them.
+func Assert(t testing.TB, pkgs []*packages.Package, opts *generator.Options, golden string) {
+	t.Helper()
+	o := *opts
+	o.Output = golden
+	o.Reproducible = true
+
+	if pkgs == nil {
+		var err error
+		if pkgs, err = generator.Load(&o); err != nil {
+			t.Fatalf("go2prototest: loading packages: %s", err)
+			return
+		}
+	}
+	model, err := generator.Analyze(pkgs, &o)
+	if err != nil {
+		t.Fatalf("go2prototest: analysing packages: %s", err)
+		return
+	}
+	files := generator.Files(model, &o)
+
+	if os.Getenv(UpdateEnv) != "" {
+		refreshed, err := generator.RefreshFiles(files)
+		if err != nil {
+			t.Fatalf("go2prototest: updating golden files: %s", err)
+			return
+		}
+		for _, f := range refreshed {
+			t.Logf("go2prototest: updated %s", f.Path)
+		}
+		return
+	}
+
+	diff, err := generator.CheckFiles(files)
+	if err != nil {
+		t.Fatalf("go2prototest: comparing with golden files: %s", err)
+		return
+	}
+	if diff != "" {
+		t.Errorf("go2prototest: generated output differs from the golden files, run the test with %s=1 to update them:\n%s", UpdateEnv, diff)
+	}
+}
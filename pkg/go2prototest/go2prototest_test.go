@@ -0,0 +1,72 @@
+package go2prototest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/beam-cloud/go2proto/pkg/generator"
+)
+
+// recorder is a testing.TB recording failures instead of failing the test running it.
+type recorder struct {
+	testing.TB
+	errors []string
+	logs   []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Logf(format string, args ...interface{}) {
+	r.logs = append(r.logs, fmt.Sprintf(format, args...))
+}
+
+func options() *generator.Options {
+	return &generator.Options{Patterns: []string{"../generator/testdata/annotated"}, ProtoPackage: "annotated"}
+}
+
+func TestAssert(t *testing.T) {
+	Assert(t, nil, options(), "testdata/annotated.proto")
+}
+
+func TestAssertUpdate(t *testing.T) {
+	golden := filepath.Join(t.TempDir(), "annotated.proto")
+	t.Setenv(UpdateEnv, "1")
+	r := &recorder{TB: t}
+	Assert(r, nil, options(), golden)
+	assert.Empty(t, r.errors)
+	assert.Equal(t, []string{
+		"go2prototest: updated " + filepath.Join(filepath.Dir(golden), "acme.users.v1.proto"),
+		"go2prototest: updated " + golden,
+	}, r.logs)
+
+	want, err := os.ReadFile("testdata/annotated.proto")
+	assert.NoError(t, err)
+	got, err := os.ReadFile(golden)
+	assert.NoError(t, err)
+	assert.Equal(t, string(want), string(got))
+}
+
+func TestAssertMismatch(t *testing.T) {
+	golden := filepath.Join(t.TempDir(), "annotated.proto")
+	assert.NoError(t, os.WriteFile(golden, []byte("syntax = \"proto3\";\n"), 0644))
+	r := &recorder{TB: t}
+	Assert(r, nil, options(), golden)
+	if assert.Len(t, r.errors, 1) {
+		assert.Contains(t, r.errors[0], UpdateEnv+"=1")
+		assert.Contains(t, r.errors[0], "+message ")
+		assert.True(t, strings.Contains(r.errors[0], "--- "+golden))
+	}
+}
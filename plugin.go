@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/beam-cloud/go2proto/pkg/generator"
+)
+
+// pluginPrefix is prepended to plugin names when looking them up in PATH,
+// so "-plugin openapi" runs go2proto-gen-openapi.
+const pluginPrefix = "go2proto-gen-"
+
+// pluginRequest is the JSON document a plugin receives on stdin.
+type pluginRequest struct {
+	ToolVersion  string
+	Parameter    string
+	GoPackage    string
+	ProtoPackage string
+	Messages     []*generator.Message
+	Enums        []*generator.Enum
+}
+
+// pluginResponse is the JSON document a plugin writes to stdout.
+type pluginResponse struct {
+	// Error, if set, fails the run with this message.
+	Error string
+	Files []pluginFile
+}
+
+// pluginFile is a file produced by a plugin, relative to the plugin output directory.
+type pluginFile struct {
+	Name    string
+	Content string
+}
+
+// runPlugin runs the plugin described by spec ("name" or "name=parameter") and writes the
+// files it returns below outDir.
+func runPlugin(spec string, req *pluginRequest, outDir string) ([]string, error) {
+	name := spec
+	if idx := strings.Index(spec, "="); idx >= 0 {
+		name, req.Parameter = spec[:idx], spec[idx+1:]
+	}
+
+	path := name
+	if !strings.ContainsAny(name, `/\`) {
+		found, err := exec.LookPath(pluginPrefix + name)
+		if err != nil {
+			return nil, fmt.Errorf("plugin %s: %s%s not found in PATH", name, pluginPrefix, name)
+		}
+		path = found
+	}
+
+	input, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	verbosef("running plugin %s (%s)", name, path)
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(path)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("plugin %s failed: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var resp pluginResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("plugin %s returned an invalid response: %w", name, err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("plugin %s: %s", name, resp.Error)
+	}
+
+	var written []string
+	for _, file := range resp.Files {
+		clean := filepath.Clean(filepath.FromSlash(file.Name))
+		if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+			return written, fmt.Errorf("plugin %s: file %q is outside the output directory", name, file.Name)
+		}
+		if clean == "." {
+			return written, errors.New("plugin " + name + ": file with empty name")
+		}
+		target := filepath.Join(outDir, clean)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return written, fmt.Errorf("failed to create directory structure: %w", err)
+		}
+		if err := ioutil.WriteFile(target, []byte(file.Content), 0666); err != nil {
+			return written, fmt.Errorf("unable to write file %s: %w", target, err)
+		}
+		written = append(written, target)
+	}
+	return written, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/beam-cloud/go2proto/pkg/generator"
+)
+
+// Values of -color.
+const (
+	colorAuto   = "auto"
+	colorAlways = "always"
+	colorNever  = "never"
+)
+
+// ANSI escape sequences used by renderDiagnostic.
+const (
+	ansiReset  = "\033[0m"
+	ansiBold   = "\033[1m"
+	ansiRed    = "\033[1;31m"
+	ansiYellow = "\033[1;33m"
+	ansiBlue   = "\033[1;34m"
+)
+
+// prettyDiagnostics renders diagnostics with colors and source excerpts instead of log lines;
+// set from -color.
+var prettyDiagnostics bool
+
+// useColor resolves the -color mode: auto enables colors when the log goes to a terminal
+// and NO_COLOR is not set.
+func useColor(mode string) (bool, error) {
+	switch mode {
+	case colorAuto:
+		return log.Writer() == os.Stderr && isTerminal(os.Stderr) && os.Getenv("NO_COLOR") == "", nil
+	case colorAlways:
+		return true, nil
+	case colorNever:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unknown -color %q, expected auto, always or never", mode)
+	}
+}
+
+// sourceLines caches the lines of the files excerpted by renderDiagnostic.
+var sourceLines = make(map[string][]string)
+
+// renderDiagnostic writes d in the style of compiler errors: a colored severity and code,
+// the position relative to root, and the source line with a caret under the column.
+//
+//	warning[invalid-identifier]: field name "message" is not a valid proto identifier
+//	  --> models/user.go:12:2
+//	   |
+//	12 |     Message string
+//	   |     ^
+func renderDiagnostic(w io.Writer, d *generator.Diagnostic, root string) {
+	color := ansiYellow
+	if d.Severity == generator.SeverityError {
+		color = ansiRed
+	}
+	label := d.Severity
+	if d.Code != "" {
+		label += "[" + d.Code + "]"
+	}
+	fmt.Fprintf(w, "%s%s%s%s: %s%s\n", color, label, ansiReset, ansiBold, d.Message, ansiReset)
+
+	if d.File == "" {
+		if name := qualifiedName(d); name != "" {
+			fmt.Fprintf(w, "  %s-->%s %s\n", ansiBlue, ansiReset, name)
+		}
+		return
+	}
+	path := d.File
+	if rel, err := filepath.Rel(root, path); err == nil && !strings.HasPrefix(rel, "..") {
+		path = rel
+	}
+	if d.Line > 0 {
+		path += fmt.Sprintf(":%d:%d", d.Line, d.Column)
+	}
+	source := sourceLine(d.File, d.Line)
+	gutter := strings.Repeat(" ", len(fmt.Sprint(d.Line)))
+	fmt.Fprintf(w, "%s %s-->%s %s\n", gutter, ansiBlue, ansiReset, path)
+	if source == "" {
+		return
+	}
+	fmt.Fprintf(w, "%s %s|%s\n", gutter, ansiBlue, ansiReset)
+	fmt.Fprintf(w, "%s%d |%s %s\n", ansiBlue, d.Line, ansiReset, source)
+	if d.Column > 0 && d.Column <= len(source)+1 {
+		// Keep the tabs of the source line so the caret lines up with the column.
+		indent := strings.Map(func(r rune) rune {
+			if r == '\t' {
+				return r
+			}
+			return ' '
+		}, source[:d.Column-1])
+		fmt.Fprintf(w, "%s %s|%s %s%s^%s\n", gutter, ansiBlue, ansiReset, indent, color, ansiReset)
+	}
+}
+
+// sourceLine returns line n of file, or "" if it can't be read.
+func sourceLine(file string, n int) string {
+	lines, ok := sourceLines[file]
+	if !ok {
+		data, err := os.ReadFile(file)
+		if err == nil {
+			lines = strings.Split(string(data), "\n")
+		}
+		sourceLines[file] = lines
+	}
+	if n < 1 || n > len(lines) {
+		return ""
+	}
+	return strings.TrimRight(lines[n-1], "\r")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+// profiler holds the profiles requested with -cpuprofile, -memprofile and -trace.
+type profiler struct {
+	cpu     *os.File
+	trace   *os.File
+	memPath string
+}
+
+// prof is the running profiler, stopped before go2proto exits.
+var prof *profiler
+
+// startProfiling starts the CPU profile and the execution trace for the paths that are
+// set. The heap profile is only written by stop, once the run is over.
+func startProfiling(cpuPath, memPath, tracePath string) (*profiler, error) {
+	p := &profiler{memPath: memPath}
+	if cpuPath != "" {
+		f, err := os.Create(cpuPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create CPU profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("unable to start CPU profile: %w", err)
+		}
+		p.cpu = f
+	}
+	if tracePath != "" {
+		f, err := os.Create(tracePath)
+		if err != nil {
+			p.stop()
+			return nil, fmt.Errorf("unable to create trace: %w", err)
+		}
+		if err := trace.Start(f); err != nil {
+			f.Close()
+			p.stop()
+			return nil, fmt.Errorf("unable to start trace: %w", err)
+		}
+		p.trace = f
+	}
+	return p, nil
+}
+
+// stop flushes the CPU profile and trace and writes the heap profile. It does nothing on
+// a nil profiler or when called again.
+func (p *profiler) stop() {
+	if p == nil {
+		return
+	}
+	if p.cpu != nil {
+		pprof.StopCPUProfile()
+		p.cpu.Close()
+		p.cpu = nil
+	}
+	if p.trace != nil {
+		trace.Stop()
+		p.trace.Close()
+		p.trace = nil
+	}
+	if p.memPath != "" {
+		path := p.memPath
+		p.memPath = ""
+		f, err := os.Create(path)
+		if err != nil {
+			warnf("unable to create memory profile: %s", err)
+			return
+		}
+		defer f.Close()
+		runtime.GC() // report the live heap at the end of the run
+		if err := pprof.WriteHeapProfile(f); err != nil {
+			warnf("unable to write memory profile: %s", err)
+		}
+	}
+}
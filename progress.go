@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// progressBarWidth is the number of cells in the -progress=bar bar.
+const progressBarWidth = 30
+
+// progressReporter prints analysis progress either as a progress bar redrawn in place or
+// as log lines printed at most once per interval.
+type progressReporter struct {
+	bar      bool
+	interval time.Duration
+	out      io.Writer
+	last     time.Time
+}
+
+// newProgressReporter returns a reporter for the -progress mode, or nil if progress is off.
+// The bar falls back to log lines when the log output is not a terminal.
+func newProgressReporter(mode string, interval time.Duration) (*progressReporter, error) {
+	switch mode {
+	case "":
+		return nil, nil
+	case "log":
+		return &progressReporter{interval: interval}, nil
+	case "bar":
+		if log.Writer() != os.Stderr || !isTerminal(os.Stderr) {
+			return &progressReporter{interval: interval}, nil
+		}
+		return &progressReporter{bar: true, out: os.Stderr}, nil
+	default:
+		return nil, fmt.Errorf("unknown -progress mode %q, expected bar or log", mode)
+	}
+}
+
+// report is the generator's Progress callback.
+func (r *progressReporter) report(done, total int, pkgPath string) {
+	if logLevel == levelQuiet {
+		return
+	}
+	if r.bar {
+		fmt.Fprintf(r.out, "\r\033[K%s", progressBar(done, total, pkgPath))
+		if done == total {
+			fmt.Fprintln(r.out)
+		}
+		return
+	}
+	if done < total && time.Since(r.last) < r.interval {
+		return
+	}
+	r.last = time.Now()
+	infof("analysed %d/%d packages (%s)", done, total, pkgPath)
+}
+
+// progressBar renders a line like "[=========>          ] 12/40 example.com/pkg".
+func progressBar(done, total int, pkgPath string) string {
+	filled := 0
+	if total > 0 {
+		filled = done * progressBarWidth / total
+	}
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+	return fmt.Sprintf("[%s] %d/%d %s", bar, done, total, pkgPath)
+}
+
+// isTerminal reports whether f is a character device, e.g. an interactive terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/beam-cloud/go2proto/pkg/generator"
+)
+
+// runProto2Go implements `go2proto proto2go [-o file.go] [-package name] file.proto`,
+// converting a .proto file into annotated Go structs.
+func runProto2Go(args []string) error {
+	fs := flag.NewFlagSet("proto2go", flag.ContinueOnError)
+	output := fs.String("o", "", "Go output file path. Defaults to stdout.")
+	goPackage := fs.String("package", "", "Go package name of the output. Defaults to the directory name of -o, or the last element of the proto package.")
+	if err := fs.Parse(args); err != nil {
+		return withExitCode(exitUsageError, err)
+	}
+	if fs.NArg() != 1 {
+		return withExitCode(exitUsageError, errors.New("proto2go expects exactly one .proto file"))
+	}
+
+	src, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	name := *goPackage
+	if name == "" {
+		name = proto2GoPackage(*output, string(src))
+	}
+	out, err := generator.ProtoToGo(src, name)
+	if err != nil {
+		return fmt.Errorf("unable to convert %s: %w", fs.Arg(0), err)
+	}
+
+	if *output == "" {
+		_, err := os.Stdout.Write(out)
+		return err
+	}
+	if err := ioutil.WriteFile(*output, out, 0666); err != nil {
+		return err
+	}
+	infof("output file written to ===> %s", *output)
+	return nil
+}
+
+// proto2GoPackage derives a Go package name from the output directory, falling back to the
+// last element of the proto package and finally to "models".
+func proto2GoPackage(output, src string) string {
+	if output != "" {
+		if dir, err := filepath.Abs(filepath.Dir(output)); err == nil {
+			return goIdentifier(filepath.Base(dir))
+		}
+	}
+	for _, line := range strings.Split(src, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "package ") {
+			pkg := strings.TrimSuffix(strings.TrimSpace(strings.TrimPrefix(line, "package ")), ";")
+			return goIdentifier(pkg[strings.LastIndex(pkg, ".")+1:])
+		}
+	}
+	return "models"
+}
+
+// goIdentifier lower-cases name and drops the characters not allowed in a package name.
+func goIdentifier(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' && b.Len() > 0 {
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() == 0 {
+		return "models"
+	}
+	return b.String()
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/beam-cloud/go2proto/pkg/generator"
+)
+
+// registrySchemaTypes maps the output formats a schema registry accepts to their schemaType.
+var registrySchemaTypes = map[string]string{
+	generator.FormatProto:      "PROTOBUF",
+	generator.FormatAvro:       "AVRO",
+	generator.FormatJSONSchema: "JSON",
+}
+
+// registryCompatibilities are the compatibility levels of a Confluent schema registry.
+var registryCompatibilities = []string{
+	"NONE", "BACKWARD", "BACKWARD_TRANSITIVE", "FORWARD", "FORWARD_TRANSITIVE", "FULL", "FULL_TRANSITIVE",
+}
+
+// isRegistryCompatibility reports whether level is one of registryCompatibilities.
+func isRegistryCompatibility(level string) bool {
+	for _, known := range registryCompatibilities {
+		if level == known {
+			return true
+		}
+	}
+	return false
+}
+
+// registryClient talks to the REST API of a Confluent-compatible schema registry.
+type registryClient struct {
+	base     *url.URL
+	user     string
+	password string
+	http     *http.Client
+}
+
+// newRegistryClient returns a client for the registry at rawURL, taking basic auth
+// credentials from its user info.
+func newRegistryClient(rawURL string) (*registryClient, error) {
+	u, err := url.Parse(strings.TrimSuffix(rawURL, "/"))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid -registry-url %q", rawURL)
+	}
+	c := &registryClient{base: u, http: &http.Client{Timeout: 30 * time.Second}}
+	if u.User != nil {
+		c.user = u.User.Username()
+		c.password, _ = u.User.Password()
+		u.User = nil
+	}
+	return c, nil
+}
+
+// do sends body as JSON and decodes the response into result, turning error responses
+// into errors carrying the registry's message.
+func (c *registryClient) do(method, path string, body, result interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, c.base.String()+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var regErr struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(respBody, &regErr) != nil || regErr.Message == "" {
+			regErr.Message = strings.TrimSpace(string(respBody))
+		}
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, regErr.Message)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, result)
+}
+
+// registrySchema is the body of the registry's register and lookup requests.
+type registrySchema struct {
+	SchemaType string              `json:"schemaType"`
+	Schema     string              `json:"schema"`
+	References []registryReference `json:"references,omitempty"`
+}
+
+// registryReference points a schema at the subject version of a schema it imports.
+type registryReference struct {
+	Name    string `json:"name"`
+	Subject string `json:"subject"`
+	Version int    `json:"version"`
+}
+
+// registrySubject expands the {file} and {package} placeholders of a -registry-subject:
+// {file} is the file name up to its first dot, {package} the file's proto package.
+func registrySubject(template string, f *generator.File) string {
+	name := filepath.Base(f.Path)
+	if i := strings.Index(name, "."); i > 0 {
+		name = name[:i]
+	}
+	return strings.NewReplacer("{file}", name, "{package}", f.ProtoPackage).Replace(template)
+}
+
+// publishSchemas registers the written files with the registry, each under its subject,
+// setting the subject's compatibility level first unless compatibility is empty. Proto
+// files are registered after the files they import, which they reference by subject.
+func publishSchemas(c *registryClient, files []*generator.File, subjectTemplate, compatibility string) error {
+	subjects := make(map[string]string, len(files))
+	fileOf := make(map[string]string, len(files))
+	for _, f := range files {
+		name, subject := filepath.Base(f.Path), registrySubject(subjectTemplate, f)
+		if other, ok := fileOf[subject]; ok {
+			return fmt.Errorf("%s and %s both map to subject %q, use {file} in -registry-subject", other, name, subject)
+		}
+		subjects[name], fileOf[subject] = subject, name
+	}
+
+	versions := make(map[string]int, len(files))
+	for _, f := range publishOrder(files) {
+		subject := subjects[filepath.Base(f.Path)]
+		content, err := ioutil.ReadFile(f.Path)
+		if err != nil {
+			return err
+		}
+		schema := &registrySchema{SchemaType: registrySchemaTypes[f.Format], Schema: string(content)}
+		if schema.SchemaType == "" {
+			schema.SchemaType = registrySchemaTypes[generator.FormatProto]
+		}
+		for _, imp := range f.Imports {
+			if ref, ok := subjects[imp]; ok {
+				schema.References = append(schema.References, registryReference{Name: imp, Subject: ref, Version: versions[ref]})
+			}
+		}
+
+		path := "/subjects/" + url.PathEscape(subject)
+		if compatibility != "" {
+			if err := c.do(http.MethodPut, "/config/"+url.PathEscape(subject), map[string]string{"compatibility": compatibility}, nil); err != nil {
+				return err
+			}
+		}
+		var registered struct {
+			ID int `json:"id"`
+		}
+		if err := c.do(http.MethodPost, path+"/versions", schema, &registered); err != nil {
+			return err
+		}
+		// Registering answers with the schema id only; look the schema up for its version.
+		var found struct {
+			Version int `json:"version"`
+		}
+		if err := c.do(http.MethodPost, path, schema, &found); err != nil {
+			return err
+		}
+		versions[subject] = found.Version
+		infof("schema registered as %s version %d (id %d)", subject, found.Version, registered.ID)
+	}
+	return nil
+}
+
+// publishOrder returns the files with every file after the files it imports.
+func publishOrder(files []*generator.File) []*generator.File {
+	byName := make(map[string]*generator.File, len(files))
+	for _, f := range files {
+		byName[filepath.Base(f.Path)] = f
+	}
+	var ordered []*generator.File
+	visited := make(map[*generator.File]bool, len(files))
+	var visit func(f *generator.File)
+	visit = func(f *generator.File) {
+		if visited[f] {
+			return
+		}
+		visited[f] = true
+		for _, imp := range f.Imports {
+			if dep, ok := byName[imp]; ok {
+				visit(dep)
+			}
+		}
+		ordered = append(ordered, f)
+	}
+	for _, f := range files {
+		visit(f)
+	}
+	return ordered
+}
+
+// bufPushCommand returns the buf invocation pushing the module in the current directory
+// to the Buf Schema Registry.
+func bufPushCommand() []string {
+	return []string{"buf", "push"}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"text/tabwriter"
+	"time"
+
+	"github.com/beam-cloud/go2proto/pkg/generator"
+)
+
+// logSkipped prints warnings for suspicious skipped items, and every other item unless
+// they are written to a report instead.
+func logSkipped(items []*generator.SkippedItem, all bool) {
+	for _, item := range items {
+		switch {
+		case item.Warning && (*diagFormat != diagFormatText || prettyDiagnostics):
+			reportDiagnostic(skippedDiagnostic(item))
+		case item.Warning:
+			warnf("skipped %s", item)
+		case all:
+			infof("skipped %s", item)
+		}
+	}
+}
+
+// writeSkippedReport writes the skipped items as a JSON array to path.
+func writeSkippedReport(items []*generator.SkippedItem, path string) error {
+	if items == nil {
+		items = []*generator.SkippedItem{}
+	}
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0666); err != nil {
+		return fmt.Errorf("unable to write report %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeGraph writes the model's reference graph in DOT format to path.
+func writeGraph(model *generator.Model, path string) error {
+	var buf bytes.Buffer
+	if err := generator.WriteGraph(&buf, model); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0666); err != nil {
+		return fmt.Errorf("unable to write graph %s: %w", path, err)
+	}
+	return nil
+}
+
+// listTypes prints a table of the messages, enums and services in model with the number of
+// fields, values or methods of each.
+func listTypes(w io.Writer, model *generator.Model) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tNAME\tPACKAGE\tFIELDS")
+	for _, msg := range model.Messages {
+		fmt.Fprintf(tw, "message\t%s\t%s\t%d\n", msg.Name, msg.PkgPath, len(msg.Fields))
+	}
+	for _, enum := range model.Enums {
+		fmt.Fprintf(tw, "enum\t%s\t%s\t%d\n", enum.Name, enum.PkgPath, len(enum.Values))
+	}
+	for _, svc := range model.Services {
+		fmt.Fprintf(tw, "service\t%s\t%s\t%d\n", svc.Name, svc.PkgPath, len(svc.Methods))
+	}
+	return tw.Flush()
+}
+
+// runStats summarises a generation run.
+type runStats struct {
+	start           time.Time
+	warningsAtStart int64
+
+	packages  int
+	messages  int
+	enums     int
+	fields    int
+	skipped   int
+	written   int
+	unchanged int
+	checked   int
+
+	timings generator.Timings
+}
+
+// collect records the sizes of the analysed model.
+func (s *runStats) collect(model *generator.Model) {
+	s.messages = len(model.Messages)
+	s.enums = len(model.Enums)
+	for _, msg := range model.Messages {
+		s.fields += len(msg.Fields)
+	}
+	s.skipped = len(model.Skipped)
+}
+
+// String formats the summary, e.g. "summary: 2 packages, 6 types matched (5 messages, 1 enums), ...",
+// followed by the time spent in each phase.
+func (s *runStats) String() string {
+	output := fmt.Sprintf("%d files written", s.written)
+	if s.unchanged > 0 {
+		output += fmt.Sprintf(" (%d unchanged)", s.unchanged)
+	}
+	if s.checked > 0 {
+		output = fmt.Sprintf("%d files checked", s.checked)
+	}
+	summary := fmt.Sprintf("summary: %d packages, %d types matched (%d messages, %d enums), %d fields, %d skipped, %d warnings, %s in %s",
+		s.packages, s.messages+s.enums, s.messages, s.enums, s.fields, s.skipped,
+		warningCount()-s.warningsAtStart, output, time.Since(s.start).Round(time.Millisecond))
+	if len(s.timings) > 0 {
+		summary += fmt.Sprintf(" (%s)", s.timings)
+	}
+	return summary
+}
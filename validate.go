@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/beam-cloud/go2proto/pkg/generator"
+)
+
+// validatorCommands returns the commands that check a generated file with the given tool.
+func validatorCommands(tool, path string) ([][]string, error) {
+	switch tool {
+	case "protoc":
+		return [][]string{{"protoc", "-I", filepath.Dir(path), "--descriptor_set_out=" + os.DevNull, filepath.Base(path)}}, nil
+	case "buf":
+		return [][]string{{"buf", "build", path}, {"buf", "lint", path}}, nil
+	default:
+		return nil, fmt.Errorf("unknown validator %q, expected protoc, buf or auto", tool)
+	}
+}
+
+// resolveValidator picks the tool for "auto": protoc if it is installed, buf otherwise.
+func resolveValidator(tool string) (string, error) {
+	if tool != "auto" {
+		return tool, nil
+	}
+	for _, candidate := range []string{"protoc", "buf"} {
+		if _, err := exec.LookPath(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", errors.New("-validate needs protoc or buf in PATH")
+}
+
+// validateOutput runs the validator over every written file and returns the tool's
+// output for the ones it rejects.
+func validateOutput(files []*generator.File, tool string) error {
+	tool, err := resolveValidator(tool)
+	if err != nil {
+		return err
+	}
+
+	var failures []string
+	for _, f := range files {
+		commands, err := validatorCommands(tool, f.Path)
+		if err != nil {
+			return err
+		}
+		for _, args := range commands {
+			verbosef("validating %s: %s", f.Path, strings.Join(args, " "))
+			cmd := exec.Command(args[0], args[1:]...)
+			if tool == "protoc" {
+				cmd.Dir = filepath.Dir(f.Path)
+			}
+			var out bytes.Buffer
+			cmd.Stdout = &out
+			cmd.Stderr = &out
+			if err := cmd.Run(); err != nil {
+				var exitErr *exec.ExitError
+				if !errors.As(err, &exitErr) {
+					return fmt.Errorf("unable to run %s: %w", args[0], err)
+				}
+				failures = append(failures, fmt.Sprintf("%s rejected %s:\n%s", strings.Join(args[:2], " "), f.Path, strings.TrimSpace(out.String())))
+				break
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "\n"))
+	}
+	return nil
+}
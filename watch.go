@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// watch regenerates the output whenever a Go source file in one of the analysed
+// package directories is added, removed or modified. It never returns.
+func watch(pwd string, interval time.Duration) {
+	var dirs []string
+	var snapshot map[string]time.Time
+
+	for {
+		pkgs, err := generate(pwd)
+		if err != nil {
+			logError(err)
+		}
+		// Keep watching the previous directories if the packages failed to load.
+		if pkgDirs := packageDirs(pkgs); len(pkgDirs) > 0 {
+			dirs = pkgDirs
+		}
+		if len(dirs) == 0 {
+			fatalf("no package directories to watch")
+		}
+		snapshot = scanSources(dirs)
+
+		infof("watching %d package directories for changes...", len(dirs))
+		for {
+			time.Sleep(interval)
+			current := scanSources(dirs)
+			if !sameSnapshot(snapshot, current) {
+				break
+			}
+		}
+	}
+}
+
+// packageDirs returns the de-duplicated directories holding the packages' Go files.
+func packageDirs(pkgs []*packages.Package) []string {
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, p := range pkgs {
+		for _, f := range p.GoFiles {
+			dir := filepath.Dir(f)
+			if !seen[dir] {
+				seen[dir] = true
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return dirs
+}
+
+// scanSources records the modification time of every Go file in dirs.
+func scanSources(dirs []string) map[string]time.Time {
+	result := make(map[string]time.Time)
+	for _, dir := range dirs {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, info := range infos {
+			if info.IsDir() || !strings.HasSuffix(info.Name(), ".go") {
+				continue
+			}
+			result[filepath.Join(dir, info.Name())] = info.ModTime()
+		}
+	}
+	return result
+}
+
+// sameSnapshot reports whether two scans saw the same files with the same modification times.
+func sameSnapshot(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, mod := range a {
+		if other, ok := b[path]; !ok || !other.Equal(mod) {
+			return false
+		}
+	}
+	return true
+}